@@ -79,6 +79,22 @@ func (is *InsertStatement) String() string {
 	return out.String()
 }
 
+type ReindexStatement struct {
+	Token token.Token
+}
+
+func (rs *ReindexStatement) statementNode()       {}
+func (rs *ReindexStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReindexStatement) String() string       { return rs.TokenLiteral() }
+
+type VacuumStatement struct {
+	Token token.Token
+}
+
+func (vs *VacuumStatement) statementNode()       {}
+func (vs *VacuumStatement) TokenLiteral() string { return vs.Token.Literal }
+func (vs *VacuumStatement) String() string       { return vs.TokenLiteral() }
+
 type Identifier struct {
 	Token token.Token
 	Value string
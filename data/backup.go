@@ -0,0 +1,154 @@
+package data
+
+import (
+	"fmt"
+	"os"
+)
+
+/*
+Backup copies a database from one pager to another a few pages at a time, so a live database can be
+backed up without holding up its writer for long.
+
+Pages are copied as src has them, including changes that have not been flushed yet. If src changes
+between two steps the copy starts again from the first page, so a finished backup is always a single
+point in time. Pagers are not safe for concurrent use, so Step must be called from the goroutine
+that writes to src.
+*/
+type Backup struct {
+	dst         Pager
+	src         Pager
+	changeCount uint64
+	numPages    uint32
+	nextPage    uint32
+	done        bool
+	// filename is only set for backups made by NewBackupFile
+	filename string
+	// Restarts is the number of times the copy has started again because src changed
+	Restarts int
+}
+
+// NewBackup prepares to copy src into dst, which must use the same page size. Anything already in
+// dst is replaced.
+func NewBackup(dst Pager, src Pager) (*Backup, error) {
+	if dst.PageSize() != src.PageSize() {
+		return nil, fmt.Errorf("backup page size %d does not match the database page size %d", dst.PageSize(), src.PageSize())
+	}
+	b := &Backup{dst: dst, src: src}
+	b.restart()
+	return b, nil
+}
+
+func (b *Backup) restart() {
+	b.changeCount = b.src.ChangeCount()
+	b.numPages = b.src.GetNextUnusedPageNum()
+	b.nextPage = 0
+}
+
+// Step copies up to n more pages, or every page left if n is negative. Once the last page has been
+// copied any pages dst has past the end of src are dropped, dst is flushed and Step returns true.
+func (b *Backup) Step(n int) (bool, error) {
+	if b.src.ChangeCount() != b.changeCount {
+		b.Restarts++
+		b.restart()
+	}
+
+	for ; n != 0 && b.nextPage < b.numPages; n-- {
+		srcPage, err := b.src.Page(b.nextPage)
+		if err != nil {
+			return false, err
+		}
+		dstPage, err := b.dst.Page(b.nextPage)
+		if err != nil {
+			return false, err
+		}
+		if err := b.dst.MarkDirty(b.nextPage); err != nil {
+			return false, err
+		}
+		copy(*dstPage, *srcPage)
+		b.nextPage++
+	}
+
+	if b.nextPage < b.numPages {
+		return false, nil
+	}
+	if b.dst.GetNextUnusedPageNum() > b.numPages {
+		if err := b.dst.Truncate(b.numPages); err != nil {
+			return false, err
+		}
+	}
+	if err := b.dst.Flush(); err != nil {
+		return false, err
+	}
+	b.done = true
+	return true, nil
+}
+
+// Remaining returns the number of pages still to be copied
+func (b *Backup) Remaining() uint32 {
+	return b.numPages - b.nextPage
+}
+
+// PageCount returns the number of pages in the database being copied
+func (b *Backup) PageCount() uint32 {
+	return b.numPages
+}
+
+// NewBackupFile creates a new db file at filename and prepares to copy src into it. filename must not
+// already exist. The copy is made by calling Step, so src can be written to between steps, then Close,
+// which removes the file again unless the copy was finished.
+//
+// The file is opened with opts, so giving a key encrypts the backup. Without one the file's page size
+// is src's, with one opts.PageSize must be the size of the encrypted file's pages.
+func NewBackupFile(src Pager, filename string, opts Options) (*Backup, error) {
+	if _, err := os.Stat(filename); err == nil {
+		return nil, fmt.Errorf("%s already exists", filename)
+	}
+	if opts.Key == nil {
+		opts.PageSize = src.PageSize()
+	}
+	opts.Mmap, opts.ReadOnly = false, false
+	dst, err := Open(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := NewBackup(dst, src)
+	if err != nil {
+		dst.Close()
+		RemoveDBFile(filename)
+		return nil, err
+	}
+	b.filename = filename
+	return b, nil
+}
+
+// Close closes the file of a backup made by NewBackupFile, removing it unless the copy was finished
+func (b *Backup) Close() error {
+	if b.filename == "" {
+		return nil
+	}
+	err := b.dst.Close()
+	if err != nil || !b.done {
+		RemoveDBFile(b.filename)
+	}
+	return err
+}
+
+// BackupToFile copies src into a new db file, stepSize pages at a time or all at once if stepSize is
+// not positive, see NewBackupFile. Nothing can write to src until it returns.
+func BackupToFile(src Pager, filename string, opts Options, stepSize int) error {
+	if stepSize <= 0 {
+		stepSize = -1
+	}
+	b, err := NewBackupFile(src, filename, opts)
+	if err != nil {
+		return err
+	}
+	for done := false; !done && err == nil; {
+		done, err = b.Step(stepSize)
+	}
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
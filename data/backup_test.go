@@ -0,0 +1,195 @@
+package data
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func checkSamePages(t *testing.T, expected Pager, actual Pager) {
+	t.Helper()
+	if expected.GetNextUnusedPageNum() != actual.GetNextUnusedPageNum() {
+		t.Fatalf("incorrect number of pages, expected %d, got %d", expected.GetNextUnusedPageNum(), actual.GetNextUnusedPageNum())
+	}
+	for pageNum := uint32(0); pageNum < expected.GetNextUnusedPageNum(); pageNum++ {
+		expectedPage, _ := expected.Page(pageNum)
+		actualPage, err := actual.Page(pageNum)
+		if err != nil {
+			t.Fatalf("unexpected error reading page %d, got %+v", pageNum, err)
+		}
+		if !bytes.Equal((*expectedPage)[:expectedPage.UsableSize()], (*actualPage)[:actualPage.UsableSize()]) {
+			t.Errorf("incorrect contents of page %d", pageNum)
+		}
+	}
+}
+
+func TestBackupInSteps(t *testing.T) {
+	src := &MemoryPager{}
+	writePages(src, 10, 0x1)
+	dst := &MemoryPager{}
+
+	backup, err := NewBackup(dst, src)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if done, err := backup.Step(3); done || err != nil {
+			t.Fatalf("unexpected result from step %d, got %t, %+v", i, done, err)
+		}
+	}
+	if backup.Remaining() != 1 {
+		t.Errorf("incorrect number of pages remaining, expected %d, got %d", 1, backup.Remaining())
+	}
+	if done, err := backup.Step(3); !done || err != nil {
+		t.Fatalf("unexpected result from last step, got %t, %+v", done, err)
+	}
+
+	checkSamePages(t, src, dst)
+	if backup.Restarts != 0 {
+		t.Errorf("incorrect number of restarts, expected %d, got %d", 0, backup.Restarts)
+	}
+}
+
+func TestBackupRestartsWhenSourceChanges(t *testing.T) {
+	src := &MemoryPager{}
+	writePages(src, 10, 0x1)
+	dst := &MemoryPager{}
+
+	backup, _ := NewBackup(dst, src)
+	backup.Step(5)
+
+	// The writer carries on between steps, changing a page already copied and adding more
+	writePages(src, 12, 0x2)
+
+	if done, err := backup.Step(-1); !done || err != nil {
+		t.Fatalf("unexpected result, got %t, %+v", done, err)
+	}
+	if backup.Restarts != 1 {
+		t.Errorf("incorrect number of restarts, expected %d, got %d", 1, backup.Restarts)
+	}
+	checkSamePages(t, src, dst)
+}
+
+func TestBackupToFile(t *testing.T) {
+	for _, pt := range pagerTypes {
+		dir := t.TempDir()
+		src, err := Open(filepath.Join(dir, "test.db"), pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(src, 100, 0x1)
+		src.Flush()
+		// Changes not yet flushed are part of the backup too
+		writePages(src, 110, 0x2)
+
+		filename := filepath.Join(dir, "backup.db")
+		if err := BackupToFile(src, filename, Options{}, 7); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		dst, err := Open(filename, Options{})
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		checkSamePages(t, src, dst)
+		dst.Close()
+
+		if err := BackupToFile(src, filename, Options{}, 7); err == nil {
+			t.Errorf("%s: expected an error backing up over an existing file", pt.name)
+		}
+		src.Close()
+	}
+}
+
+func TestBackupFileRestartsWhenSourceChanges(t *testing.T) {
+	for _, pt := range pagerTypes {
+		dir := t.TempDir()
+		src, err := Open(filepath.Join(dir, "test.db"), pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(src, 100, 0x1)
+		src.Flush()
+
+		filename := filepath.Join(dir, "backup.db")
+		backup, err := NewBackupFile(src, filename, Options{})
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		for i := 0; i < 3; i++ {
+			if done, err := backup.Step(7); done || err != nil {
+				t.Fatalf("%s: unexpected result from step %d, got %t, %+v", pt.name, i, done, err)
+			}
+		}
+
+		// The writer carries on between steps, changing pages already copied and adding more
+		writePages(src, 110, 0x2)
+		if err := src.Flush(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		for done := false; !done; {
+			if done, err = backup.Step(7); err != nil {
+				t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+			}
+		}
+		if backup.Restarts != 1 {
+			t.Errorf("%s: incorrect number of restarts, expected %d, got %d", pt.name, 1, backup.Restarts)
+		}
+		if err := backup.Close(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		dst, err := Open(filename, Options{})
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		checkSamePages(t, src, dst)
+		dst.Close()
+		src.Close()
+	}
+}
+
+func TestUnfinishedBackupFileIsRemoved(t *testing.T) {
+	src := &MemoryPager{}
+	writePages(src, 10, 0x1)
+	filename := filepath.Join(t.TempDir(), "backup.db")
+	backup, err := NewBackupFile(src, filename, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	backup.Step(3)
+	if err := backup.Close(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("expected the unfinished backup to be removed")
+	}
+}
+
+func TestBackupPageSizeMismatch(t *testing.T) {
+	if _, err := NewBackup(NewMemoryPager(MinPageSize), &MemoryPager{}); err == nil {
+		t.Errorf("expected an error for different page sizes")
+	}
+}
+
+func TestBackupTruncatesLargerDestination(t *testing.T) {
+	for _, pt := range pagerTypes {
+		dst, err := Open(filepath.Join(t.TempDir(), "test.db"), pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(dst, 30, 0x1)
+		dst.Flush()
+
+		src := &MemoryPager{}
+		writePages(src, 10, 0x2)
+		backup, _ := NewBackup(dst, src)
+		if done, err := backup.Step(-1); !done || err != nil {
+			t.Fatalf("%s: unexpected result, got %t, %+v", pt.name, done, err)
+		}
+		checkSamePages(t, src, dst)
+		dst.Close()
+	}
+}
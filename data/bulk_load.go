@@ -0,0 +1,150 @@
+package data
+
+import "fmt"
+
+// TreeEntry is a key and the item stored against it
+type TreeEntry struct {
+	Key  uint64
+	Item IndexItem
+}
+
+// builtNode is a node written by BulkLoad, waiting for its parent to be written
+type builtNode struct {
+	pageNum uint32
+	maxKey  uint64
+}
+
+/*
+BulkLoad builds a tree rooted at rootPageNum out of entries, which must be in ascending key order.
+Rather than inserting the keys one at a time it writes the leaves from left to right, then each level
+of internal nodes above them, so every node is as full as it can be and no page is written twice
+other than to set parent pointers. The entries are shared evenly between the nodes of each level, so
+the last node is not left nearly empty.
+
+The root page must already have been allocated from pager. Whatever was in it is overwritten, and
+any other pages of a tree already rooted there are not freed.
+*/
+func BulkLoad(pager Pager, rootPageNum uint32, entries []TreeEntry) (*Tree, error) {
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Key <= entries[i-1].Key {
+			return nil, fmt.Errorf("entries must be in ascending key order, %d is after %d", entries[i].Key, entries[i-1].Key)
+		}
+	}
+
+	t := NewTree(pager, rootPageNum)
+	level, err := t.loadLeaves(entries)
+	if err != nil {
+		return nil, err
+	}
+	for len(level) > 1 {
+		if level, err = t.loadInternalNodes(level); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// evenShares divides total cells between as few nodes holding at most maxPerNode each as possible, as
+// evenly as possible, returning the number for each node. There is always at least one node.
+func evenShares(total int, maxPerNode int) []int {
+	numNodes := (total + maxPerNode - 1) / maxPerNode
+	if numNodes == 0 {
+		numNodes = 1
+	}
+	shares := make([]int, numNodes)
+	for i := range shares {
+		shares[i] = total / numNodes
+		if i < total%numNodes {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+// levelPage returns a page for a node of a level of numNodes nodes, which is the root page if the
+// node is the only one
+func (t *Tree) levelPage(numNodes int) (uint32, error) {
+	if numNodes == 1 {
+		return t.rootPageNum, nil
+	}
+	return t.pager.AllocatePage()
+}
+
+// loadLeaves writes entries to a row of linked leaves
+func (t *Tree) loadLeaves(entries []TreeEntry) ([]builtNode, error) {
+	shares := evenShares(len(entries), int(LeafNodeMaxCells(t.pager.PageSize())))
+	built := make([]builtNode, 0, len(shares))
+	pageNum, err := t.levelPage(len(shares))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, share := range shares {
+		next := uint32(0)
+		if i+1 < len(shares) {
+			if next, err = t.pager.AllocatePage(); err != nil {
+				return nil, err
+			}
+		}
+		n, err := t.dirtyNode(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		leaf := NewLeaf(n.page)
+		leaf.SetIsRoot(pageNum == t.rootPageNum)
+		leaf.SetParentPointer(0)
+		for j, e := range entries[:share] {
+			leaf.SetNodeKey(uint16(j), e.Key)
+			leaf.SetNodeValue(uint16(j), e.Item)
+		}
+		leaf.SetNumCells(uint16(share))
+		leaf.SetNextLeaf(next)
+
+		maxKey := uint64(0)
+		if share > 0 {
+			maxKey = entries[share-1].Key
+		}
+		built = append(built, builtNode{pageNum: pageNum, maxKey: maxKey})
+		entries = entries[share:]
+		pageNum = next
+	}
+	return built, nil
+}
+
+// loadInternalNodes writes the level of internal nodes above children
+func (t *Tree) loadInternalNodes(children []builtNode) ([]builtNode, error) {
+	shares := evenShares(len(children), int(InternalNodeMaxCells(t.pager.PageSize()))+1)
+	built := make([]builtNode, 0, len(shares))
+	for _, share := range shares {
+		pageNum, err := t.levelPage(len(shares))
+		if err != nil {
+			return nil, err
+		}
+		n, err := t.dirtyNode(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		internal := NewInternal(n.page)
+		internal.SetIsRoot(pageNum == t.rootPageNum)
+		internal.SetParentPointer(0)
+
+		// Each child but the last has its largest key in a cell, the last is the right child
+		nodeChildren := children[:share]
+		internal.SetNumKeys(uint16(share - 1))
+		childPageNums := make([]uint32, share)
+		for i, child := range nodeChildren {
+			internal.SetChildPointer(uint16(i), child.pageNum)
+			if i < share-1 {
+				internal.SetInternalKey(uint16(i), child.maxKey)
+			}
+			childPageNums[i] = child.pageNum
+		}
+		built = append(built, builtNode{pageNum: pageNum, maxKey: nodeChildren[share-1].maxKey})
+		children = children[share:]
+
+		if err := t.setParent(childPageNums, pageNum); err != nil {
+			return nil, err
+		}
+	}
+	return built, nil
+}
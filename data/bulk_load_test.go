@@ -0,0 +1,117 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func testEntries(numEntries int) []TreeEntry {
+	entries := make([]TreeEntry, numEntries)
+	for i := range entries {
+		key := uint64(i * 3)
+		entries[i] = TreeEntry{Key: key, Item: IndexItem{uint32(key), 0, uint32(key) + 1}}
+	}
+	return entries
+}
+
+func TestBulkLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	filePager, _ := OpenFilePager(filename, Options{PageSize: MinPageSize, CacheSize: MinCacheSize})
+	defer filePager.Close()
+
+	maxCells := int(LeafNodeMaxCells(MinPageSize))
+	tests := []struct {
+		name       string
+		pager      Pager
+		numEntries int
+	}{
+		{"empty", NewMemoryPager(MinPageSize), 0},
+		{"one", NewMemoryPager(MinPageSize), 1},
+		{"one full leaf", NewMemoryPager(MinPageSize), maxCells},
+		{"two leaves", NewMemoryPager(MinPageSize), maxCells + 1},
+		{"three levels", NewMemoryPager(MinPageSize), 100000},
+		{"small cache", filePager, 20000},
+	}
+
+	for _, test := range tests {
+		test.pager.Page(0)
+		rootPageNum, _ := test.pager.AllocatePage()
+		entries := testEntries(test.numEntries)
+		tree, err := BulkLoad(test.pager, rootPageNum, entries)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", test.name, err)
+		}
+
+		next := 0
+		problems := tree.Check(func(key uint64, item IndexItem) {
+			if next >= len(entries) || key != entries[next].Key || item != entries[next].Item {
+				t.Errorf("%s: incorrect key visited, expected entry %d, got %d, %+v", test.name, next, key, item)
+			}
+			next++
+		})
+		if len(problems) != 0 {
+			t.Errorf("%s: unexpected problems, got %+v", test.name, problems)
+		}
+		if next != len(entries) {
+			t.Errorf("%s: incorrect number of keys, expected %d, got %d", test.name, len(entries), next)
+		}
+
+		// The leaves are packed, so there are as few as the entries fit in
+		numLeaves := 0
+		pages, _ := tree.Pages()
+		for _, pageNum := range pages {
+			if n, _ := tree.node(pageNum); n.Type() == LeafNode {
+				numLeaves++
+			}
+		}
+		if expected := (test.numEntries + maxCells - 1) / maxCells; numLeaves != expected && test.numEntries > 0 {
+			t.Errorf("%s: incorrect number of leaves, expected %d, got %d", test.name, expected, numLeaves)
+		}
+	}
+}
+
+func TestBulkLoadedTreeCanBeChanged(t *testing.T) {
+	freeList := newTestFreeList(NewMemoryPager(MinPageSize))
+	rootPageNum, _ := freeList.AllocatePage()
+	entries := testEntries(5000)
+	tree, err := BulkLoad(freeList, rootPageNum, entries)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	// Fill the gaps between the keys, splitting the full leaves, then remove the original keys
+	for i := uint64(0); i < 15000; i++ {
+		if i%3 != 0 {
+			if err := tree.Insert(i, IndexItem{uint32(i), 0, 1}); err != nil {
+				t.Fatalf("unexpected error inserting %d, got %+v", i, err)
+			}
+		}
+	}
+	for _, e := range entries {
+		if err := tree.Delete(e.Key); err != nil {
+			t.Fatalf("unexpected error deleting %d, got %+v", e.Key, err)
+		}
+	}
+
+	next := uint64(1)
+	problems := tree.Check(func(key uint64, item IndexItem) {
+		if key != next {
+			t.Errorf("incorrect key visited, expected %d, got %d", next, key)
+		}
+		if next++; next%3 == 0 {
+			next++
+		}
+	})
+	if len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+}
+
+func TestBulkLoadRejectsUnsortedEntries(t *testing.T) {
+	pager := NewMemoryPager(MinPageSize)
+	pager.Page(0)
+	entries := []TreeEntry{{Key: 1}, {Key: 3}, {Key: 3}}
+	if _, err := BulkLoad(pager, 1, entries); err == nil {
+		t.Errorf("expected an error for a repeated key")
+	}
+}
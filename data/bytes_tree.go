@@ -0,0 +1,697 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Byte trees
+++++++++++
+A BytesTree is a b-tree like Tree, but with keys and values that are byte slices of any length. Its
+nodes are slotted pages: a header, then an array of slots holding the offsets of the cells, which are
+packed at the end of the page. Cells of different sizes then share a page, and the slots keep them in
+key order without the cells having to move.
+
+Header: NodeType, IsRoot, ParentPointer (unused), NumCells, ContentStart, Link
+Leaf cell: KeyLength, ValueLength, OverflowPage, Key, Value
+Internal cell: Child, KeyLength, Key
+
+Link is the next leaf of a leaf, and the right child of an internal node. As in Tree, the key of an
+internal cell is the largest key its child can hold, and the right child holds the keys after the
+last one.
+
+No cell may take more than a quarter of a page, so a node can always be split into two that fit, or
+merged with a neighbour that has room. Keys are limited to fit in that, see MaxBytesKeySize, and a
+value that would not fit is written to a chain of overflow pages instead. Each overflow page starts
+with the number of the next one.
+*/
+const (
+	SlottedNumCellsOffset     = int(GenericHeaderSize)
+	SlottedNumCellsSize       = 2
+	SlottedContentStartOffset = SlottedNumCellsOffset + SlottedNumCellsSize
+	SlottedContentStartSize   = 2
+	SlottedLinkOffset         = SlottedContentStartOffset + SlottedContentStartSize
+	SlottedLinkSize           = 4
+	SlottedHeaderSize         = SlottedLinkOffset + SlottedLinkSize
+	SlotSize                  = 2
+
+	BytesLeafKeyLengthOffset     = 0
+	BytesLeafValueLengthOffset   = BytesLeafKeyLengthOffset + 2
+	BytesLeafOverflowOffset      = BytesLeafValueLengthOffset + 4
+	BytesLeafCellHeaderSize      = BytesLeafOverflowOffset + 4
+	BytesInternalChildOffset     = 0
+	BytesInternalKeyLengthOffset = BytesInternalChildOffset + 4
+	BytesInternalCellHeaderSize  = BytesInternalKeyLengthOffset + 2
+
+	OverflowNextSize = 4
+)
+
+// Comparator orders keys, returning a negative number if a comes before b, zero if they are the same
+// key and a positive number if a comes after b
+type Comparator func(a, b []byte) int
+
+// slottedCapacity is the space for slots and cells in a node on a page of pageSize bytes
+func slottedCapacity(pageSize int) int {
+	return pageSize - PageReservedSize - SlottedHeaderSize
+}
+
+// maxCellSize is the most space, slot included, a cell may take on a page of pageSize bytes
+func maxCellSize(pageSize int) int {
+	return slottedCapacity(pageSize) / 4
+}
+
+// MaxBytesKeySize is the longest key a BytesTree on pages of pageSize bytes can hold
+func MaxBytesKeySize(pageSize int) int {
+	return maxCellSize(pageSize) - SlotSize - BytesLeafCellHeaderSize
+}
+
+func leafCellKey(cell []byte) []byte {
+	keyLength := int(binary.LittleEndian.Uint16(cell[BytesLeafKeyLengthOffset:]))
+	return cell[BytesLeafCellHeaderSize : BytesLeafCellHeaderSize+keyLength]
+}
+
+func leafCellValueLength(cell []byte) uint32 {
+	return binary.LittleEndian.Uint32(cell[BytesLeafValueLengthOffset:])
+}
+
+func leafCellOverflow(cell []byte) uint32 {
+	return binary.LittleEndian.Uint32(cell[BytesLeafOverflowOffset:])
+}
+
+// leafCellSize returns the size of the leaf cell that starts cell, which may run on into other cells
+func leafCellSize(cell []byte) int {
+	size := BytesLeafCellHeaderSize + len(leafCellKey(cell))
+	if leafCellOverflow(cell) == 0 {
+		size += int(leafCellValueLength(cell))
+	}
+	return size
+}
+
+func newInternalCell(child uint32, key []byte) []byte {
+	cell := make([]byte, BytesInternalCellHeaderSize+len(key))
+	binary.LittleEndian.PutUint32(cell[BytesInternalChildOffset:], child)
+	binary.LittleEndian.PutUint16(cell[BytesInternalKeyLengthOffset:], uint16(len(key)))
+	copy(cell[BytesInternalCellHeaderSize:], key)
+	return cell
+}
+
+func internalCellChild(cell []byte) uint32 {
+	return binary.LittleEndian.Uint32(cell[BytesInternalChildOffset:])
+}
+
+func internalCellKey(cell []byte) []byte {
+	keyLength := int(binary.LittleEndian.Uint16(cell[BytesInternalKeyLengthOffset:]))
+	return cell[BytesInternalCellHeaderSize : BytesInternalCellHeaderSize+keyLength]
+}
+
+// cellsSize is the space cells take in a node, slots included
+func cellsSize(cells [][]byte) int {
+	size := 0
+	for _, cell := range cells {
+		size += len(cell) + SlotSize
+	}
+	return size
+}
+
+func insertCell(cells [][]byte, index int, cell []byte) [][]byte {
+	cells = append(cells, nil)
+	copy(cells[index+1:], cells[index:])
+	cells[index] = cell
+	return cells
+}
+
+func removeCell(cells [][]byte, index int) [][]byte {
+	return append(cells[:index], cells[index+1:]...)
+}
+
+// bytesNode is a node read out of its page, so it can be changed freely and then written back
+type bytesNode struct {
+	pageNum uint32
+	leaf    bool
+	cells   [][]byte
+	link    uint32
+}
+
+func (n *bytesNode) key(index int) []byte {
+	if n.leaf {
+		return leafCellKey(n.cells[index])
+	}
+	return internalCellKey(n.cells[index])
+}
+
+// child returns the child pointer at index, with len(n.cells) being the right child
+func (n *bytesNode) child(index int) uint32 {
+	if index == len(n.cells) {
+		return n.link
+	}
+	return internalCellChild(n.cells[index])
+}
+
+func (n *bytesNode) setChild(index int, child uint32) {
+	if index == len(n.cells) {
+		n.link = child
+		return
+	}
+	binary.LittleEndian.PutUint32(n.cells[index][BytesInternalChildOffset:], child)
+}
+
+// bytesPathEntry is an internal node passed through on the way down the tree, and the child taken
+type bytesPathEntry struct {
+	pageNum uint32
+	index   int
+}
+
+/*
+BytesTree is a b-tree of byte slice keys and values, ordered by a Comparator. Nodes do not keep
+parent pointers, instead each change records the path to the leaf it starts at.
+*/
+type BytesTree struct {
+	pager       Pager
+	rootPageNum uint32
+	compare     Comparator
+}
+
+// NewBytesTree returns the tree rooted at rootPageNum, with keys ordered by compare, or by
+// bytes.Compare if compare is nil
+func NewBytesTree(pager Pager, rootPageNum uint32, compare Comparator) *BytesTree {
+	if compare == nil {
+		compare = bytes.Compare
+	}
+	return &BytesTree{pager: pager, rootPageNum: rootPageNum, compare: compare}
+}
+
+// InitialiseBytesTree allocates the root page of a new, empty tree and returns the tree and its root
+// page number
+func InitialiseBytesTree(pager Pager, compare Comparator) (*BytesTree, uint32, error) {
+	rootPageNum, err := pager.AllocatePage()
+	if err != nil {
+		return nil, 0, err
+	}
+	t := NewBytesTree(pager, rootPageNum, compare)
+	if err := t.writeNode(&bytesNode{pageNum: rootPageNum, leaf: true}); err != nil {
+		return nil, 0, err
+	}
+	return t, rootPageNum, nil
+}
+
+func (t *BytesTree) capacity() int {
+	return slottedCapacity(t.pager.PageSize())
+}
+
+// readNode copies a node out of its page
+func (t *BytesTree) readNode(pageNum uint32) (*bytesNode, error) {
+	page, err := t.pager.Page(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	header := NewNode(page)
+	n := &bytesNode{pageNum: pageNum, leaf: header.Type() == LeafNode}
+	if header.Type() != LeafNode && header.Type() != InternalNode {
+		return nil, corruptf("page %d has unknown node type %d", pageNum, header.Type())
+	}
+
+	p := *page
+	numCells := int(binary.LittleEndian.Uint16(p[SlottedNumCellsOffset:]))
+	usableSize := p.UsableSize()
+	if SlottedHeaderSize+numCells*SlotSize > usableSize {
+		return nil, corruptf("page %d has %d cells, more than fit", pageNum, numCells)
+	}
+	n.link = binary.LittleEndian.Uint32(p[SlottedLinkOffset:])
+	n.cells = make([][]byte, numCells)
+	for i := range n.cells {
+		offset := int(binary.LittleEndian.Uint16(p[SlottedHeaderSize+i*SlotSize:]))
+		headerSize := BytesInternalCellHeaderSize
+		if n.leaf {
+			headerSize = BytesLeafCellHeaderSize
+		}
+		if offset < SlottedHeaderSize || offset+headerSize > usableSize {
+			return nil, corruptf("page %d has cell %d at offset %d, outside the page", pageNum, i, offset)
+		}
+
+		size := BytesInternalCellHeaderSize + len(internalCellKey(p[offset:]))
+		if n.leaf {
+			size = leafCellSize(p[offset:])
+		}
+		if offset+size > usableSize {
+			return nil, corruptf("page %d has cell %d running past the end of the page", pageNum, i)
+		}
+		n.cells[i] = append([]byte(nil), p[offset:offset+size]...)
+	}
+	return n, nil
+}
+
+// writeNode lays a node out in its page, packing its cells at the end
+func (t *BytesTree) writeNode(n *bytesNode) error {
+	if cellsSize(n.cells) > t.capacity() {
+		return fmt.Errorf("node for page %d takes %d bytes, only %d fit", n.pageNum, cellsSize(n.cells), t.capacity())
+	}
+	page, err := t.pager.Page(n.pageNum)
+	if err != nil {
+		return err
+	}
+	if err := t.pager.MarkDirty(n.pageNum); err != nil {
+		return err
+	}
+
+	header := NewNode(page)
+	if n.leaf {
+		header.SetType(LeafNode)
+	} else {
+		header.SetType(InternalNode)
+	}
+	header.SetIsRoot(n.pageNum == t.rootPageNum)
+	header.SetParentPointer(0)
+
+	p := *page
+	offset := p.UsableSize()
+	for i, cell := range n.cells {
+		offset -= len(cell)
+		copy(p[offset:], cell)
+		binary.LittleEndian.PutUint16(p[SlottedHeaderSize+i*SlotSize:], uint16(offset))
+	}
+	binary.LittleEndian.PutUint16(p[SlottedNumCellsOffset:], uint16(len(n.cells)))
+	binary.LittleEndian.PutUint16(p[SlottedContentStartOffset:], uint16(offset))
+	binary.LittleEndian.PutUint32(p[SlottedLinkOffset:], n.link)
+	return nil
+}
+
+// search returns the index of the first cell with a key no less than key, and whether it is key
+func (t *BytesTree) search(n *bytesNode, key []byte) (int, bool) {
+	minIndex, maxIndex := 0, len(n.cells)
+	for minIndex != maxIndex {
+		index := (minIndex + maxIndex) / 2
+		if t.compare(n.key(index), key) >= 0 {
+			maxIndex = index
+		} else {
+			minIndex = index + 1
+		}
+	}
+	return minIndex, minIndex < len(n.cells) && t.compare(n.key(minIndex), key) == 0
+}
+
+// find returns the path to the leaf key belongs in, the leaf, the index key has or should have in it,
+// and whether it is there
+func (t *BytesTree) find(key []byte) ([]bytesPathEntry, *bytesNode, int, bool, error) {
+	path := []bytesPathEntry{}
+	n, err := t.readNode(t.rootPageNum)
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	for !n.leaf {
+		index, _ := t.search(n, key)
+		path = append(path, bytesPathEntry{pageNum: n.pageNum, index: index})
+		if n, err = t.readNode(n.child(index)); err != nil {
+			return nil, nil, 0, false, err
+		}
+	}
+	index, found := t.search(n, key)
+	return path, n, index, found, nil
+}
+
+// Get returns the value stored against key, or ErrKeyNotFound
+func (t *BytesTree) Get(key []byte) ([]byte, error) {
+	_, leaf, index, found, err := t.find(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	return t.value(leaf.cells[index])
+}
+
+// value returns the value of a leaf cell, reading it from its overflow pages if need be
+func (t *BytesTree) value(cell []byte) ([]byte, error) {
+	overflow := leafCellOverflow(cell)
+	if overflow == 0 {
+		value := cell[BytesLeafCellHeaderSize+len(leafCellKey(cell)):]
+		return append([]byte(nil), value...), nil
+	}
+	return t.readOverflow(overflow, leafCellValueLength(cell))
+}
+
+// Put stores value against key, replacing any value already stored against it
+func (t *BytesTree) Put(key []byte, value []byte) error {
+	if len(key) > MaxBytesKeySize(t.pager.PageSize()) {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrKeyTooLarge, len(key), MaxBytesKeySize(t.pager.PageSize()))
+	}
+	path, leaf, index, found, err := t.find(key)
+	if err != nil {
+		return err
+	}
+	cell, err := t.newLeafCell(key, value)
+	if err != nil {
+		return err
+	}
+	if !found {
+		leaf.cells = insertCell(leaf.cells, index, cell)
+		return t.store(path, leaf)
+	}
+
+	// The old value is only freed once the leaf points at the new one
+	overflow := leafCellOverflow(leaf.cells[index])
+	leaf.cells[index] = cell
+	if err := t.store(path, leaf); err != nil {
+		return err
+	}
+	return t.freeOverflow(overflow)
+}
+
+// newLeafCell makes the cell for key and value, writing the value to overflow pages if it is too big
+// to keep in the cell
+func (t *BytesTree) newLeafCell(key []byte, value []byte) ([]byte, error) {
+	size := BytesLeafCellHeaderSize + len(key) + len(value)
+	overflow := uint32(0)
+	if size+SlotSize > maxCellSize(t.pager.PageSize()) {
+		var err error
+		if overflow, err = t.writeOverflow(value); err != nil {
+			return nil, err
+		}
+		size -= len(value)
+	}
+
+	cell := make([]byte, size)
+	binary.LittleEndian.PutUint16(cell[BytesLeafKeyLengthOffset:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(cell[BytesLeafValueLengthOffset:], uint32(len(value)))
+	binary.LittleEndian.PutUint32(cell[BytesLeafOverflowOffset:], overflow)
+	copy(cell[BytesLeafCellHeaderSize:], key)
+	if overflow == 0 {
+		copy(cell[BytesLeafCellHeaderSize+len(key):], value)
+	}
+	return cell, nil
+}
+
+func (t *BytesTree) overflowChunkSize() int {
+	return t.pager.PageSize() - PageReservedSize - OverflowNextSize
+}
+
+// writeOverflow writes value to a chain of overflow pages and returns the first
+func (t *BytesTree) writeOverflow(value []byte) (uint32, error) {
+	chunkSize := t.overflowChunkSize()
+	numPages := (len(value) + chunkSize - 1) / chunkSize
+
+	// The chain is written from its end, so each page can point at the one after it
+	next := uint32(0)
+	for i := numPages - 1; i >= 0; i-- {
+		pageNum, err := t.pager.AllocatePage()
+		if err != nil {
+			return 0, err
+		}
+		page, err := t.pager.Page(pageNum)
+		if err != nil {
+			return 0, err
+		}
+		if err := t.pager.MarkDirty(pageNum); err != nil {
+			return 0, err
+		}
+		end := (i + 1) * chunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		binary.LittleEndian.PutUint32((*page)[:OverflowNextSize], next)
+		copy((*page)[OverflowNextSize:], value[i*chunkSize:end])
+		next = pageNum
+	}
+	return next, nil
+}
+
+// readOverflow reads a value of length bytes from the chain of overflow pages starting at pageNum
+func (t *BytesTree) readOverflow(pageNum uint32, length uint32) ([]byte, error) {
+	value := make([]byte, 0, length)
+	chunkSize := t.overflowChunkSize()
+	for uint32(len(value)) < length {
+		if pageNum == 0 {
+			return nil, corruptf("overflow chain ends after %d of %d bytes", len(value), length)
+		}
+		page, err := t.pager.Page(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		n := int(length) - len(value)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		value = append(value, (*page)[OverflowNextSize:OverflowNextSize+n]...)
+		pageNum = binary.LittleEndian.Uint32((*page)[:OverflowNextSize])
+	}
+	return value, nil
+}
+
+// freeOverflow hands the chain of overflow pages starting at pageNum back to the pager
+func (t *BytesTree) freeOverflow(pageNum uint32) error {
+	for pageNum != 0 {
+		page, err := t.pager.Page(pageNum)
+		if err != nil {
+			return err
+		}
+		next := binary.LittleEndian.Uint32((*page)[:OverflowNextSize])
+		if err := t.pager.FreePage(pageNum); err != nil {
+			return err
+		}
+		pageNum = next
+	}
+	return nil
+}
+
+// splitIndex returns where to divide cells so each side takes about half the space, leaving at least
+// lo cells on the left and len(cells)-hi on the right
+func splitIndex(cells [][]byte, lo int, hi int) int {
+	half := cellsSize(cells) / 2
+	size := 0
+	index := 0
+	for index < len(cells) && size+len(cells[index])+SlotSize <= half {
+		size += len(cells[index]) + SlotSize
+		index++
+	}
+	if index < lo {
+		return lo
+	}
+	if index > hi {
+		return hi
+	}
+	return index
+}
+
+/*
+split divides a node's cells between two nodes, returning them and the largest key the left one can
+hold. The left node takes n's page number and the right one needs a page of its own. Linking split
+leaves is left to the caller.
+*/
+func (t *BytesTree) split(n *bytesNode) (*bytesNode, *bytesNode, []byte) {
+	if n.leaf {
+		index := splitIndex(n.cells, 1, len(n.cells)-1)
+		left := &bytesNode{pageNum: n.pageNum, leaf: true, cells: n.cells[:index:index]}
+		right := &bytesNode{leaf: true, cells: n.cells[index:]}
+		return left, right, left.key(index - 1)
+	}
+
+	// The middle cell's child becomes the left node's right child, and its key is passed up
+	index := splitIndex(n.cells, 1, len(n.cells)-2)
+	left := &bytesNode{pageNum: n.pageNum, cells: n.cells[:index:index], link: n.child(index)}
+	right := &bytesNode{cells: n.cells[index+1:], link: n.link}
+	return left, right, n.key(index)
+}
+
+// store writes n back to its page, splitting it if it no longer fits. path leads to n's parent.
+func (t *BytesTree) store(path []bytesPathEntry, n *bytesNode) error {
+	if cellsSize(n.cells) <= t.capacity() {
+		return t.writeNode(n)
+	}
+
+	left, right, separator := t.split(n)
+	if n.pageNum == t.rootPageNum {
+		// The root page never moves, both halves go to new pages and the root points at them
+		leftPageNum, err := t.pager.AllocatePage()
+		if err != nil {
+			return err
+		}
+		rightPageNum, err := t.pager.AllocatePage()
+		if err != nil {
+			return err
+		}
+		left.pageNum, right.pageNum = leftPageNum, rightPageNum
+		if n.leaf {
+			left.link = rightPageNum
+		}
+		if err := t.writeNode(left); err != nil {
+			return err
+		}
+		if err := t.writeNode(right); err != nil {
+			return err
+		}
+		root := &bytesNode{pageNum: t.rootPageNum, cells: [][]byte{newInternalCell(leftPageNum, separator)}, link: rightPageNum}
+		return t.writeNode(root)
+	}
+
+	rightPageNum, err := t.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	right.pageNum = rightPageNum
+	if n.leaf {
+		left.link, right.link = rightPageNum, n.link
+	}
+	if err := t.writeNode(left); err != nil {
+		return err
+	}
+	if err := t.writeNode(right); err != nil {
+		return err
+	}
+
+	// The right node takes over n's slot in the parent, and the left node is inserted in front of it
+	entry := path[len(path)-1]
+	parent, err := t.readNode(entry.pageNum)
+	if err != nil {
+		return err
+	}
+	parent.setChild(entry.index, rightPageNum)
+	parent.cells = insertCell(parent.cells, entry.index, newInternalCell(left.pageNum, separator))
+	return t.store(path[:len(path)-1], parent)
+}
+
+// Delete removes key from the tree, or returns ErrKeyNotFound
+func (t *BytesTree) Delete(key []byte) error {
+	path, leaf, index, found, err := t.find(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	overflow := leafCellOverflow(leaf.cells[index])
+	leaf.cells = removeCell(leaf.cells, index)
+	if err := t.writeNode(leaf); err != nil {
+		return err
+	}
+	if err := t.freeOverflow(overflow); err != nil {
+		return err
+	}
+	return t.rebalance(path, leaf)
+}
+
+/*
+rebalance tops up a node that has lost a cell and is left less than a quarter full, merging it with
+a neighbour if they fit in one page, or sharing the cells of both between them otherwise. path leads
+to n's parent.
+*/
+func (t *BytesTree) rebalance(path []bytesPathEntry, n *bytesNode) error {
+	if n.pageNum == t.rootPageNum {
+		if !n.leaf && len(n.cells) == 0 {
+			return t.collapseRoot(n.link)
+		}
+		return nil
+	}
+	if cellsSize(n.cells) >= t.capacity()/4 {
+		return nil
+	}
+
+	entry := path[len(path)-1]
+	parent, err := t.readNode(entry.pageNum)
+	if err != nil {
+		return err
+	}
+	// The node is paired with its left neighbour, or its right one if it is the first child
+	leftIndex := entry.index
+	if leftIndex > 0 {
+		leftIndex--
+	}
+	left, err := t.readNode(parent.child(leftIndex))
+	if err != nil {
+		return err
+	}
+	right, err := t.readNode(parent.child(leftIndex + 1))
+	if err != nil {
+		return err
+	}
+	if left.leaf != right.leaf {
+		return corruptf("pages %d and %d are siblings of different types", left.pageNum, right.pageNum)
+	}
+
+	// The key between the nodes comes down from the parent to join internal nodes
+	cells := append([][]byte{}, left.cells...)
+	if !left.leaf {
+		cells = append(cells, newInternalCell(left.link, parent.key(leftIndex)))
+	}
+	cells = append(cells, right.cells...)
+	combined := &bytesNode{pageNum: left.pageNum, leaf: left.leaf, cells: cells, link: right.link}
+
+	if cellsSize(cells) <= t.capacity() {
+		if err := t.writeNode(combined); err != nil {
+			return err
+		}
+		parent.setChild(leftIndex+1, left.pageNum)
+		parent.cells = removeCell(parent.cells, leftIndex)
+		if err := t.writeNode(parent); err != nil {
+			return err
+		}
+		if err := t.pager.FreePage(right.pageNum); err != nil {
+			return err
+		}
+		return t.rebalance(path[:len(path)-1], parent)
+	}
+
+	newLeft, newRight, separator := t.split(combined)
+	newRight.pageNum = right.pageNum
+	if left.leaf {
+		newLeft.link, newRight.link = right.pageNum, right.link
+	}
+	if err := t.writeNode(newLeft); err != nil {
+		return err
+	}
+	if err := t.writeNode(newRight); err != nil {
+		return err
+	}
+	// The new separator may be longer than the old one, so the parent may need splitting
+	parent.cells[leftIndex] = newInternalCell(left.pageNum, separator)
+	return t.store(path[:len(path)-1], parent)
+}
+
+// collapseRoot replaces a root left with a single child by that child, so the root page never moves
+func (t *BytesTree) collapseRoot(childPageNum uint32) error {
+	child, err := t.readNode(childPageNum)
+	if err != nil {
+		return err
+	}
+	child.pageNum = t.rootPageNum
+	if err := t.writeNode(child); err != nil {
+		return err
+	}
+	return t.pager.FreePage(childPageNum)
+}
+
+// Walk calls fn with every key and value in the tree, in key order, stopping at the first error
+func (t *BytesTree) Walk(fn func(key []byte, value []byte) error) error {
+	n, err := t.readNode(t.rootPageNum)
+	if err != nil {
+		return err
+	}
+	for !n.leaf {
+		if n, err = t.readNode(n.child(0)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		for _, cell := range n.cells {
+			value, err := t.value(cell)
+			if err != nil {
+				return err
+			}
+			if err := fn(leafCellKey(cell), value); err != nil {
+				return err
+			}
+		}
+		if n.link == 0 {
+			return nil
+		}
+		if n, err = t.readNode(n.link); err != nil {
+			return err
+		}
+		if !n.leaf {
+			return corruptf("leaf links to page %d which is not a leaf", n.pageNum)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBytesTree(t *testing.T, pager Pager, compare Comparator) (*FreeListPager, *BytesTree) {
+	t.Helper()
+	freeList := newTestFreeList(pager)
+	tree, _, err := InitialiseBytesTree(freeList, compare)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	return freeList, tree
+}
+
+// testBytesKey returns keys of differing lengths that do not sort in the order of i
+func testBytesKey(i int) []byte {
+	return []byte(fmt.Sprintf("%s-%d", bytes.Repeat([]byte{'k'}, i%37), i))
+}
+
+// testBytesValue returns values of differing lengths, every tenth one too big to keep in a cell
+func testBytesValue(i int, pageSize int) []byte {
+	length := i % 50
+	if i%10 == 0 {
+		length = pageSize*2 + i%pageSize
+	}
+	value := make([]byte, length)
+	for j := range value {
+		value[j] = byte(i + j)
+	}
+	return value
+}
+
+// checkBytesTree checks the keys are in order and within the range their parents allow, that every
+// leaf is the same distance from the root and linked to the next, and that no node but the root is
+// empty. It returns the number of keys in the tree.
+func checkBytesTree(t *testing.T, tree *BytesTree) int {
+	t.Helper()
+	numKeys := 0
+	leafDepth := -1
+	leaves := []uint32{}
+
+	var check func(pageNum uint32, lo []byte, hi []byte, depth int)
+	check = func(pageNum uint32, lo []byte, hi []byte, depth int) {
+		n, err := tree.readNode(pageNum)
+		if err != nil {
+			t.Fatalf("unexpected error reading page %d, got %+v", pageNum, err)
+		}
+		if pageNum != tree.rootPageNum && len(n.cells) == 0 {
+			t.Errorf("page %d is empty", pageNum)
+		}
+		for i := range n.cells {
+			key := n.key(i)
+			if (lo != nil && tree.compare(key, lo) <= 0) || (hi != nil && tree.compare(key, hi) > 0) {
+				t.Errorf("page %d has key %q out of range (%q, %q]", pageNum, key, lo, hi)
+			}
+			if i > 0 && tree.compare(key, n.key(i-1)) <= 0 {
+				t.Errorf("page %d has key %q after %q", pageNum, key, n.key(i-1))
+			}
+		}
+
+		if n.leaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				t.Errorf("leaf %d is at depth %d, expected %d", pageNum, depth, leafDepth)
+			}
+			if len(leaves) > 0 {
+				if previous, _ := tree.readNode(leaves[len(leaves)-1]); previous.link != pageNum {
+					t.Errorf("leaf %d links to %d, expected %d", previous.pageNum, previous.link, pageNum)
+				}
+			}
+			leaves = append(leaves, pageNum)
+			numKeys += len(n.cells)
+			return
+		}
+		for i := 0; i <= len(n.cells); i++ {
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = n.key(i - 1)
+			}
+			if i < len(n.cells) {
+				childHi = n.key(i)
+			}
+			check(n.child(i), childLo, childHi, depth+1)
+		}
+	}
+	check(tree.rootPageNum, nil, nil, 0)
+
+	if last, _ := tree.readNode(leaves[len(leaves)-1]); last.link != 0 {
+		t.Errorf("last leaf %d links to %d", last.pageNum, last.link)
+	}
+	return numKeys
+}
+
+func TestBytesTreePutAndDelete(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	filePager, _ := OpenFilePager(filename, Options{PageSize: MinPageSize, CacheSize: MinCacheSize})
+	defer filePager.Close()
+
+	const numKeys = 2000
+	tests := []struct {
+		name  string
+		pager Pager
+	}{
+		{"memory", NewMemoryPager(MinPageSize)},
+		{"small cache", filePager},
+	}
+
+	for _, test := range tests {
+		freeList, tree := newTestBytesTree(t, test.pager, nil)
+		for i := 0; i < numKeys; i++ {
+			key := (i * 7919) % numKeys
+			if err := tree.Put(testBytesKey(key), testBytesValue(key, MinPageSize)); err != nil {
+				t.Fatalf("%s: unexpected error putting %d, got %+v", test.name, key, err)
+			}
+		}
+		if count := checkBytesTree(t, tree); count != numKeys {
+			t.Errorf("%s: incorrect number of keys, expected %d, got %d", test.name, numKeys, count)
+		}
+		for i := 0; i < numKeys; i++ {
+			value, err := tree.Get(testBytesKey(i))
+			if err != nil || !bytes.Equal(value, testBytesValue(i, MinPageSize)) {
+				t.Fatalf("%s: incorrect value for key %d, got %d bytes, %+v", test.name, i, len(value), err)
+			}
+		}
+
+		for i := 0; i < numKeys; i++ {
+			key := (i * 104729) % numKeys
+			if err := tree.Delete(testBytesKey(key)); err != nil {
+				t.Fatalf("%s: unexpected error deleting %d, got %+v", test.name, key, err)
+			}
+			if _, err := tree.Get(testBytesKey(key)); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("%s: expected %v for deleted key %d, got %+v", test.name, ErrKeyNotFound, key, err)
+			}
+			if i%250 == 0 {
+				if count := checkBytesTree(t, tree); count != numKeys-i-1 {
+					t.Fatalf("%s: incorrect number of keys, expected %d, got %d", test.name, numKeys-i-1, count)
+				}
+			}
+		}
+
+		// Every page but the root, overflow pages included, has been handed back
+		checkBytesTree(t, tree)
+		freePages, _ := freeList.FreePageCount()
+		if expected := freeList.GetNextUnusedPageNum() - 2; freePages != expected {
+			t.Errorf("%s: incorrect number of free pages, expected %d, got %d", test.name, expected, freePages)
+		}
+	}
+}
+
+func TestBytesTreeReplace(t *testing.T) {
+	freeList, tree := newTestBytesTree(t, NewMemoryPager(MinPageSize), nil)
+	key := []byte("offset")
+	big := bytes.Repeat([]byte{0x1}, MinPageSize*3)
+
+	for _, value := range [][]byte{[]byte("small"), big, []byte("smaller"), {}} {
+		if err := tree.Put(key, value); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+		if got, err := tree.Get(key); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("incorrect value, expected %d bytes, got %d, %+v", len(value), len(got), err)
+		}
+	}
+
+	// The overflow pages of the big value are free again
+	freePages, _ := freeList.FreePageCount()
+	if expected := freeList.GetNextUnusedPageNum() - 2; freePages != expected {
+		t.Errorf("incorrect number of free pages, expected %d, got %d", expected, freePages)
+	}
+	if count := checkBytesTree(t, tree); count != 1 {
+		t.Errorf("incorrect number of keys, expected %d, got %d", 1, count)
+	}
+}
+
+func TestBytesTreeReplaceFailure(t *testing.T) {
+	fault := NewFaultPager(NewMemoryPager(MinPageSize), 1)
+	freeList, tree := newTestBytesTree(t, fault, nil)
+	big := bytes.Repeat([]byte{0x1}, MinPageSize*3)
+	// A cell of the most a cell may take, for a one byte key
+	full := make([]byte, maxCellSize(MinPageSize)-SlotSize-BytesLeafCellHeaderSize-1)
+	for key, value := range map[string][]byte{"a": full, "b": full, "c": full, "d": {0x2}, "k": big} {
+		if err := tree.Put([]byte(key), value); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+
+	// The new value is too big for the leaf, which fails to split
+	fault.SetFaults(Fault{Op: FaultRead, At: 0})
+	if err := tree.Put([]byte("k"), full); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected %v, got %+v", ErrInjected, err)
+	}
+	fault.SetFaults()
+
+	// The old value and its overflow pages are untouched
+	if got, err := tree.Get([]byte("k")); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("incorrect value, expected %d bytes, got %d, %+v", len(big), len(got), err)
+	}
+	if freePages, _ := freeList.FreePageCount(); freePages != 0 {
+		t.Errorf("incorrect number of free pages, expected %d, got %d", 0, freePages)
+	}
+}
+
+func TestBytesTreeComparator(t *testing.T) {
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+	_, tree := newTestBytesTree(t, NewMemoryPager(MinPageSize), reverse)
+	for i := 0; i < 1000; i++ {
+		tree.Put(testBytesKey(i), []byte{byte(i)})
+	}
+	checkBytesTree(t, tree)
+
+	var previous []byte
+	count := 0
+	err := tree.Walk(func(key []byte, value []byte) error {
+		if previous != nil && bytes.Compare(key, previous) >= 0 {
+			t.Errorf("incorrect order, %q after %q", key, previous)
+		}
+		previous = append([]byte(nil), key...)
+		count++
+		return nil
+	})
+	if err != nil || count != 1000 {
+		t.Errorf("incorrect walk, expected %d keys, got %d, %+v", 1000, count, err)
+	}
+}
+
+func TestBytesTreeKeyTooLarge(t *testing.T) {
+	_, tree := newTestBytesTree(t, NewMemoryPager(MinPageSize), nil)
+	maxKey := bytes.Repeat([]byte{'k'}, MaxBytesKeySize(MinPageSize))
+	if err := tree.Put(maxKey, []byte("value")); err != nil {
+		t.Errorf("unexpected error, got %+v", err)
+	}
+	if err := tree.Put(append(maxKey, 'k'), []byte("value")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected %v, got %+v", ErrKeyTooLarge, err)
+	}
+}
+
+func TestBytesTreeSurvivesReopen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := Open(filename, Options{PageSize: MinPageSize})
+	_, tree := newTestBytesTree(t, pager, nil)
+	rootPageNum := tree.rootPageNum
+	for i := 0; i < 500; i++ {
+		tree.Put(testBytesKey(i), testBytesValue(i, MinPageSize))
+	}
+	pager.Close()
+
+	pager, err := Open(filename, Options{PageSize: MinPageSize, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+	tree = NewBytesTree(pager, rootPageNum, nil)
+	for i := 0; i < 500; i++ {
+		if value, err := tree.Get(testBytesKey(i)); err != nil || !bytes.Equal(value, testBytesValue(i, MinPageSize)) {
+			t.Fatalf("incorrect value for key %d, got %d bytes, %+v", i, len(value), err)
+		}
+	}
+}
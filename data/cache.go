@@ -0,0 +1,127 @@
+package data
+
+import (
+	"container/list"
+	"sort"
+)
+
+const (
+	DefaultCacheSize = 2000
+	// MinCacheSize keeps enough pages cached that the pages touched by a single tree or stream
+	// operation are never evicted while that operation still holds them
+	MinCacheSize = 64
+)
+
+type CacheStats struct {
+	Hits     uint64
+	Misses   uint64
+	Size     int
+	Capacity int
+}
+
+type cacheEntry struct {
+	pageNum uint32
+	page    Page
+	dirty   bool
+}
+
+// pageCache holds up to capacity pages, ordered from most to least recently used
+type pageCache struct {
+	capacity int
+	entries  map[uint32]*list.Element
+	lru      *list.List
+	hits     uint64
+	misses   uint64
+}
+
+func newPageCache(capacity int) *pageCache {
+	if capacity <= 0 {
+		capacity = DefaultCacheSize
+	}
+	if capacity < MinCacheSize {
+		capacity = MinCacheSize
+	}
+	return &pageCache{capacity: capacity, entries: make(map[uint32]*list.Element), lru: list.New()}
+}
+
+// get returns a cached page, marking it as the most recently used
+func (c *pageCache) get(pageNum uint32) (Page, bool) {
+	element, found := c.entries[pageNum]
+	if !found {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(element)
+	return element.Value.(*cacheEntry).page, true
+}
+
+func (c *pageCache) put(pageNum uint32, page Page, dirty bool) {
+	c.entries[pageNum] = c.lru.PushFront(&cacheEntry{pageNum: pageNum, page: page, dirty: dirty})
+}
+
+func (c *pageCache) full() bool {
+	return c.lru.Len() >= c.capacity
+}
+
+// victim returns the least recently used page
+func (c *pageCache) victim() *cacheEntry {
+	element := c.lru.Back()
+	if element == nil {
+		return nil
+	}
+	return element.Value.(*cacheEntry)
+}
+
+func (c *pageCache) remove(pageNum uint32) {
+	if element, found := c.entries[pageNum]; found {
+		c.lru.Remove(element)
+		delete(c.entries, pageNum)
+	}
+}
+
+// truncate removes every page from numPages on
+func (c *pageCache) truncate(numPages uint32) {
+	for pageNum, element := range c.entries {
+		if pageNum >= numPages {
+			c.lru.Remove(element)
+			delete(c.entries, pageNum)
+		}
+	}
+}
+
+// discardDirty removes every dirty page
+func (c *pageCache) discardDirty() {
+	for pageNum, element := range c.entries {
+		if element.Value.(*cacheEntry).dirty {
+			c.lru.Remove(element)
+			delete(c.entries, pageNum)
+		}
+	}
+}
+
+func (c *pageCache) markDirty(pageNum uint32) bool {
+	element, found := c.entries[pageNum]
+	if !found {
+		return false
+	}
+	element.Value.(*cacheEntry).dirty = true
+	return true
+}
+
+// dirtyPages returns the dirty pages in page order
+func (c *pageCache) dirtyPages() []*cacheEntry {
+	dirty := []*cacheEntry{}
+	for _, element := range c.entries {
+		entry := element.Value.(*cacheEntry)
+		if entry.dirty {
+			dirty = append(dirty, entry)
+		}
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i].pageNum < dirty[j].pageNum })
+	return dirty
+}
+
+func (c *pageCache) stats() CacheStats {
+	return CacheStats{Hits: c.hits, Misses: c.misses, Size: c.lru.Len(), Capacity: c.capacity}
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newPageCache(MinCacheSize)
+	for i := uint32(0); i < MinCacheSize; i++ {
+		cache.put(i, make([]byte, DefaultPageSize), false)
+	}
+
+	if !cache.full() {
+		t.Errorf("expected cache to be full")
+	}
+
+	cache.get(0)
+	if victim := cache.victim(); victim.pageNum != 1 {
+		t.Errorf("incorrect victim, expected %d, got %d", 1, victim.pageNum)
+	}
+
+	cache.remove(1)
+	if cache.full() {
+		t.Errorf("expected cache to have room after removing a page")
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	cache := newPageCache(0)
+	cache.put(1, make([]byte, DefaultPageSize), false)
+	cache.get(1)
+	cache.get(1)
+	cache.get(2)
+
+	stats := cache.stats()
+	expected := CacheStats{Hits: 2, Misses: 1, Size: 1, Capacity: DefaultCacheSize}
+	if stats != expected {
+		t.Errorf("incorrect stats, expected %+v, got %+v", expected, stats)
+	}
+}
+
+// fillPages marks each of the first numPages pages dirty, then has fill change it
+func fillPages(pager Pager, numPages uint32, fill func(pageNum uint32, page Page)) {
+	for i := uint32(0); i < numPages; i++ {
+		page, _ := pager.Page(i)
+		pager.MarkDirty(i)
+		fill(i, *page)
+	}
+}
+
+func writePages(pager Pager, numPages uint32, value byte) {
+	fillPages(pager, numPages, func(pageNum uint32, page Page) {
+		page[0] = value
+		page[1] = byte(pageNum)
+	})
+}
+
+func TestFilePagerSpillsDirtyPages(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, err := OpenFilePager(filename, Options{CacheSize: MinCacheSize})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	numPages := uint32(3 * MinCacheSize)
+	writePages(pager, numPages, 0x1)
+
+	if stats := pager.CacheStats(); stats.Size > MinCacheSize {
+		t.Errorf("cache has grown past its capacity, expected at most %d, got %d", MinCacheSize, stats.Size)
+	}
+
+	// Spilled pages are read back from the journal before they are committed
+	page, _ := pager.Page(0)
+	if (*page)[0] != 0x1 {
+		t.Errorf("incorrect byte in spilled page, expected %d, got %d", 0x1, (*page)[0])
+	}
+
+	if err := pager.Flush(); err != nil {
+		t.Errorf("unexpected error, got %+v", err)
+	}
+	pager.Close()
+
+	contents, _ := os.ReadFile(filename)
+	if len(contents) != int(numPages)*DefaultPageSize {
+		t.Errorf("incorrect file length, expected %d, got %d", int(numPages)*DefaultPageSize, len(contents))
+	}
+	for i := 0; i < int(numPages); i++ {
+		if contents[i*DefaultPageSize] != 0x1 || contents[i*DefaultPageSize+1] != byte(i) {
+			t.Errorf("incorrect contents for page %d", i)
+		}
+	}
+}
+
+func TestSpilledPagesSurviveFailedFlush(t *testing.T) {
+	defer func(f func(*os.File) error) { syncFile = f }(syncFile)
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := OpenFilePager(filename, Options{CacheSize: MinCacheSize})
+	numPages := uint32(3 * MinCacheSize)
+	writePages(pager, numPages, 0x1)
+
+	syncFile = func(file *os.File) error { return errors.New("sync failed") }
+	if err := pager.Flush(); err == nil {
+		t.Fatalf("expected an error when the journal cannot be synced")
+	}
+	syncFile = func(file *os.File) error { return file.Sync() }
+
+	// More pages are spilled, to a new journal, before the pages of the failed flush are flushed again
+	for i := numPages; i < 2*numPages; i++ {
+		page, _ := pager.Page(i)
+		pager.MarkDirty(i)
+		(*page)[0] = 0x2
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	pager.Close()
+
+	pager, err := OpenFilePager(filename, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+	for i := uint32(0); i < 2*numPages; i++ {
+		page, err := pager.Page(i)
+		if err != nil {
+			t.Fatalf("unexpected error reading page %d, got %+v", i, err)
+		}
+		expected := byte(0x1)
+		if i >= numPages {
+			expected = 0x2
+		}
+		if (*page)[0] != expected {
+			t.Errorf("incorrect byte in page %d, expected %d, got %d", i, expected, (*page)[0])
+		}
+	}
+}
+
+func TestSpilledPagesDiscardedWithoutCommit(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := OpenFilePager(filename, Options{CacheSize: MinCacheSize})
+	numPages := uint32(2 * MinCacheSize)
+	writePages(pager, numPages, 0x1)
+	pager.Close()
+
+	pager, _ = OpenFilePager(filename, Options{CacheSize: MinCacheSize})
+	writePages(pager, numPages, 0x2)
+	if pager.journal == nil {
+		t.Fatalf("expected dirty pages to have been spilled to the journal")
+	}
+
+	// Simulate a crash before the batch is committed
+	pager.journal.close()
+	pager.fileDescriptor.Close()
+	pager.writerLock.Close()
+
+	pager, err := OpenFilePager(filename, Options{CacheSize: MinCacheSize})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+
+	for i := uint32(0); i < numPages; i++ {
+		page, _ := pager.Page(i)
+		if (*page)[0] != 0x1 {
+			t.Errorf("incorrect byte in page %d, expected %d, got %d", i, 0x1, (*page)[0])
+		}
+	}
+}
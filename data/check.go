@@ -0,0 +1,153 @@
+package data
+
+// keyRange is the range of keys a node may hold, keys greater than lo (if hasLo) and no greater than
+// hi (if hasHi)
+type keyRange struct {
+	lo, hi       uint64
+	hasLo, hasHi bool
+}
+
+func (r keyRange) contains(key uint64) bool {
+	return (!r.hasLo || key > r.lo) && (!r.hasHi || key <= r.hi)
+}
+
+type treeCheck struct {
+	tree      *Tree
+	visit     func(key uint64, item IndexItem)
+	visited   map[uint32]bool
+	leafDepth int
+	// The last leaf visited and the leaf it links to, which should be the next one visited
+	lastLeaf, lastLeafNext uint32
+	problems               []error
+}
+
+func (c *treeCheck) report(format string, a ...interface{}) {
+	c.problems = append(c.problems, corruptf(format, a...))
+}
+
+/*
+Check walks the whole tree and returns every problem it finds with it, or nil if it is sound. It
+checks that every node is a leaf or internal node holding no more cells than fit in its page, that
+keys are in order and within the range their parent allows, that parent pointers and root flags are
+right, that every leaf is the same distance from the root and that each leaf links to the next.
+
+If visit is not nil it is called with each key and item in the leaves, in key order.
+*/
+func (t *Tree) Check(visit func(key uint64, item IndexItem)) []error {
+	c := &treeCheck{tree: t, visit: visit, visited: make(map[uint32]bool), leafDepth: -1}
+	c.checkNode(t.rootPageNum, 0, keyRange{}, 0)
+	if c.lastLeafNext != 0 {
+		c.report("leaf %d links to %d, expected it to be the last leaf", c.lastLeaf, c.lastLeafNext)
+	}
+	return c.problems
+}
+
+func (c *treeCheck) checkNode(pageNum uint32, parentPageNum uint32, r keyRange, depth int) {
+	if c.visited[pageNum] {
+		c.report("page %d is in the tree more than once", pageNum)
+		return
+	}
+	c.visited[pageNum] = true
+
+	n, err := c.tree.node(pageNum)
+	if err != nil {
+		c.problems = append(c.problems, err)
+		return
+	}
+
+	isRoot := pageNum == c.tree.rootPageNum
+	if n.IsRoot() != isRoot {
+		c.report("page %d has its root flag set to %t", pageNum, n.IsRoot())
+	}
+	if !isRoot && n.ParentPointer() != parentPageNum {
+		c.report("page %d has parent %d, expected %d", pageNum, n.ParentPointer(), parentPageNum)
+	}
+
+	switch n.Type() {
+	case LeafNode:
+		c.checkLeaf(n, r, depth)
+	case InternalNode:
+		c.checkInternal(n, r, depth)
+	default:
+		c.report("page %d has unknown node type %d", pageNum, n.Type())
+	}
+}
+
+func (c *treeCheck) checkLeaf(n *Node, r keyRange, depth int) {
+	if c.leafDepth == -1 {
+		c.leafDepth = depth
+	} else if depth != c.leafDepth {
+		c.report("leaf %d is at depth %d, expected %d", n.pageNum, depth, c.leafDepth)
+	}
+	if c.lastLeaf != 0 && c.lastLeafNext != n.pageNum {
+		c.report("leaf %d links to %d, expected %d", c.lastLeaf, c.lastLeafNext, n.pageNum)
+	}
+	c.lastLeaf, c.lastLeafNext = n.pageNum, n.GetNextLeaf()
+
+	numCells := n.NumCells()
+	if numCells > LeafNodeMaxCells(n.pageSize()) {
+		c.report("leaf %d has %d cells, at most %d fit", n.pageNum, numCells, LeafNodeMaxCells(n.pageSize()))
+		return
+	}
+	if numCells == 0 && n.pageNum != c.tree.rootPageNum {
+		c.report("leaf %d is empty", n.pageNum)
+	}
+
+	for i := uint16(0); i < numCells; i++ {
+		key := n.GetNodeKey(i)
+		if !r.contains(key) {
+			c.report("leaf %d has key %d out of range for its parent", n.pageNum, key)
+		}
+		if i > 0 && key <= n.GetNodeKey(i-1) {
+			c.report("leaf %d has key %d after key %d", n.pageNum, key, n.GetNodeKey(i-1))
+		}
+		if c.visit != nil {
+			c.visit(key, n.GetNodeValue(i))
+		}
+	}
+}
+
+func (c *treeCheck) checkInternal(n *Node, r keyRange, depth int) {
+	numKeys := n.NumKeys()
+	if numKeys == 0 {
+		c.report("internal node %d has no keys", n.pageNum)
+	}
+	if numKeys > InternalNodeMaxCells(n.pageSize()) {
+		c.report("internal node %d has %d keys, at most %d fit", n.pageNum, numKeys, InternalNodeMaxCells(n.pageSize()))
+		return
+	}
+
+	// Read everything needed up front, as checking the children may evict n's page
+	keys := make([]uint64, numKeys)
+	children := make([]uint32, numKeys+1)
+	for i := uint16(0); i < numKeys; i++ {
+		keys[i] = n.InternalKey(i)
+		children[i] = n.ChildPointer(i)
+	}
+	children[numKeys] = n.RightChild()
+
+	for i, key := range keys {
+		if !r.contains(key) {
+			c.report("internal node %d has key %d out of range for its parent", n.pageNum, key)
+		}
+		if i > 0 && key <= keys[i-1] {
+			c.report("internal node %d has key %d after key %d", n.pageNum, key, keys[i-1])
+		}
+	}
+
+	numPages := c.tree.pager.GetNextUnusedPageNum()
+	for i, child := range children {
+		if child == 0 || child >= numPages {
+			c.report("internal node %d has child %d which is not a page in the file", n.pageNum, child)
+			continue
+		}
+		childRange := r
+		if i > 0 {
+			childRange.lo, childRange.hasLo = keys[i-1], true
+		}
+		if i < len(keys) {
+			childRange.hi, childRange.hasHi = keys[i], true
+		}
+		c.checkNode(child, n.pageNum, childRange, depth+1)
+	}
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckVisitsEveryKeyInOrder(t *testing.T) {
+	tree := newTestTree(NewMemoryPager(MinPageSize))
+	for i := uint64(0); i < 5000; i++ {
+		key := (i * 7919) % 5000
+		tree.Insert(key, IndexItem{uint32(key), 0, 1})
+	}
+
+	next := uint64(0)
+	problems := tree.Check(func(key uint64, item IndexItem) {
+		if key != next || uint64(item.PageNum) != key {
+			t.Errorf("incorrect key visited, expected %d, got %d, %+v", next, key, item)
+		}
+		next++
+	})
+	if len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+	if next != 5000 {
+		t.Errorf("incorrect number of keys visited, expected %d, got %d", 5000, next)
+	}
+}
+
+func TestCheckReportsEveryProblem(t *testing.T) {
+	pager := NewMemoryPager(MinPageSize)
+	tree := newTestTree(pager)
+	for i := uint64(0); i < 500; i++ {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+	root, _ := tree.node(tree.rootPageNum)
+	if root.Type() != InternalNode || root.NumKeys() < 2 {
+		t.Fatalf("expected a root with at least three children")
+	}
+
+	// Keys out of order in the first leaf
+	first, _ := tree.node(root.ChildPointer(0))
+	first.SetNodeKey(0, first.GetNodeKey(1))
+	// A stray root flag and the wrong parent on the second leaf
+	second, _ := tree.node(root.ChildPointer(1))
+	second.SetIsRoot(true)
+	second.SetParentPointer(root.ChildPointer(0))
+	// A child pointer past the end of the file, which also leaves the last leaf checked linking to a
+	// leaf that is not in the tree
+	root.SetRightChild(pager.GetNextUnusedPageNum() + 10)
+
+	problems := tree.Check(nil)
+	if len(problems) != 5 {
+		t.Errorf("incorrect number of problems, expected %d, got %d: %+v", 5, len(problems), problems)
+	}
+	for _, problem := range problems {
+		if !errors.Is(problem, ErrCorrupt) {
+			t.Errorf("expected %v, got %+v", ErrCorrupt, problem)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+/*
+Every page ends with a CRC32C checksum of the rest of the page. The checksum is written as the page
+is journalled and checked whenever the page is read back from disk. Node layouts must leave the last
+PageReservedSize bytes of a page alone.
+*/
+const PageReservedSize = 4
+
+// UsableSize is the number of bytes at the start of the page that node layouts may use
+func (p Page) UsableSize() int {
+	return len(p) - PageReservedSize
+}
+
+// CorruptPageError is returned when a page read from disk does not match its checksum
+type CorruptPageError struct {
+	PageNum uint32
+}
+
+func (e *CorruptPageError) Error() string {
+	return fmt.Sprintf("page %d is corrupt, checksum does not match", e.PageNum)
+}
+
+// Is makes a CorruptPageError match ErrCorrupt
+func (e *CorruptPageError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+func checksumOffset(page []byte) int {
+	return len(page) - PageReservedSize
+}
+
+func pageChecksum(page []byte) uint32 {
+	return crc32.Checksum(page[:checksumOffset(page)], crcTable)
+}
+
+func setChecksum(page []byte) {
+	offset := checksumOffset(page)
+	binary.LittleEndian.PutUint32(page[offset:offset+PageReservedSize], pageChecksum(page))
+}
+
+func verifyChecksum(pageNum uint32, page []byte) error {
+	offset := checksumOffset(page)
+	if binary.LittleEndian.Uint32(page[offset:offset+PageReservedSize]) != pageChecksum(page) {
+		return &CorruptPageError{PageNum: pageNum}
+	}
+	return nil
+}
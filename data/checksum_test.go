@@ -0,0 +1,112 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumWrittenOnFlush(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		page, _ := pager.Page(0)
+		(*page)[0] = 0x1
+		pager.Close()
+
+		contents, _ := os.ReadFile(filename)
+		if err := verifyChecksum(0, contents[:DefaultPageSize]); err != nil {
+			t.Errorf("%s: unexpected error, got %+v", pt.name, err)
+		}
+	}
+}
+
+func TestCorruptPageDetected(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		writeDBFile(filename, make([]byte, DefaultPageSize), make([]byte, DefaultPageSize))
+
+		// Flip a bit in page 1, as bit rot would
+		file, _ := os.OpenFile(filename, os.O_RDWR, 0644)
+		file.WriteAt([]byte{0x10}, DefaultPageSize+100)
+		file.Close()
+
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		if _, err := pager.Page(0); err != nil {
+			t.Errorf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		_, err = pager.Page(1)
+		var corrupt *CorruptPageError
+		if !errors.As(err, &corrupt) {
+			t.Errorf("%s: expected a CorruptPageError, got %+v", pt.name, err)
+		} else if corrupt.PageNum != 1 {
+			t.Errorf("%s: incorrect page in error, expected %d, got %d", pt.name, 1, corrupt.PageNum)
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected %v, got %+v", pt.name, ErrCorrupt, err)
+		}
+		pager.Close()
+	}
+}
+
+func TestSpilledPagesAreChecksummed(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := OpenFilePager(filename, Options{CacheSize: MinCacheSize})
+	defer pager.Close()
+	writePages(pager, 2*MinCacheSize, 0x1)
+
+	offset, spilled := pager.spilled[0]
+	if !spilled {
+		t.Fatalf("expected page 0 to have been spilled to the journal")
+	}
+
+	// Corrupt the spilled copy of page 0 in the journal
+	pager.journal.file.WriteAt([]byte{0xFF}, offset+100)
+
+	var corrupt *CorruptPageError
+	if _, err := pager.Page(0); !errors.As(err, &corrupt) {
+		t.Errorf("expected a CorruptPageError, got %+v", err)
+	}
+}
+
+func TestSkipChecksums(t *testing.T) {
+	for _, pt := range pagerTypes {
+		// Pages written before there were checksums use the bytes the checksum is now kept in
+		filename := filepath.Join(t.TempDir(), "test.db")
+		page := make([]byte, DefaultPageSize)
+		for i := range page {
+			page[i] = byte(i)
+		}
+		os.WriteFile(filename, page, 0644)
+
+		opts := pt.opts
+		opts.SkipChecksums = true
+		pager, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		read, err := pager.Page(0)
+		if err != nil {
+			t.Errorf("%s: unexpected error, got %+v", pt.name, err)
+		} else if (*read)[DefaultPageSize-1] != page[DefaultPageSize-1] {
+			t.Errorf("%s: incorrect last byte, expected %d, got %d", pt.name, page[DefaultPageSize-1], (*read)[DefaultPageSize-1])
+		}
+
+		// Pages written back get a checksum
+		pager.MarkDirty(0)
+		pager.Close()
+		contents, _ := os.ReadFile(filename)
+		if err := verifyChecksum(0, contents); err != nil {
+			t.Errorf("%s: unexpected error, got %+v", pt.name, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package data
+
+/*
+TreeCursor walks the keys of a tree in order. Next follows the links between neighbouring leaves, so
+a scan reads each leaf once. Leaves only link forward, so Prev searches from the root when it leaves
+a leaf.
+
+The cursor holds the key and item it is positioned at rather than the page they are in, so pages can
+be evicted while it is in use. A cursor must be positioned again with Seek, First or Last after the
+tree is changed.
+*/
+type TreeCursor struct {
+	tree    *Tree
+	pageNum uint32
+	index   uint16
+	key     uint64
+	item    IndexItem
+	valid   bool
+}
+
+// Cursor returns a cursor over the tree. It is not positioned at a key until Seek, First or Last is
+// called.
+func (t *Tree) Cursor() *TreeCursor {
+	return &TreeCursor{tree: t}
+}
+
+// Valid reports whether the cursor is positioned at a key
+func (c *TreeCursor) Valid() bool {
+	return c.valid
+}
+
+// Key returns the key the cursor is positioned at
+func (c *TreeCursor) Key() uint64 {
+	return c.key
+}
+
+// Item returns the item stored against the key the cursor is positioned at
+func (c *TreeCursor) Item() IndexItem {
+	return c.item
+}
+
+// First positions the cursor at the smallest key in the tree
+func (c *TreeCursor) First() error {
+	n, err := c.tree.descend(c.tree.rootPageNum, func(n *Node) uint32 { return n.ChildPointer(0) })
+	if err != nil {
+		return err
+	}
+	return c.load(n, 0)
+}
+
+// Last positions the cursor at the largest key in the tree
+func (c *TreeCursor) Last() error {
+	return c.last(c.tree.rootPageNum)
+}
+
+// last positions the cursor at the largest key under pageNum
+func (c *TreeCursor) last(pageNum uint32) error {
+	n, err := c.tree.descend(pageNum, func(n *Node) uint32 { return n.RightChild() })
+	if err != nil {
+		return err
+	}
+	if n.NumCells() == 0 {
+		c.valid = false
+		return nil
+	}
+	return c.load(n, n.NumCells()-1)
+}
+
+// Seek positions the cursor at key, or the smallest key after it if it is not in the tree
+func (c *TreeCursor) Seek(key uint64) error {
+	found, _, err := c.tree.find(key)
+	if err != nil {
+		return err
+	}
+	return c.load(found.Node, found.Index)
+}
+
+// seekBefore positions the cursor at the largest key before key
+func (c *TreeCursor) seekBefore(key uint64) error {
+	// The nearest subtree to the left of the path to key holds the largest key before it, if the
+	// leaf key belongs in holds none
+	n, err := c.tree.node(c.tree.rootPageNum)
+	if err != nil {
+		return err
+	}
+	left := uint32(0)
+	for n.Type() == InternalNode {
+		index := n.internalKeyIndex(key)
+		if index > 0 {
+			left = n.ChildPointer(index - 1)
+		}
+		if n, err = c.tree.node(n.ChildPointer(index)); err != nil {
+			return err
+		}
+	}
+	if n.Type() != LeafNode {
+		return corruptf("page %d has unknown node type %d", n.pageNum, n.Type())
+	}
+
+	found, _ := c.tree.leafNodeFind(n, key)
+	if found.Index > 0 {
+		return c.load(n, found.Index-1)
+	}
+	if left == 0 {
+		c.valid = false
+		return nil
+	}
+	return c.last(left)
+}
+
+// Next moves the cursor to the next key, leaving it invalid after the last one
+func (c *TreeCursor) Next() error {
+	if !c.valid {
+		return nil
+	}
+	n, err := c.tree.node(c.pageNum)
+	if err != nil {
+		return err
+	}
+	return c.load(n, c.index+1)
+}
+
+// Prev moves the cursor to the previous key, leaving it invalid before the first one
+func (c *TreeCursor) Prev() error {
+	if !c.valid {
+		return nil
+	}
+	if c.index == 0 {
+		return c.seekBefore(c.key)
+	}
+	n, err := c.tree.node(c.pageNum)
+	if err != nil {
+		return err
+	}
+	return c.load(n, c.index-1)
+}
+
+// load positions the cursor at a cell of a leaf, following the links to later leaves if the leaf has
+// no cell there
+func (c *TreeCursor) load(n *Node, index uint16) error {
+	for index >= n.NumCells() {
+		next := n.GetNextLeaf()
+		if next == 0 {
+			c.valid = false
+			return nil
+		}
+		var err error
+		if n, err = c.tree.node(next); err != nil {
+			return err
+		}
+		if n.Type() != LeafNode {
+			return corruptf("leaf links to page %d which is not a leaf", next)
+		}
+		index = 0
+	}
+	c.pageNum, c.index = n.pageNum, index
+	c.key, c.item = n.GetNodeKey(index), n.GetNodeValue(index)
+	c.valid = true
+	return nil
+}
+
+// descend follows child from pageNum down to a leaf
+func (t *Tree) descend(pageNum uint32, child func(n *Node) uint32) (*Node, error) {
+	n, err := t.node(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	for n.Type() == InternalNode {
+		if n, err = t.node(child(n)); err != nil {
+			return nil, err
+		}
+	}
+	if n.Type() != LeafNode {
+		return nil, corruptf("page %d has unknown node type %d", n.pageNum, n.Type())
+	}
+	return n, nil
+}
+
+// Ceiling returns the smallest key in the tree no less than key and its item, and whether there is one
+func (t *Tree) Ceiling(key uint64) (uint64, IndexItem, bool, error) {
+	c := t.Cursor()
+	if err := c.Seek(key); err != nil {
+		return 0, IndexItem{}, false, err
+	}
+	return c.key, c.item, c.valid, nil
+}
+
+// Floor returns the largest key in the tree no greater than key and its item, and whether there is one
+func (t *Tree) Floor(key uint64) (uint64, IndexItem, bool, error) {
+	c := t.Cursor()
+	if err := c.Seek(key); err != nil {
+		return 0, IndexItem{}, false, err
+	}
+	if !c.valid || c.key != key {
+		if err := c.seekBefore(key); err != nil {
+			return 0, IndexItem{}, false, err
+		}
+	}
+	return c.key, c.item, c.valid, nil
+}
+
+// Min returns the smallest key in the tree and its item, and whether the tree has any keys
+func (t *Tree) Min() (uint64, IndexItem, bool, error) {
+	c := t.Cursor()
+	if err := c.First(); err != nil {
+		return 0, IndexItem{}, false, err
+	}
+	return c.key, c.item, c.valid, nil
+}
+
+// Max returns the largest key in the tree and its item, and whether the tree has any keys
+func (t *Tree) Max() (uint64, IndexItem, bool, error) {
+	c := t.Cursor()
+	if err := c.Last(); err != nil {
+		return 0, IndexItem{}, false, err
+	}
+	return c.key, c.item, c.valid, nil
+}
+
+// LinkLeaves points every leaf at the leaf after it. Trees written before the links were kept up to
+// date need this once.
+func (t *Tree) LinkLeaves() error {
+	leaves := []uint32{}
+	toVisit := []uint32{t.rootPageNum}
+	for len(toVisit) > 0 {
+		pageNum := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+
+		n, err := t.node(pageNum)
+		if err != nil {
+			return err
+		}
+		switch n.Type() {
+		case LeafNode:
+			leaves = append(leaves, pageNum)
+		case InternalNode:
+			// Children are pushed right to left so the leaves are reached in key order
+			for i := int(n.NumKeys()); i >= 0; i-- {
+				toVisit = append(toVisit, n.ChildPointer(uint16(i)))
+			}
+		default:
+			return corruptf("page %d has unknown node type %d", pageNum, n.Type())
+		}
+	}
+
+	for i, pageNum := range leaves {
+		leaf, err := t.dirtyNode(pageNum)
+		if err != nil {
+			return err
+		}
+		next := uint32(0)
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		leaf.SetNextLeaf(next)
+	}
+	return nil
+}
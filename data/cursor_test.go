@@ -0,0 +1,209 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCursorWalksKeysInOrder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	filePager, _ := OpenFilePager(filename, Options{PageSize: MinPageSize, CacheSize: MinCacheSize})
+	defer filePager.Close()
+
+	const numKeys = 5000
+	tests := []struct {
+		name  string
+		pager Pager
+	}{
+		{"memory", NewMemoryPager(MinPageSize)},
+		{"small cache", filePager},
+	}
+
+	for _, test := range tests {
+		tree := newTestTree(test.pager)
+		for i := uint64(0); i < numKeys; i++ {
+			key := (i * 7919) % numKeys
+			tree.Insert(key, IndexItem{uint32(key), 0, uint32(key) + 1})
+		}
+
+		c := tree.Cursor()
+		next := uint64(0)
+		for err := c.First(); c.Valid(); err = c.Next() {
+			if err != nil {
+				t.Fatalf("%s: unexpected error, got %+v", test.name, err)
+			}
+			if c.Key() != next || uint64(c.Item().Length) != next+1 {
+				t.Fatalf("%s: incorrect position, expected %d, got %d, %+v", test.name, next, c.Key(), c.Item())
+			}
+			next++
+		}
+		if next != numKeys {
+			t.Errorf("%s: incorrect number of keys walked forwards, expected %d, got %d", test.name, numKeys, next)
+		}
+
+		count := uint64(0)
+		for err := c.Last(); c.Valid(); err = c.Prev() {
+			if err != nil {
+				t.Fatalf("%s: unexpected error, got %+v", test.name, err)
+			}
+			if expected := numKeys - 1 - count; c.Key() != expected {
+				t.Fatalf("%s: incorrect position, expected %d, got %d", test.name, expected, c.Key())
+			}
+			count++
+		}
+		if count != numKeys {
+			t.Errorf("%s: incorrect number of keys walked backwards, expected %d, got %d", test.name, numKeys, count)
+		}
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	tree := newTestTree(NewMemoryPager(MinPageSize))
+	for i := uint64(0); i < 2000; i += 2 {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+
+	c := tree.Cursor()
+	for key := uint64(0); key < 1999; key++ {
+		if err := c.Seek(key); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+		expected := key + key%2
+		if !c.Valid() || c.Key() != expected {
+			t.Fatalf("incorrect position seeking %d, expected %d, got %d, %t", key, expected, c.Key(), c.Valid())
+		}
+		if key%2 == 1 {
+			// The key before the one sought is the one before the gap
+			if err := c.Prev(); err != nil || !c.Valid() || c.Key() != key-1 {
+				t.Fatalf("incorrect position before %d, expected %d, got %d, %t, %+v", expected, key-1, c.Key(), c.Valid(), err)
+			}
+		}
+	}
+
+	if c.Seek(1999); c.Valid() {
+		t.Errorf("expected no key after the last, got %d", c.Key())
+	}
+	c.Seek(0)
+	if c.Prev(); c.Valid() {
+		t.Errorf("expected no key before the first, got %d", c.Key())
+	}
+}
+
+func TestCursorOnEmptyTree(t *testing.T) {
+	tree := newTestTree(NewMemoryPager(MinPageSize))
+	c := tree.Cursor()
+	if c.Valid() {
+		t.Errorf("expected a new cursor to be invalid")
+	}
+	for name, move := range map[string]func() error{"First": c.First, "Last": c.Last, "Seek": func() error { return c.Seek(1) }} {
+		if err := move(); err != nil || c.Valid() {
+			t.Errorf("%s: expected an invalid cursor, got %t, %+v", name, c.Valid(), err)
+		}
+	}
+}
+
+func TestCursorAfterDeletes(t *testing.T) {
+	tree := newTestTree(newTestFreeList(NewMemoryPager(MinPageSize)))
+	for i := uint64(0); i < 3000; i++ {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+	// Leave every third key, merging most of the leaves
+	for i := uint64(0); i < 3000; i++ {
+		if i%3 != 0 {
+			if err := tree.Delete(i); err != nil {
+				t.Fatalf("unexpected error deleting %d, got %+v", i, err)
+			}
+		}
+	}
+
+	c := tree.Cursor()
+	next := uint64(0)
+	for c.First(); c.Valid(); c.Next() {
+		if c.Key() != next {
+			t.Fatalf("incorrect position, expected %d, got %d", next, c.Key())
+		}
+		next += 3
+	}
+	if next != 3000 {
+		t.Errorf("incorrect last key, expected %d, got %d", 2997, next-3)
+	}
+}
+
+func TestLinkLeaves(t *testing.T) {
+	tree := newTestTree(NewMemoryPager(MinPageSize))
+	for i := uint64(0); i < 2000; i++ {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+
+	// Unlink the leaves, as trees written before the links were kept were
+	pages, _ := tree.Pages()
+	for _, pageNum := range pages {
+		if n, _ := tree.node(pageNum); n.Type() == LeafNode {
+			n.SetNextLeaf(0)
+		}
+	}
+	if problems := tree.Check(nil); len(problems) == 0 {
+		t.Fatalf("expected problems with unlinked leaves")
+	}
+
+	if err := tree.LinkLeaves(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if problems := tree.Check(nil); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+}
+
+func TestFloorAndCeiling(t *testing.T) {
+	tree := newTestTree(NewMemoryPager(MinPageSize))
+	for i := uint64(10); i <= 2000; i += 10 {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+
+	tests := []struct {
+		key        uint64
+		floor      uint64
+		hasFloor   bool
+		ceiling    uint64
+		hasCeiling bool
+	}{
+		{0, 0, false, 10, true},
+		{10, 10, true, 10, true},
+		{15, 10, true, 20, true},
+		{999, 990, true, 1000, true},
+		{1000, 1000, true, 1000, true},
+		{2000, 2000, true, 2000, true},
+		{2001, 2000, true, 0, false},
+	}
+	for _, test := range tests {
+		key, item, found, err := tree.Floor(test.key)
+		if err != nil || found != test.hasFloor || (found && (key != test.floor || uint64(item.PageNum) != key)) {
+			t.Errorf("incorrect floor of %d, expected %d, %t, got %d, %t, %+v", test.key, test.floor, test.hasFloor, key, found, err)
+		}
+		key, item, found, err = tree.Ceiling(test.key)
+		if err != nil || found != test.hasCeiling || (found && (key != test.ceiling || uint64(item.PageNum) != key)) {
+			t.Errorf("incorrect ceiling of %d, expected %d, %t, got %d, %t, %+v", test.key, test.ceiling, test.hasCeiling, key, found, err)
+		}
+	}
+}
+
+func TestMinAndMax(t *testing.T) {
+	tree := newTestTree(NewMemoryPager(MinPageSize))
+	if _, _, found, err := tree.Min(); found || err != nil {
+		t.Errorf("expected no minimum of an empty tree, got %t, %+v", found, err)
+	}
+	if _, _, found, err := tree.Max(); found || err != nil {
+		t.Errorf("expected no maximum of an empty tree, got %t, %+v", found, err)
+	}
+
+	for i := uint64(0); i < 3000; i++ {
+		key := 5 + (i*7919)%3000
+		tree.Insert(key, IndexItem{uint32(key), 0, 1})
+	}
+	if key, item, found, err := tree.Min(); !found || err != nil || key != 5 || item.PageNum != 5 {
+		t.Errorf("incorrect minimum, expected %d, got %d, %t, %+v", 5, key, found, err)
+	}
+	if key, item, found, err := tree.Max(); !found || err != nil || key != 3004 || item.PageNum != 3004 {
+		t.Errorf("incorrect maximum, expected %d, got %d, %t, %+v", 3004, key, found, err)
+	}
+}
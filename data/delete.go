@@ -0,0 +1,201 @@
+package data
+
+// Delete removes key from the tree, or returns ErrKeyNotFound. A node left with too few cells borrows
+// one from a sibling, or is merged with it, and the pages of merged nodes are freed. The tree's pager
+// must have a free list, or Delete returns ErrNoFreeList without changing anything.
+func (t *Tree) Delete(key uint64) error {
+	// Freeing a page only fails once the tree has been changed part way through a merge
+	if !hasFreeList(t.pager) {
+		return ErrNoFreeList
+	}
+	c, found, err := t.find(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	if err := t.pager.MarkDirty(c.Node.pageNum); err != nil {
+		return err
+	}
+	c.Node.removeNodeCell(c.Index)
+	return t.rebalance(c.Node.pageNum)
+}
+
+// underflows reports whether a node other than the root has too few cells
+func underflows(n *Node) bool {
+	if n.Type() == LeafNode {
+		return n.NumCells() < LeafNodeMinCells(n.pageSize())
+	}
+	return n.NumKeys() < InternalNodeMinCells(n.pageSize())
+}
+
+// rebalance tops up a node that may have lost a cell, working up the tree as merges take cells from
+// the parents
+func (t *Tree) rebalance(pageNum uint32) error {
+	n, err := t.node(pageNum)
+	if err != nil {
+		return err
+	}
+	if t.isRoot(n) {
+		if n.Type() == InternalNode && n.NumKeys() == 0 {
+			return t.collapseRoot()
+		}
+		return nil
+	}
+	if !underflows(n) {
+		return nil
+	}
+
+	parent, err := t.dirtyNode(n.ParentPointer())
+	if err != nil {
+		return err
+	}
+	index, found := parent.childIndex(pageNum)
+	if !found {
+		return corruptf("page %d is not a child of its parent %d", pageNum, parent.pageNum)
+	}
+
+	// The node is paired with its left sibling, or its right one if it is the first child
+	leftIndex := index
+	if index > 0 {
+		leftIndex = index - 1
+	}
+	left, err := t.dirtyNode(parent.ChildPointer(leftIndex))
+	if err != nil {
+		return err
+	}
+	right, err := t.dirtyNode(parent.ChildPointer(leftIndex + 1))
+	if err != nil {
+		return err
+	}
+	if left.Type() != right.Type() {
+		return corruptf("pages %d and %d are siblings of different types", left.pageNum, right.pageNum)
+	}
+
+	if n.Type() == LeafNode {
+		return t.rebalanceLeaves(parent, leftIndex, left, right)
+	}
+	return t.rebalanceInternal(parent, leftIndex, left, right)
+}
+
+// rebalanceLeaves merges two neighbouring leaves, or moves a cell from the fuller one to the other
+func (t *Tree) rebalanceLeaves(parent *Node, leftIndex uint16, left *Node, right *Node) error {
+	leftCells, rightCells := left.NumCells(), right.NumCells()
+	if leftCells+rightCells <= LeafNodeMaxCells(left.pageSize()) {
+		for i := uint16(0); i < rightCells; i++ {
+			left.setNodeCell(leftCells+i, right.getNodeCell(i))
+		}
+		left.SetNumCells(leftCells + rightCells)
+		left.SetNextLeaf(right.GetNextLeaf())
+		return t.removeMergedChild(parent, leftIndex, left.pageNum, right.pageNum, nil)
+	}
+
+	if leftCells > rightCells {
+		for i := rightCells; i > 0; i-- {
+			right.setNodeCell(i, right.getNodeCell(i-1))
+		}
+		right.setNodeCell(0, left.getNodeCell(leftCells-1))
+		right.SetNumCells(rightCells + 1)
+		left.SetNumCells(leftCells - 1)
+	} else {
+		left.setNodeCell(leftCells, right.getNodeCell(0))
+		left.SetNumCells(leftCells + 1)
+		right.removeNodeCell(0)
+	}
+	parent.SetInternalKey(leftIndex, left.GetNodeKey(left.NumCells()-1))
+	return nil
+}
+
+/*
+rebalanceInternal merges two neighbouring internal nodes, bringing the key between them down from the
+parent, or rotates a child from the fuller one to the other through the parent.
+*/
+func (t *Tree) rebalanceInternal(parent *Node, leftIndex uint16, left *Node, right *Node) error {
+	separator := parent.InternalKey(leftIndex)
+	leftKeys, rightKeys := left.NumKeys(), right.NumKeys()
+	if leftKeys+1+rightKeys <= InternalNodeMaxCells(left.pageSize()) {
+		// The left node's right child becomes an ordinary cell, bounded by the separator
+		moved := make([]uint32, 0, rightKeys+1)
+		leftRightChild := left.RightChild()
+		left.SetNumKeys(leftKeys + 1 + rightKeys)
+		left.SetChildPointer(leftKeys, leftRightChild)
+		left.SetInternalKey(leftKeys, separator)
+		for i := uint16(0); i < rightKeys; i++ {
+			left.SetChildPointer(leftKeys+1+i, right.ChildPointer(i))
+			left.SetInternalKey(leftKeys+1+i, right.InternalKey(i))
+			moved = append(moved, right.ChildPointer(i))
+		}
+		left.SetRightChild(right.RightChild())
+		moved = append(moved, right.RightChild())
+		return t.removeMergedChild(parent, leftIndex, left.pageNum, right.pageNum, moved)
+	}
+
+	var movedChild, newParent uint32
+	if leftKeys > rightKeys {
+		// The left node's right child becomes the right node's first child
+		movedChild, newParent = left.RightChild(), right.pageNum
+		right.SetNumKeys(rightKeys + 1)
+		for i := rightKeys; i > 0; i-- {
+			right.moveInternalCell(i-1, i)
+		}
+		right.SetChildPointer(0, movedChild)
+		right.SetInternalKey(0, separator)
+		parent.SetInternalKey(leftIndex, left.InternalKey(leftKeys-1))
+		left.SetRightChild(left.ChildPointer(leftKeys - 1))
+		left.SetNumKeys(leftKeys - 1)
+	} else {
+		// The right node's first child becomes the left node's right child
+		movedChild, newParent = right.ChildPointer(0), left.pageNum
+		leftRightChild := left.RightChild()
+		left.SetNumKeys(leftKeys + 1)
+		left.SetChildPointer(leftKeys, leftRightChild)
+		left.SetInternalKey(leftKeys, separator)
+		left.SetRightChild(movedChild)
+		parent.SetInternalKey(leftIndex, right.InternalKey(0))
+		right.removeInternalCell(0)
+	}
+	return t.setParent([]uint32{movedChild}, newParent)
+}
+
+/*
+removeMergedChild drops the right node of a merged pair from the parent, frees its page and points
+the children moved out of it at the left node. The parent is then rebalanced, as it has lost a key.
+*/
+func (t *Tree) removeMergedChild(parent *Node, leftIndex uint16, leftPageNum uint32, rightPageNum uint32, moved []uint32) error {
+	parentPageNum := parent.pageNum
+	parent.SetChildPointer(leftIndex+1, leftPageNum)
+	parent.removeInternalCell(leftIndex)
+
+	if err := t.setParent(moved, leftPageNum); err != nil {
+		return err
+	}
+	if err := t.pager.FreePage(rightPageNum); err != nil {
+		return err
+	}
+	return t.rebalance(parentPageNum)
+}
+
+// collapseRoot replaces a root left with a single child by that child, so the root page never moves
+func (t *Tree) collapseRoot() error {
+	root, err := t.dirtyNode(t.rootPageNum)
+	if err != nil {
+		return err
+	}
+	childPageNum := root.RightChild()
+	child, err := t.node(childPageNum)
+	if err != nil {
+		return err
+	}
+
+	copy(*root.page, *child.page)
+	root.SetIsRoot(true)
+	root.SetParentPointer(0)
+
+	if root.Type() == InternalNode {
+		if err := t.setParentPointers(root, t.rootPageNum); err != nil {
+			return err
+		}
+	}
+	return t.pager.FreePage(childPageNum)
+}
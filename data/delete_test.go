@@ -0,0 +1,143 @@
+package data
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteManyKeys(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	filePager, _ := OpenFilePager(filename, Options{PageSize: MinPageSize, CacheSize: MinCacheSize})
+	defer filePager.Close()
+
+	const numKeys = 5000
+	shuffled := func(i uint64) uint64 { return (i * 7919) % numKeys }
+	tests := []struct {
+		name        string
+		pager       Pager
+		insertOrder func(i uint64) uint64
+		deleteOrder func(i uint64) uint64
+	}{
+		{"ascending", NewMemoryPager(MinPageSize), shuffled, func(i uint64) uint64 { return i }},
+		{"descending", NewMemoryPager(MinPageSize), shuffled, func(i uint64) uint64 { return numKeys - 1 - i }},
+		{"shuffled", NewMemoryPager(MinPageSize), func(i uint64) uint64 { return i }, shuffled},
+		{"alternate ends", NewMemoryPager(MinPageSize), shuffled, func(i uint64) uint64 {
+			if i%2 == 0 {
+				return i / 2
+			}
+			return numKeys - 1 - i/2
+		}},
+		{"small cache", filePager, shuffled, func(i uint64) uint64 { return (i * 104729) % numKeys }},
+	}
+
+	for _, test := range tests {
+		freeList := newTestFreeList(test.pager)
+		tree := newTestTree(freeList)
+		for i := uint64(0); i < numKeys; i++ {
+			key := test.insertOrder(i)
+			if err := tree.Insert(key, IndexItem{uint32(key), 0, uint32(key) + 1}); err != nil {
+				t.Fatalf("%s: unexpected error inserting %d, got %+v", test.name, key, err)
+			}
+		}
+
+		deleted := make(map[uint64]bool)
+		for i := uint64(0); i < numKeys; i++ {
+			key := test.deleteOrder(i)
+			if err := tree.Delete(key); err != nil {
+				t.Fatalf("%s: unexpected error deleting %d, got %+v", test.name, key, err)
+			}
+			deleted[key] = true
+
+			if i%500 != 0 {
+				continue
+			}
+			if problems := tree.Check(nil); len(problems) != 0 {
+				t.Fatalf("%s: unexpected problems after deleting %d keys, got %+v", test.name, i+1, problems)
+			}
+			for k := uint64(0); k < numKeys; k++ {
+				item, err := tree.Get(k)
+				if deleted[k] && !errors.Is(err, ErrKeyNotFound) {
+					t.Fatalf("%s: expected %v for deleted key %d, got %+v", test.name, ErrKeyNotFound, k, err)
+				}
+				if !deleted[k] && (err != nil || uint64(item.Length) != k+1) {
+					t.Fatalf("%s: incorrect value for key %d, got %+v, %+v", test.name, k, item, err)
+				}
+			}
+		}
+
+		// Every page but the root has been merged away and handed back
+		if problems := tree.Check(nil); len(problems) != 0 {
+			t.Errorf("%s: unexpected problems, got %+v", test.name, problems)
+		}
+		root, _ := tree.node(tree.rootPageNum)
+		if root.Type() != LeafNode || root.NumCells() != 0 {
+			t.Errorf("%s: expected an empty root leaf, got %s with %d cells", test.name, root.Type(), root.NumCells())
+		}
+		freePages, _ := freeList.FreePageCount()
+		if expected := freeList.GetNextUnusedPageNum() - 2; freePages != expected {
+			t.Errorf("%s: incorrect number of free pages, expected %d, got %d", test.name, expected, freePages)
+		}
+	}
+}
+
+func TestDeleteMissingKey(t *testing.T) {
+	tree := newTestTree(newTestFreeList(NewMemoryPager(MinPageSize)))
+	for i := uint64(0); i < 100; i += 2 {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+
+	if err := tree.Delete(51); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, got %+v", ErrKeyNotFound, err)
+	}
+	if err := tree.Delete(50); err != nil {
+		t.Errorf("unexpected error, got %+v", err)
+	}
+	if err := tree.Delete(50); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, got %+v", ErrKeyNotFound, err)
+	}
+}
+
+func TestDeletedPagesAreReused(t *testing.T) {
+	freeList := newTestFreeList(NewMemoryPager(MinPageSize))
+	tree := newTestTree(freeList)
+	for i := uint64(0); i < 2000; i++ {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+	numPages := freeList.GetNextUnusedPageNum()
+
+	for i := uint64(0); i < 2000; i++ {
+		if err := tree.Delete(i); err != nil {
+			t.Fatalf("unexpected error deleting %d, got %+v", i, err)
+		}
+	}
+	for i := uint64(0); i < 2000; i++ {
+		if err := tree.Insert(i, IndexItem{uint32(i), 0, 1}); err != nil {
+			t.Fatalf("unexpected error inserting %d, got %+v", i, err)
+		}
+	}
+
+	if freeList.GetNextUnusedPageNum() != numPages {
+		t.Errorf("incorrect number of pages, expected %d, got %d", numPages, freeList.GetNextUnusedPageNum())
+	}
+	if problems := tree.Check(nil); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+}
+
+func TestDeleteWithoutFreeList(t *testing.T) {
+	tree := newTestTree(NewMemoryPager(MinPageSize))
+	for i := uint64(0); i < 2000; i++ {
+		tree.Insert(i, IndexItem{uint32(i), 0, 1})
+	}
+
+	if err := tree.Delete(0); !errors.Is(err, ErrNoFreeList) {
+		t.Errorf("expected %v, got %+v", ErrNoFreeList, err)
+	}
+	if _, err := tree.Get(0); err != nil {
+		t.Errorf("expected key to still be in the tree, got %+v", err)
+	}
+	if problems := tree.Check(nil); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+}
@@ -0,0 +1,374 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+Encryption
+++++++++++
+An encrypted db file starts with a plaintext header page identifying it as an encrypted klite
+database, holding its page size and a check that the key is the right one. Every other page holds a
+page of the database sealed with AES-GCM under a nonce of its own, with the number of the page it is
+in as additional data so pages cannot be swapped around.
+
+Header: Magic, PageSize, KeyCheck (Nonce, Tag)
+Page: Nonce, Ciphertext, Tag, Checksum
+*/
+const (
+	EncryptedMagic          = "klite encrypted\x00"
+	EncryptedMagicOffset    = 0
+	EncryptedMagicSize      = 16
+	EncryptedPageSizeOffset = EncryptedMagicOffset + EncryptedMagicSize
+	EncryptedPageSizeSize   = 4
+	EncryptedKeyCheckOffset = EncryptedPageSizeOffset + EncryptedPageSizeSize
+	EncryptedKeyCheckSize   = EncryptionOverhead
+	EncryptedHeaderSize     = EncryptedKeyCheckOffset + EncryptedKeyCheckSize
+
+	EncryptionNonceSize = 12
+	EncryptionTagSize   = 16
+	// EncryptionOverhead is how much smaller an encrypted database's pages are than the file's
+	EncryptionOverhead = EncryptionNonceSize + EncryptionTagSize
+)
+
+// EncryptedPageSize returns the page size recorded in the header of an encrypted db file, and
+// whether header is the start of one
+func EncryptedPageSize(header []byte) (int, bool) {
+	if len(header) < EncryptedPageSizeOffset+EncryptedPageSizeSize || string(header[EncryptedMagicOffset:EncryptedMagicOffset+EncryptedMagicSize]) != EncryptedMagic {
+		return 0, false
+	}
+	return int(binary.LittleEndian.Uint32(header[EncryptedPageSizeOffset : EncryptedPageSizeOffset+EncryptedPageSizeSize])), true
+}
+
+// ReadKeyFile reads an encryption key written hex encoded in a file
+func ReadKeyFile(filename string) ([]byte, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s is not hex encoded: %w", filename, err)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pageAdditionalData ties a sealed page to the page of the file it is written in
+func pageAdditionalData(filePageNum uint32) []byte {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, filePageNum)
+	return data
+}
+
+/*
+EncryptedPager encrypts the pages of another pager. Page n of the database is page n+1 of the
+wrapped pager, page 0 being the plaintext header. Pages are decrypted into a cache of their own and
+encrypted again when they are flushed, or evicted while dirty, so the wrapped pager, its journal and
+the db file only ever hold ciphertext.
+
+The pages are EncryptionOverhead bytes and a checksum smaller than the wrapped pager's, as each holds
+a nonce and tag besides the page.
+*/
+type EncryptedPager struct {
+	inner       Pager
+	aead        cipher.AEAD
+	cache       *pageCache
+	readOnly    bool
+	changeCount uint64
+}
+
+// NewEncryptedPager encrypts the pages of inner with opts.Key, which must be 16, 24 or 32 bytes
+// long for AES-128, AES-192 or AES-256. An empty inner pager is given a header for the key.
+func NewEncryptedPager(inner Pager, opts Options) (*EncryptedPager, error) {
+	aead, err := newAEAD(opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	p := &EncryptedPager{inner: inner, aead: aead, cache: newPageCache(opts.CacheSize), readOnly: opts.ReadOnly}
+
+	if inner.GetNextUnusedPageNum() == 0 {
+		if opts.ReadOnly {
+			return nil, ErrNotEncrypted
+		}
+		if err := p.writeHeader(aead); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	header, err := inner.Page(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, encrypted := EncryptedPageSize(*header); !encrypted {
+		return nil, ErrNotEncrypted
+	}
+	keyCheck := (*header)[EncryptedKeyCheckOffset : EncryptedKeyCheckOffset+EncryptedKeyCheckSize]
+	if _, err := aead.Open(nil, keyCheck[:EncryptionNonceSize], keyCheck[EncryptionNonceSize:], []byte(EncryptedMagic)); err != nil {
+		return nil, ErrWrongKey
+	}
+	return p, nil
+}
+
+// writeHeader writes the header page, with a check for the key aead was made with
+func (p *EncryptedPager) writeHeader(aead cipher.AEAD) error {
+	header, err := p.inner.Page(0)
+	if err != nil {
+		return err
+	}
+	if err := p.inner.MarkDirty(0); err != nil {
+		return err
+	}
+	copy((*header)[EncryptedMagicOffset:EncryptedMagicOffset+EncryptedMagicSize], EncryptedMagic)
+	binary.LittleEndian.PutUint32((*header)[EncryptedPageSizeOffset:EncryptedPageSizeOffset+EncryptedPageSizeSize], uint32(p.inner.PageSize()))
+
+	keyCheck := (*header)[EncryptedKeyCheckOffset : EncryptedKeyCheckOffset+EncryptedKeyCheckSize]
+	nonce := keyCheck[:EncryptionNonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	aead.Seal(keyCheck[EncryptionNonceSize:EncryptionNonceSize], nonce, nil, []byte(EncryptedMagic))
+	return nil
+}
+
+// seal encrypts a page into its page of the wrapped pager
+func (p *EncryptedPager) seal(aead cipher.AEAD, pageNum uint32, page Page) error {
+	filePage, err := p.inner.Page(pageNum + 1)
+	if err != nil {
+		return err
+	}
+	if err := p.inner.MarkDirty(pageNum + 1); err != nil {
+		return err
+	}
+	nonce := (*filePage)[:EncryptionNonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	aead.Seal((*filePage)[EncryptionNonceSize:EncryptionNonceSize], nonce, page, pageAdditionalData(pageNum+1))
+	return nil
+}
+
+// open decrypts a page from its page of the wrapped pager
+func (p *EncryptedPager) open(pageNum uint32) (Page, error) {
+	filePage, err := p.inner.Page(pageNum + 1)
+	if err != nil {
+		return nil, err
+	}
+	sealed := (*filePage)[:filePage.UsableSize()]
+	page, err := p.aead.Open(nil, sealed[:EncryptionNonceSize], sealed[EncryptionNonceSize:], pageAdditionalData(pageNum+1))
+	if err != nil {
+		return nil, corruptf("page %d could not be decrypted", pageNum)
+	}
+	return page, nil
+}
+
+func (p *EncryptedPager) Page(pageNum uint32) (*Page, error) {
+	if err := checkPageNum(pageNum + 1); err != nil {
+		return nil, err
+	}
+	if _, err := p.beginRead(); err != nil {
+		return nil, err
+	}
+
+	page, found := p.cache.get(pageNum)
+	if !found {
+		if err := p.evict(); err != nil {
+			return nil, err
+		}
+
+		dirty := false
+		if pageNum < p.GetNextUnusedPageNum() {
+			var err error
+			if page, err = p.open(pageNum); err != nil {
+				return nil, err
+			}
+		} else {
+			if p.readOnly {
+				return nil, fmt.Errorf("%w: page %d does not exist", ErrPageOutOfBounds, pageNum)
+			}
+			// Growing the wrapped pager keeps the number of pages right until the page is sealed
+			if _, err := p.inner.Page(pageNum + 1); err != nil {
+				return nil, err
+			}
+			page = make([]byte, p.PageSize())
+			dirty = true
+		}
+		p.cache.put(pageNum, page, dirty)
+	}
+	return &page, nil
+}
+
+// beginRead starts a read transaction on the wrapped pager, dropping the decrypted pages if it does
+func (p *EncryptedPager) beginRead() (bool, error) {
+	started, err := beginRead(p.inner)
+	if started {
+		p.cache.truncate(0)
+	}
+	return started, err
+}
+
+func (p *EncryptedPager) endRead() {
+	endRead(p.inner)
+}
+
+// evict makes room in the cache for another page, sealing dirty pages into the wrapped pager
+func (p *EncryptedPager) evict() error {
+	for p.cache.full() {
+		victim := p.cache.victim()
+		if victim.dirty {
+			if err := p.seal(p.aead, victim.pageNum, victim.page); err != nil {
+				return err
+			}
+		}
+		p.cache.remove(victim.pageNum)
+	}
+	return nil
+}
+
+func (p *EncryptedPager) MarkDirty(pageNum uint32) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if !p.cache.markDirty(pageNum) {
+		return fmt.Errorf("page %d is not in the cache", pageNum)
+	}
+	p.changeCount++
+	return nil
+}
+
+func (p *EncryptedPager) GetNextUnusedPageNum() uint32 {
+	return p.inner.GetNextUnusedPageNum() - 1
+}
+
+func (p *EncryptedPager) AllocatePage() (uint32, error) {
+	if p.readOnly {
+		return 0, ErrReadOnly
+	}
+	return appendPage(p)
+}
+
+func (p *EncryptedPager) FreePage(pageNum uint32) error {
+	return ErrNoFreeList
+}
+
+func (p *EncryptedPager) PageSize() int {
+	return p.inner.PageSize() - EncryptionOverhead - PageReservedSize
+}
+
+func (p *EncryptedPager) ChangeCount() uint64 {
+	return p.changeCount
+}
+
+func (p *EncryptedPager) Truncate(numPages uint32) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	p.cache.truncate(numPages)
+	p.changeCount++
+	return p.inner.Truncate(numPages + 1)
+}
+
+// Stats returns the stats of the wrapped pager, with the cache counts of the decrypted pages
+func (p *EncryptedPager) Stats() Stats {
+	stats := p.inner.Stats()
+	cache := p.cache.stats()
+	stats.CacheHits, stats.CacheMisses = cache.Hits, cache.Misses
+	stats.CachedPages, stats.CacheCapacity = cache.Size, cache.Capacity
+	return stats
+}
+
+// Flush seals the dirty pages into the wrapped pager, then flushes it
+func (p *EncryptedPager) Flush() error {
+	for _, entry := range p.cache.dirtyPages() {
+		if err := p.seal(p.aead, entry.pageNum, entry.page); err != nil {
+			return err
+		}
+		entry.dirty = false
+	}
+	return p.inner.Flush()
+}
+
+/*
+Rekey encrypts every page again with a new key. The pages and the header are written through the
+wrapped pager as a single batch, so if it has a journal a crash leaves the database under either the
+old key or the new one.
+
+If sealing the pages fails part way, the pages already sealed under the new key are discarded from
+the wrapped pager, so the database carries on under the old key. A MemoryPager cannot discard them.
+*/
+func (p *EncryptedPager) Rekey(key []byte) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	if err := p.Flush(); err != nil {
+		return err
+	}
+
+	if err := p.sealAll(aead); err != nil {
+		if inner, ok := p.inner.(discarder); ok {
+			if discardErr := inner.Discard(); discardErr != nil {
+				return fmt.Errorf("%w, then discarding the pages sealed under the new key: %v", err, discardErr)
+			}
+		}
+		return err
+	}
+	p.aead = aead
+	return p.inner.Flush()
+}
+
+// sealAll seals every page and the header with aead. Every page must be clean, so each is read under
+// the old key before being sealed under the new one.
+func (p *EncryptedPager) sealAll(aead cipher.AEAD) error {
+	for pageNum := uint32(0); pageNum < p.GetNextUnusedPageNum(); pageNum++ {
+		page, err := p.Page(pageNum)
+		if err != nil {
+			return err
+		}
+		if err := p.seal(aead, pageNum, *page); err != nil {
+			return err
+		}
+	}
+	return p.writeHeader(aead)
+}
+
+// Discard drops the dirty pages, and every change made to the wrapped pager since it was last
+// flushed, see Discard
+func (p *EncryptedPager) Discard() error {
+	if p.readOnly {
+		return nil
+	}
+	p.cache.discardDirty()
+	p.changeCount++
+	return Discard(p.inner)
+}
+
+func (p *EncryptedPager) Close() error {
+	var err error
+	if !p.readOnly {
+		err = p.Flush()
+	}
+	if closeErr := p.inner.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
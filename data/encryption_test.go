@@ -0,0 +1,194 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testKey    = bytes.Repeat([]byte{0x1}, 32)
+	testNewKey = bytes.Repeat([]byte{0x2}, 16)
+	// secret is written into every page, and must never reach the file
+	secret = []byte("not for the disk")
+)
+
+// secretPage writes secret into a page, followed by its page number, see fillPages
+func secretPage(pageNum uint32, page Page) {
+	copy(page, secret)
+	page[len(secret)] = byte(pageNum)
+}
+
+func checkSecretPages(t *testing.T, name string, pager Pager, numPages uint32) {
+	t.Helper()
+	if pager.GetNextUnusedPageNum() != numPages {
+		t.Errorf("%s: incorrect number of pages, expected %d, got %d", name, numPages, pager.GetNextUnusedPageNum())
+	}
+	for pageNum := uint32(0); pageNum < numPages; pageNum++ {
+		page, err := pager.Page(pageNum)
+		if err != nil {
+			t.Fatalf("%s: unexpected error reading page %d, got %+v", name, pageNum, err)
+		}
+		if !bytes.HasPrefix(*page, secret) || (*page)[len(secret)] != byte(pageNum) {
+			t.Errorf("%s: incorrect contents for page %d", name, pageNum)
+		}
+	}
+}
+
+func TestEncryptedPager(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		opts := pt.opts
+		opts.Key = testKey
+		// A small cache makes dirty pages get sealed when they are evicted as well as when flushed
+		opts.CacheSize = MinCacheSize
+		pager, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		if pager.PageSize() != DefaultPageSize-EncryptionOverhead-PageReservedSize {
+			t.Errorf("%s: incorrect page size, got %d", pt.name, pager.PageSize())
+		}
+		fillPages(pager, 200, secretPage)
+		pager.Close()
+
+		contents, _ := os.ReadFile(filename)
+		if pageSize, encrypted := EncryptedPageSize(contents); !encrypted || pageSize != DefaultPageSize {
+			t.Errorf("%s: incorrect header, got %t and page size %d", pt.name, encrypted, pageSize)
+		}
+		if bytes.Contains(contents, secret) {
+			t.Errorf("%s: plaintext found in the db file", pt.name)
+		}
+
+		pager, err = Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error reopening, got %+v", pt.name, err)
+		}
+		checkSecretPages(t, pt.name, pager, 200)
+		pager.Close()
+	}
+}
+
+func TestEncryptedPagerWrongKey(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := Open(filename, Options{Key: testKey})
+	fillPages(pager, 3, secretPage)
+	pager.Close()
+
+	if _, err := Open(filename, Options{Key: testNewKey}); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected %v, got %+v", ErrWrongKey, err)
+	}
+	if _, err := Open(filename, Options{Key: []byte("short")}); err == nil {
+		t.Errorf("expected an error for an invalid key")
+	}
+
+	plain := filepath.Join(t.TempDir(), "plain.db")
+	pager, _ = Open(plain, Options{})
+	writePages(pager, 3, 0x1)
+	pager.Close()
+	if _, err := Open(plain, Options{Key: testKey}); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected %v, got %+v", ErrNotEncrypted, err)
+	}
+}
+
+func TestEncryptedPagesCannotBeMoved(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := Open(filename, Options{Key: testKey})
+	fillPages(pager, 3, secretPage)
+	pager.Close()
+
+	// Copy the file's page 1 over page 2, which holds page 1 of the database
+	contents, _ := os.ReadFile(filename)
+	copy(contents[2*DefaultPageSize:3*DefaultPageSize], contents[DefaultPageSize:2*DefaultPageSize])
+	os.WriteFile(filename, contents, 0644)
+
+	pager, err := Open(filename, Options{Key: testKey})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+	if _, err := pager.Page(1); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected %v, got %+v", ErrCorrupt, err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := Open(filename, Options{Key: testKey, CacheSize: MinCacheSize})
+	fillPages(pager, 100, secretPage)
+	if err := pager.(*EncryptedPager).Rekey(testNewKey); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	// The pager carries on under the new key
+	checkSecretPages(t, "rekeyed", pager, 100)
+	pager.Close()
+
+	if _, err := Open(filename, Options{Key: testKey}); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected %v opening with the old key, got %+v", ErrWrongKey, err)
+	}
+	pager, err := Open(filename, Options{Key: testNewKey})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	checkSecretPages(t, "reopened", pager, 100)
+	pager.Close()
+}
+
+func TestEncryptedDiscard(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := Open(filename, Options{Key: testKey, CacheSize: MinCacheSize})
+	defer pager.Close()
+	fillPages(pager, 10, secretPage)
+	pager.Flush()
+
+	// Some of the changes are sealed into the wrapped pager as they are evicted
+	writePages(pager, 100, 0x1)
+	if err := Discard(pager); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	checkSecretPages(t, "discarded", pager, 10)
+}
+
+func TestRekeyFailure(t *testing.T) {
+	disk := NewMemoryPager(DefaultPageSize)
+	pager, _ := NewEncryptedPager(NewFaultPager(disk, 1), Options{Key: testKey})
+	fillPages(pager, 100, secretPage)
+	pager.Flush()
+
+	// Reading a page fails half way through, once the pages before it are sealed under the new key
+	fault := NewFaultPager(disk, 1, Fault{Op: FaultRead, At: 50})
+	pager, err := NewEncryptedPager(fault, Options{Key: testKey})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if err := pager.Rekey(testNewKey); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected %v, got %+v", ErrInjected, err)
+	}
+
+	// The pager carries on under the old key, and flushes nothing sealed under the new one
+	checkSecretPages(t, "after failed rekey", pager, 100)
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	pager, err = NewEncryptedPager(NewFaultPager(disk, 1), Options{Key: testKey})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	checkSecretPages(t, "reopened", pager, 100)
+}
+
+func TestReadKeyFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(filename, []byte("000102030405060708090a0b0c0d0e0f\n"), 0600)
+	key, err := ReadKeyFile(filename)
+	if err != nil || len(key) != 16 || key[15] != 0xf {
+		t.Errorf("incorrect key, got %x, %+v", key, err)
+	}
+
+	os.WriteFile(filename, []byte("not hex"), 0600)
+	if _, err := ReadKeyFile(filename); err == nil {
+		t.Errorf("expected an error for a key that is not hex encoded")
+	}
+}
@@ -0,0 +1,24 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the tree and pagers, to be checked with errors.Is
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrCorrupt         = errors.New("database is corrupt")
+	ErrPageOutOfBounds = errors.New("page out of bounds")
+	ErrWrongKey        = errors.New("wrong encryption key")
+	ErrEncrypted       = errors.New("database is encrypted, a key is needed to open it")
+	ErrNotEncrypted    = errors.New("database is not encrypted")
+	ErrKeyTooLarge     = errors.New("key is too large")
+	ErrCannotDiscard   = errors.New("pager cannot discard its changes")
+	ErrNoFreeList      = errors.New("pager has no free list")
+)
+
+// corruptf returns an error wrapping ErrCorrupt
+func corruptf(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,321 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Errors returned by a FaultPager
+var (
+	ErrInjected = errors.New("injected fault")
+	ErrCrashed  = errors.New("pager has crashed")
+)
+
+// FaultOp is an operation of a FaultPager a fault can be injected into
+type FaultOp int
+
+const (
+	// FaultRead is reading a page that is not already held into the FaultPager
+	FaultRead FaultOp = iota
+	// FaultWrite is writing one dirty page to the wrapped pager during Flush
+	FaultWrite
+	// FaultSync is flushing the wrapped pager, once every dirty page has been written to it
+	FaultSync
+)
+
+// FaultKind is what happens when a fault fires
+type FaultKind int
+
+const (
+	// FaultError fails the operation with ErrInjected, leaving everything as it was
+	FaultError FaultKind = iota
+	// FaultShortWrite writes only part of the page before failing with ErrInjected. Only for FaultWrite.
+	FaultShortWrite
+	// FaultTornPage writes only some of the page's sectors, then crashes. Only for FaultWrite.
+	FaultTornPage
+	// FaultCrash crashes before the operation
+	FaultCrash
+)
+
+// tornSectorSize is the unit a torn page is written in, as a disk writes whole sectors
+const tornSectorSize = 512
+
+// Fault describes a fault to inject into a FaultPager
+type Fault struct {
+	Op   FaultOp
+	Kind FaultKind
+	// At is how many operations of type Op succeed before the fault fires, once. Ignored if Rate is set.
+	At int
+	// Rate is the chance of the fault firing on each operation of type Op
+	Rate float64
+}
+
+/*
+FaultPager wraps another pager, which stands in for the disk, and injects read and write errors,
+short writes, torn pages and crashes into it. Pages are held by the FaultPager until they are
+flushed, when they are written to the wrapped pager one by one and then it is flushed.
+
+A crash drops every page that has not been written to the wrapped pager and fails everything until
+Restart is called, as if the process had died and been started again. Pages a crash interrupts the
+flush of stay written, so wrapping a MemoryPager models a disk that writes pages in place with no
+journal.
+
+The faults are chosen from a random source with the given seed, so a run can be repeated exactly.
+*/
+type FaultPager struct {
+	inner       Pager
+	faults      []Fault
+	counts      map[FaultOp]int
+	rand        *rand.Rand
+	pages       map[uint32]Page
+	dirty       map[uint32]bool
+	truncated   bool
+	crashed     bool
+	changeCount uint64
+	NumPages    uint32
+}
+
+func NewFaultPager(inner Pager, seed int64, faults ...Fault) *FaultPager {
+	p := &FaultPager{inner: inner, faults: faults, counts: make(map[FaultOp]int), rand: rand.New(rand.NewSource(seed))}
+	p.reset()
+	return p
+}
+
+// reset drops every page held, leaving only what was written to the wrapped pager
+func (p *FaultPager) reset() {
+	p.pages = make(map[uint32]Page)
+	p.dirty = make(map[uint32]bool)
+	p.truncated = false
+	p.NumPages = p.inner.GetNextUnusedPageNum()
+}
+
+// Crash drops every page that has not been written to the wrapped pager and fails every operation
+// until Restart is called
+func (p *FaultPager) Crash() {
+	p.reset()
+	p.crashed = true
+}
+
+// Restart recovers from a crash, carrying on from what was written to the wrapped pager
+func (p *FaultPager) Restart() {
+	p.reset()
+	p.crashed = false
+}
+
+// Crashed returns whether the pager has crashed and not been restarted
+func (p *FaultPager) Crashed() bool {
+	return p.crashed
+}
+
+// Discard drops every page that has not been written to the wrapped pager
+func (p *FaultPager) Discard() error {
+	if p.crashed {
+		return ErrCrashed
+	}
+	p.reset()
+	p.changeCount++
+	return nil
+}
+
+// SetFaults replaces the faults to inject, counting operations afresh
+func (p *FaultPager) SetFaults(faults ...Fault) {
+	p.faults = faults
+	p.counts = make(map[FaultOp]int)
+}
+
+// inject returns the kind of fault to inject into the next operation of type op, if any
+func (p *FaultPager) inject(op FaultOp) (FaultKind, bool) {
+	count := p.counts[op]
+	p.counts[op]++
+	for _, f := range p.faults {
+		if f.Op != op {
+			continue
+		}
+		if f.Rate > 0 {
+			if p.rand.Float64() < f.Rate {
+				return f.Kind, true
+			}
+		} else if f.At == count {
+			return f.Kind, true
+		}
+	}
+	return FaultError, false
+}
+
+func (p *FaultPager) Page(pageNum uint32) (*Page, error) {
+	if p.crashed {
+		return nil, ErrCrashed
+	}
+	if err := checkPageNum(pageNum); err != nil {
+		return nil, err
+	}
+	if page, found := p.pages[pageNum]; found {
+		return &page, nil
+	}
+
+	if kind, found := p.inject(FaultRead); found {
+		if kind == FaultCrash {
+			p.Crash()
+			return nil, ErrCrashed
+		}
+		return nil, fmt.Errorf("%w: reading page %d", ErrInjected, pageNum)
+	}
+
+	page := Page(make([]byte, p.PageSize()))
+	if pageNum < p.NumPages {
+		innerPage, err := p.inner.Page(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		copy(page, *innerPage)
+	} else {
+		// Pages past the end only exist once they have been flushed
+		p.dirty[pageNum] = true
+		p.NumPages = pageNum + 1
+	}
+	p.pages[pageNum] = page
+	return &page, nil
+}
+
+func (p *FaultPager) MarkDirty(pageNum uint32) error {
+	if p.crashed {
+		return ErrCrashed
+	}
+	if _, found := p.pages[pageNum]; !found {
+		return fmt.Errorf("page %d has not been loaded", pageNum)
+	}
+	p.dirty[pageNum] = true
+	p.changeCount++
+	return nil
+}
+
+func (p *FaultPager) GetNextUnusedPageNum() uint32 {
+	return p.NumPages
+}
+
+func (p *FaultPager) AllocatePage() (uint32, error) {
+	return appendPage(p)
+}
+
+func (p *FaultPager) FreePage(pageNum uint32) error {
+	return ErrNoFreeList
+}
+
+func (p *FaultPager) PageSize() int {
+	return p.inner.PageSize()
+}
+
+// Stats returns the stats of the wrapped pager
+func (p *FaultPager) Stats() Stats {
+	return p.inner.Stats()
+}
+
+func (p *FaultPager) ChangeCount() uint64 {
+	return p.changeCount
+}
+
+func (p *FaultPager) Truncate(numPages uint32) error {
+	if p.crashed {
+		return ErrCrashed
+	}
+	for pageNum := range p.pages {
+		if pageNum >= numPages {
+			delete(p.pages, pageNum)
+			delete(p.dirty, pageNum)
+		}
+	}
+	if p.NumPages > numPages {
+		p.NumPages = numPages
+		p.truncated = true
+	}
+	p.changeCount++
+	return nil
+}
+
+// Flush writes the dirty pages to the wrapped pager in page order, then flushes it
+func (p *FaultPager) Flush() error {
+	if p.crashed {
+		return ErrCrashed
+	}
+
+	if p.truncated {
+		if err := p.inner.Truncate(p.NumPages); err != nil {
+			return err
+		}
+		p.truncated = false
+	}
+
+	dirty := make([]uint32, 0, len(p.dirty))
+	for pageNum := range p.dirty {
+		dirty = append(dirty, pageNum)
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i] < dirty[j] })
+
+	for _, pageNum := range dirty {
+		if err := p.writePage(pageNum); err != nil {
+			return err
+		}
+	}
+
+	if kind, found := p.inject(FaultSync); found {
+		if kind == FaultCrash {
+			p.Crash()
+			return ErrCrashed
+		}
+		return fmt.Errorf("%w: flushing", ErrInjected)
+	}
+	return p.inner.Flush()
+}
+
+func (p *FaultPager) writePage(pageNum uint32) error {
+	page := p.pages[pageNum]
+	length := len(page)
+
+	kind, found := p.inject(FaultWrite)
+	if found {
+		switch kind {
+		case FaultError:
+			return fmt.Errorf("%w: writing page %d", ErrInjected, pageNum)
+		case FaultCrash:
+			p.Crash()
+			return ErrCrashed
+		case FaultShortWrite:
+			length = 1 + p.rand.Intn(len(page)-1)
+		case FaultTornPage:
+			length = tornSectorSize * (1 + p.rand.Intn(len(page)/tornSectorSize-1))
+		}
+	}
+
+	innerPage, err := p.inner.Page(pageNum)
+	if err != nil {
+		return err
+	}
+	if err := p.inner.MarkDirty(pageNum); err != nil {
+		return err
+	}
+	copy(*innerPage, page[:length])
+
+	if found {
+		if kind == FaultTornPage {
+			p.Crash()
+			return ErrCrashed
+		}
+		return fmt.Errorf("%w: wrote %d of %d bytes of page %d", ErrInjected, length, len(page), pageNum)
+	}
+	delete(p.dirty, pageNum)
+	return nil
+}
+
+// Close flushes the pager unless it has crashed, then closes the wrapped pager
+func (p *FaultPager) Close() error {
+	var err error
+	if !p.crashed {
+		err = p.Flush()
+	}
+	if closeErr := p.inner.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+// wholePage fills every byte of a page with value, see fillPages
+func wholePage(value byte) func(uint32, Page) {
+	return func(_ uint32, page Page) {
+		for i := range page {
+			page[i] = value
+		}
+	}
+}
+
+func TestFaultPagerCrashDropsUnflushedPages(t *testing.T) {
+	inner := NewMemoryPager(MinPageSize)
+	pager := NewFaultPager(inner, 1)
+	fillPages(pager, 3, wholePage(0x1))
+	pager.Flush()
+	fillPages(pager, 5, wholePage(0x2))
+
+	pager.Crash()
+	if _, err := pager.Page(0); !errors.Is(err, ErrCrashed) {
+		t.Errorf("expected %v after crashing, got %+v", ErrCrashed, err)
+	}
+
+	pager.Restart()
+	if pager.GetNextUnusedPageNum() != 3 {
+		t.Errorf("incorrect number of pages, expected %d, got %d", 3, pager.GetNextUnusedPageNum())
+	}
+	for pageNum := uint32(0); pageNum < 3; pageNum++ {
+		page, _ := pager.Page(pageNum)
+		if (*page)[0] != 0x1 {
+			t.Errorf("incorrect contents for page %d, expected %d, got %d", pageNum, 0x1, (*page)[0])
+		}
+	}
+}
+
+func TestFaultPagerTornPage(t *testing.T) {
+	inner := NewMemoryPager(4096)
+	pager := NewFaultPager(inner, 1)
+	fillPages(pager, 3, wholePage(0x1))
+	pager.Flush()
+
+	pager.SetFaults(Fault{Op: FaultWrite, Kind: FaultTornPage, At: 1})
+	fillPages(pager, 3, wholePage(0x2))
+	if err := pager.Flush(); !errors.Is(err, ErrCrashed) {
+		t.Fatalf("expected %v, got %+v", ErrCrashed, err)
+	}
+	if !pager.Crashed() {
+		t.Errorf("expected the pager to have crashed")
+	}
+	pager.Restart()
+
+	// Pages are written in order, so the first made it, the second is torn and the third is untouched
+	first, _ := pager.Page(0)
+	if (*first)[len(*first)-1] != 0x2 {
+		t.Errorf("first page was not written")
+	}
+	torn, _ := pager.Page(1)
+	written := 0
+	for written < len(*torn) && (*torn)[written] == 0x2 {
+		written++
+	}
+	if written == 0 || written == len(*torn) || written%tornSectorSize != 0 {
+		t.Errorf("expected part of the second page to be written in whole sectors, got %d bytes", written)
+	}
+	for _, b := range (*torn)[written:] {
+		if b != 0x1 {
+			t.Errorf("torn page has unexpected contents after %d bytes", written)
+			break
+		}
+	}
+	last, _ := pager.Page(2)
+	if (*last)[0] != 0x1 {
+		t.Errorf("third page was written")
+	}
+}
+
+func TestFaultPagerShortWrite(t *testing.T) {
+	inner := NewMemoryPager(MinPageSize)
+	pager := NewFaultPager(inner, 1, Fault{Op: FaultWrite, Kind: FaultShortWrite})
+	fillPages(pager, 2, wholePage(0x1))
+
+	if err := pager.Flush(); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected %v, got %+v", ErrInjected, err)
+	}
+	innerPage, _ := inner.Page(0)
+	if (*innerPage)[0] != 0x1 || (*innerPage)[MinPageSize-1] != 0x0 {
+		t.Errorf("expected the start of the page to be written and not the end")
+	}
+
+	// The page is still dirty, so flushing again writes all of it
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	for pageNum := uint32(0); pageNum < 2; pageNum++ {
+		innerPage, _ := inner.Page(pageNum)
+		if (*innerPage)[MinPageSize-1] != 0x1 {
+			t.Errorf("page %d was not written", pageNum)
+		}
+	}
+}
+
+func TestFaultPagerReadError(t *testing.T) {
+	pager := NewFaultPager(NewMemoryPager(MinPageSize), 1, Fault{Op: FaultRead, Kind: FaultError, At: 2})
+	for pageNum := uint32(0); pageNum < 2; pageNum++ {
+		if _, err := pager.Page(pageNum); err != nil {
+			t.Fatalf("unexpected error for page %d, got %+v", pageNum, err)
+		}
+	}
+	if _, err := pager.Page(2); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected %v, got %+v", ErrInjected, err)
+	}
+	// A fault with At only fires once
+	if _, err := pager.Page(2); err != nil {
+		t.Errorf("unexpected error, got %+v", err)
+	}
+}
+
+// insertUnderFaults inserts keys into a tree while pages fail to be read, crashing and restarting
+// whenever an insert fails, and returns the errors seen in order
+func insertUnderFaults(t *testing.T, seed int64) []error {
+	t.Helper()
+	pager := NewFaultPager(NewMemoryPager(MinPageSize), seed)
+	tree := newTestTree(pager)
+	pager.Flush()
+	pager.SetFaults(Fault{Op: FaultRead, Kind: FaultError, Rate: 0.05}, Fault{Op: FaultRead, Kind: FaultCrash, Rate: 0.01})
+
+	errs := []error{}
+	flushed := uint64(0)
+	for key := uint64(0); key < 2000; key++ {
+		err := tree.Insert(key, IndexItem{uint32(key), 0, uint32(key) + 1})
+		if err == nil && key%10 == 9 {
+			if err = pager.Flush(); err == nil {
+				flushed = key + 1
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		// Whatever failed, the tree goes back to how it was when last flushed
+		errs = append(errs, err)
+		pager.Crash()
+		pager.Restart()
+		pager.SetFaults()
+		if problems := tree.Check(nil); len(problems) != 0 {
+			t.Fatalf("unexpected problems after crashing at key %d, got %+v", key, problems)
+		}
+		for k := uint64(0); k < flushed; k++ {
+			if item, err := tree.Get(k); err != nil || uint64(item.Length) != k+1 {
+				t.Fatalf("incorrect value for key %d after crashing, got %+v, %+v", k, item, err)
+			}
+		}
+		if _, err := tree.Get(flushed); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected key %d to be lost in the crash, got %+v", flushed, err)
+		}
+		pager.SetFaults(Fault{Op: FaultRead, Kind: FaultError, Rate: 0.05}, Fault{Op: FaultRead, Kind: FaultCrash, Rate: 0.01})
+		key = flushed - 1
+	}
+	return errs
+}
+
+func TestTreeSplitsUnderFaults(t *testing.T) {
+	errs := insertUnderFaults(t, 42)
+	if len(errs) == 0 {
+		t.Fatalf("expected some faults to be injected")
+	}
+
+	// The same seed injects the same faults
+	again := insertUnderFaults(t, 42)
+	if len(again) != len(errs) {
+		t.Fatalf("incorrect number of faults with the same seed, expected %d, got %d", len(errs), len(again))
+	}
+	for i := range errs {
+		if errs[i].Error() != again[i].Error() {
+			t.Errorf("fault %d differs with the same seed, expected %v, got %v", i, errs[i], again[i])
+		}
+	}
+}
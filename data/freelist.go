@@ -0,0 +1,237 @@
+package data
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+/*
+Free pages are tracked in a list of trunk pages, like SQLite's free-list. Each trunk page holds the
+page number of the next trunk page and the page numbers of up to FreeListTrunkMaxLeaves free (leaf)
+pages. When a trunk page has no leaves left it is handed out itself.
+
+The head of the list, and the number of free pages, are kept in the header of another page, usually
+the environment root page, at an offset given to NewFreeListPager.
+*/
+const (
+	FreeListHeadPageSize      = uint16(unsafe.Sizeof(uint32(0)))
+	FreeListCountOffset       = FreeListHeadPageSize
+	FreeListCountSize         = uint16(unsafe.Sizeof(uint32(0)))
+	FreeListHeaderSize        = FreeListCountOffset + FreeListCountSize
+	FreeListTrunkNextSize     = uint16(unsafe.Sizeof(uint32(0)))
+	FreeListTrunkNumSize      = uint16(unsafe.Sizeof(uint32(0)))
+	FreeListTrunkNumOffset    = FreeListTrunkNextSize
+	FreeListTrunkLeavesOffset = FreeListTrunkNumOffset + FreeListTrunkNumSize
+	FreeListTrunkLeafSize     = uint16(unsafe.Sizeof(uint32(0)))
+)
+
+// trunkMaxLeaves is the number of free pages a trunk page can hold
+func trunkMaxLeaves(trunk *Page) uint32 {
+	return uint32(trunk.UsableSize()-int(FreeListTrunkLeavesOffset)) / uint32(FreeListTrunkLeafSize)
+}
+
+// appendPage allocates a page at the end of the file, for pagers without a free-list
+func appendPage(p Pager) (uint32, error) {
+	pageNum := p.GetNextUnusedPageNum()
+	if _, err := p.Page(pageNum); err != nil {
+		return 0, err
+	}
+	if err := p.MarkDirty(pageNum); err != nil {
+		return 0, err
+	}
+	return pageNum, nil
+}
+
+// FreeListPager adds a persistent free-list to another pager, so freed pages are reused by
+// AllocatePage before the file is grown
+type FreeListPager struct {
+	Pager
+	headerPageNum uint32
+	headerOffset  uint16
+	reused        uint64
+}
+
+// hasFreeList reports whether p can free pages, rather than failing with ErrNoFreeList
+func hasFreeList(p Pager) bool {
+	_, ok := p.(*FreeListPager)
+	return ok
+}
+
+func NewFreeListPager(p Pager, headerPageNum uint32, headerOffset uint16) *FreeListPager {
+	return &FreeListPager{Pager: p, headerPageNum: headerPageNum, headerOffset: headerOffset}
+}
+
+func (fl *FreeListPager) header() (*Page, error) {
+	return fl.Pager.Page(fl.headerPageNum)
+}
+
+func (fl *FreeListPager) headPage(header *Page) uint32 {
+	return binary.LittleEndian.Uint32((*header)[fl.headerOffset : fl.headerOffset+FreeListHeadPageSize])
+}
+
+func (fl *FreeListPager) setHeadPage(header *Page, pageNum uint32) {
+	binary.LittleEndian.PutUint32((*header)[fl.headerOffset:fl.headerOffset+FreeListHeadPageSize], pageNum)
+}
+
+func (fl *FreeListPager) count(header *Page) uint32 {
+	offset := fl.headerOffset + FreeListCountOffset
+	return binary.LittleEndian.Uint32((*header)[offset : offset+FreeListCountSize])
+}
+
+func (fl *FreeListPager) setCount(header *Page, count uint32) {
+	offset := fl.headerOffset + FreeListCountOffset
+	binary.LittleEndian.PutUint32((*header)[offset:offset+FreeListCountSize], count)
+}
+
+// FreePageCount returns the number of pages waiting on the free-list to be reused
+func (fl *FreeListPager) FreePageCount() (uint32, error) {
+	header, err := fl.header()
+	if err != nil {
+		return 0, err
+	}
+	return fl.count(header), nil
+}
+
+func trunkNext(trunk *Page) uint32 {
+	return binary.LittleEndian.Uint32((*trunk)[0:FreeListTrunkNextSize])
+}
+
+func trunkNumLeaves(trunk *Page) uint32 {
+	return binary.LittleEndian.Uint32((*trunk)[FreeListTrunkNumOffset : FreeListTrunkNumOffset+FreeListTrunkNumSize])
+}
+
+func setTrunkNumLeaves(trunk *Page, numLeaves uint32) {
+	binary.LittleEndian.PutUint32((*trunk)[FreeListTrunkNumOffset:FreeListTrunkNumOffset+FreeListTrunkNumSize], numLeaves)
+}
+
+func trunkLeafOffset(index uint32) uint32 {
+	return uint32(FreeListTrunkLeavesOffset) + index*uint32(FreeListTrunkLeafSize)
+}
+
+func trunkLeaf(trunk *Page, index uint32) uint32 {
+	offset := trunkLeafOffset(index)
+	return binary.LittleEndian.Uint32((*trunk)[offset : offset+uint32(FreeListTrunkLeafSize)])
+}
+
+func setTrunkLeaf(trunk *Page, index uint32, pageNum uint32) {
+	offset := trunkLeafOffset(index)
+	binary.LittleEndian.PutUint32((*trunk)[offset:offset+uint32(FreeListTrunkLeafSize)], pageNum)
+}
+
+// AllocatePage returns a zeroed page, already marked dirty, taken from the free-list if it has any
+// pages or from the end of the file if it does not
+func (fl *FreeListPager) AllocatePage() (uint32, error) {
+	header, err := fl.header()
+	if err != nil {
+		return 0, err
+	}
+	trunkPageNum := fl.headPage(header)
+	if trunkPageNum == 0 {
+		return fl.Pager.AllocatePage()
+	}
+
+	if err := fl.Pager.MarkDirty(fl.headerPageNum); err != nil {
+		return 0, err
+	}
+	trunk, err := fl.Pager.Page(trunkPageNum)
+	if err != nil {
+		return 0, err
+	}
+	if err := fl.Pager.MarkDirty(trunkPageNum); err != nil {
+		return 0, err
+	}
+
+	pageNum := trunkPageNum
+	if numLeaves := trunkNumLeaves(trunk); numLeaves > 0 {
+		pageNum = trunkLeaf(trunk, numLeaves-1)
+		setTrunkNumLeaves(trunk, numLeaves-1)
+	} else {
+		// The trunk is empty, so the trunk page itself is reused
+		fl.setHeadPage(header, trunkNext(trunk))
+	}
+	fl.setCount(header, fl.count(header)-1)
+
+	page, err := fl.Pager.Page(pageNum)
+	if err != nil {
+		return 0, err
+	}
+	if err := fl.Pager.MarkDirty(pageNum); err != nil {
+		return 0, err
+	}
+	for i := range *page {
+		(*page)[i] = 0
+	}
+	fl.reused++
+	return pageNum, nil
+}
+
+// Stats adds the pages allocated from the free-list to the stats of the wrapped pager
+func (fl *FreeListPager) Stats() Stats {
+	stats := fl.Pager.Stats()
+	stats.PagesReused = fl.reused
+	return stats
+}
+
+// Discard drops every change made since the last flush, free-list included, see Discard
+func (fl *FreeListPager) Discard() error {
+	return Discard(fl.Pager)
+}
+
+func (fl *FreeListPager) beginRead() (bool, error) {
+	return beginRead(fl.Pager)
+}
+
+func (fl *FreeListPager) endRead() {
+	endRead(fl.Pager)
+}
+
+// FreePage puts a page on the free-list. The caller must not use the page again until it is handed
+// back out by AllocatePage.
+func (fl *FreeListPager) FreePage(pageNum uint32) error {
+	// Page 0 marks the end of the list, so it can never be on it
+	if pageNum == 0 || pageNum == fl.headerPageNum || pageNum >= fl.Pager.GetNextUnusedPageNum() {
+		return fmt.Errorf("page %d cannot be freed", pageNum)
+	}
+
+	header, err := fl.header()
+	if err != nil {
+		return err
+	}
+	if err := fl.Pager.MarkDirty(fl.headerPageNum); err != nil {
+		return err
+	}
+
+	trunkPageNum := fl.headPage(header)
+	if trunkPageNum != 0 {
+		trunk, err := fl.Pager.Page(trunkPageNum)
+		if err != nil {
+			return err
+		}
+		if numLeaves := trunkNumLeaves(trunk); numLeaves < trunkMaxLeaves(trunk) {
+			if err := fl.Pager.MarkDirty(trunkPageNum); err != nil {
+				return err
+			}
+			setTrunkLeaf(trunk, numLeaves, pageNum)
+			setTrunkNumLeaves(trunk, numLeaves+1)
+			fl.setCount(header, fl.count(header)+1)
+			return nil
+		}
+	}
+
+	// No room on the current trunk, so the freed page becomes the new head trunk
+	page, err := fl.Pager.Page(pageNum)
+	if err != nil {
+		return err
+	}
+	if err := fl.Pager.MarkDirty(pageNum); err != nil {
+		return err
+	}
+	for i := range *page {
+		(*page)[i] = 0
+	}
+	binary.LittleEndian.PutUint32((*page)[0:FreeListTrunkNextSize], trunkPageNum)
+	fl.setHeadPage(header, pageNum)
+	fl.setCount(header, fl.count(header)+1)
+	return nil
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestFreeList(pager Pager) *FreeListPager {
+	pager.Page(0)
+	return NewFreeListPager(pager, 0, 16)
+}
+
+func TestAllocateWithEmptyFreeListGrowsFile(t *testing.T) {
+	freeList := newTestFreeList(&MemoryPager{})
+
+	pageNum, err := freeList.AllocatePage()
+	if err != nil {
+		t.Errorf("unexpected error, got %+v", err)
+	}
+	if pageNum != 1 {
+		t.Errorf("incorrect page allocated, expected %d, got %d", 1, pageNum)
+	}
+	if freeList.GetNextUnusedPageNum() != 2 {
+		t.Errorf("incorrect next unused page, expected %d, got %d", 2, freeList.GetNextUnusedPageNum())
+	}
+}
+
+func TestFreedPagesAreReused(t *testing.T) {
+	freeList := newTestFreeList(&MemoryPager{})
+	for i := 0; i < 4; i++ {
+		freeList.AllocatePage()
+	}
+
+	page, _ := freeList.Page(2)
+	(*page)[0] = 0x1
+	for _, pageNum := range []uint32{2, 3, 4} {
+		if err := freeList.FreePage(pageNum); err != nil {
+			t.Errorf("unexpected error freeing page %d, got %+v", pageNum, err)
+		}
+	}
+	if count, _ := freeList.FreePageCount(); count != 3 {
+		t.Errorf("incorrect free page count, expected %d, got %d", 3, count)
+	}
+
+	// Page 2 became the trunk, pages 3 and 4 are its leaves
+	for _, expected := range []uint32{4, 3, 2} {
+		pageNum, _ := freeList.AllocatePage()
+		if pageNum != expected {
+			t.Errorf("incorrect page allocated, expected %d, got %d", expected, pageNum)
+		}
+	}
+	if page, _ := freeList.Page(2); (*page)[0] != 0x0 {
+		t.Errorf("reused page was not zeroed, expected %d, got %d", 0x0, (*page)[0])
+	}
+
+	if pageNum, _ := freeList.AllocatePage(); pageNum != 5 {
+		t.Errorf("incorrect page allocated once free list was empty, expected %d, got %d", 5, pageNum)
+	}
+	if count, _ := freeList.FreePageCount(); count != 0 {
+		t.Errorf("incorrect free page count, expected %d, got %d", 0, count)
+	}
+}
+
+func TestFreeListAddsTrunkWhenFull(t *testing.T) {
+	freeList := newTestFreeList(&MemoryPager{})
+	header, _ := freeList.Page(0)
+	maxLeaves := trunkMaxLeaves(header)
+	numPages := maxLeaves + 3
+	for i := uint32(0); i < numPages; i++ {
+		freeList.AllocatePage()
+	}
+	for pageNum := uint32(1); pageNum <= numPages; pageNum++ {
+		freeList.FreePage(pageNum)
+	}
+
+	head := freeList.headPage(header)
+	trunk, _ := freeList.Page(head)
+	if head != maxLeaves+2 || trunkNext(trunk) != 1 {
+		t.Errorf("incorrect trunks, expected %d -> %d, got %d -> %d", maxLeaves+2, 1, head, trunkNext(trunk))
+	}
+
+	allocated := map[uint32]bool{}
+	for i := uint32(0); i < numPages; i++ {
+		pageNum, _ := freeList.AllocatePage()
+		allocated[pageNum] = true
+	}
+	if uint32(len(allocated)) != numPages || freeList.GetNextUnusedPageNum() != numPages+1 {
+		t.Errorf("expected every freed page to be reused exactly once")
+	}
+}
+
+func TestFreePageRejectsInvalidPages(t *testing.T) {
+	freeList := newTestFreeList(&MemoryPager{})
+	freeList.AllocatePage()
+
+	for _, pageNum := range []uint32{0, 2} {
+		if err := freeList.FreePage(pageNum); err == nil {
+			t.Errorf("expected an error freeing page %d", pageNum)
+		}
+	}
+}
+
+func TestFreeListSurvivesReopen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := NewFilePager(filename)
+	freeList := newTestFreeList(pager)
+	freeList.AllocatePage()
+	freeList.AllocatePage()
+	freeList.FreePage(1)
+	pager.Close()
+
+	pager, _ = NewFilePager(filename)
+	defer pager.Close()
+	freeList = newTestFreeList(pager)
+	if pageNum, _ := freeList.AllocatePage(); pageNum != 1 {
+		t.Errorf("incorrect page allocated, expected %d, got %d", 1, pageNum)
+	}
+}
@@ -12,17 +12,35 @@ const (
 	RightChildOffset       = NumKeysOffset + NumKeysSize
 	InternalNodeHeaderSize = GenericHeaderSize + NumKeysSize + RightChildSize
 
-	InternalNodeKeySize         = uint16(unsafe.Sizeof(uint32(0)))
-	InternalNodeChildSize       = uint16(unsafe.Sizeof(uint32(0)))
-	InternalNodeCellSize        = InternalNodeKeySize + InternalNodeChildSize
-	InternalNodeSpaceForCells   = PageSize - InternalNodeHeaderSize
-	InternalNodeMaxCells        = InternalNodeSpaceForCells / InternalNodeCellSize
-	InternalNodeRightSplitCount = (InternalNodeMaxCells + 1) / 2
-	InternalNodeLeftSplitCount  = InternalNodeMaxCells + 1 - InternalNodeRightSplitCount
+	InternalNodeKeySize   = uint16(unsafe.Sizeof(uint64(0)))
+	InternalNodeChildSize = uint16(unsafe.Sizeof(uint32(0)))
+	InternalNodeCellSize  = InternalNodeKeySize + InternalNodeChildSize
 )
 
+// InternalNodeMaxCells is the number of keys that fit in an internal node on a page of pageSize bytes
+func InternalNodeMaxCells(pageSize int) uint16 {
+	return uint16(pageSize-PageReservedSize-int(InternalNodeHeaderSize)) / InternalNodeCellSize
+}
+
+// InternalNodeRightSplitCount is the number of keys that move to the new node when a full internal
+// node splits
+func InternalNodeRightSplitCount(pageSize int) uint16 {
+	return (InternalNodeMaxCells(pageSize) + 1) / 2
+}
+
+// InternalNodeLeftSplitCount is the number of keys that stay in a full internal node when it splits
+func InternalNodeLeftSplitCount(pageSize int) uint16 {
+	return InternalNodeMaxCells(pageSize) + 1 - InternalNodeRightSplitCount(pageSize)
+}
+
+// InternalNodeMinCells is the fewest keys an internal node other than the root can hold before it
+// borrows from, or is merged with, a sibling
+func InternalNodeMinCells(pageSize int) uint16 {
+	return InternalNodeMaxCells(pageSize) / 2
+}
+
 func NewInternal(data *Page) *Node {
-	n := Node{data}
+	n := Node{page: data}
 	n.SetType(InternalNode)
 	n.SetIsRoot(false)
 	n.SetNumKeys(0)
@@ -84,11 +102,30 @@ func (i *Node) SetChildPointer(childNum uint16, childPage uint32) {
 	}
 }
 
-func (n *Node) InternalKey(cell uint16) uint32 {
-	return binary.LittleEndian.Uint32(n.internalCell(cell)[InternalNodeChildSize : InternalNodeChildSize+InternalNodeKeySize])
+func (n *Node) InternalKey(cell uint16) uint64 {
+	return binary.LittleEndian.Uint64(n.internalCell(cell)[InternalNodeChildSize : InternalNodeChildSize+InternalNodeKeySize])
 }
 
-func (n *Node) SetInternalKey(cell uint16, key uint32) {
+func (n *Node) SetInternalKey(cell uint16, key uint64) {
 	c := n.internalCell(cell)
-	binary.LittleEndian.PutUint32(c[InternalNodeChildSize:InternalNodeChildSize+InternalNodeKeySize], key)
+	binary.LittleEndian.PutUint64(c[InternalNodeChildSize:InternalNodeChildSize+InternalNodeKeySize], key)
+}
+
+// removeInternalCell removes a key and the child to its left, moving the cells after it down
+func (n *Node) removeInternalCell(cellNum uint16) {
+	numKeys := n.NumKeys()
+	for i := cellNum; i+1 < numKeys; i++ {
+		n.moveInternalCell(i+1, i)
+	}
+	n.SetNumKeys(numKeys - 1)
+}
+
+// childIndex returns the index of the child pointer to pageNum, and whether there is one
+func (n *Node) childIndex(pageNum uint32) (uint16, bool) {
+	for i := uint16(0); i <= n.NumKeys(); i++ {
+		if n.ChildPointer(i) == pageNum {
+			return i, true
+		}
+	}
+	return 0, false
 }
@@ -17,7 +17,7 @@ func TestGetNumKeys(t *testing.T) {
 }
 
 func TestSetNumKeys(t *testing.T) {
-	p := Page(make([]byte, PageSize))
+	p := Page(make([]byte, DefaultPageSize))
 	node := NewInternal(&p)
 
 	node.SetNumKeys(6)
@@ -44,7 +44,7 @@ func TestRightChild(t *testing.T) {
 }
 
 func TestSetRightChild(t *testing.T) {
-	p := Page(make([]byte, PageSize))
+	p := Page(make([]byte, DefaultPageSize))
 	node := NewInternal(&p)
 
 	node.SetRightChild(264)
@@ -68,10 +68,10 @@ func TestChildPointer(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		p := Page(make([]byte, PageSize))
+		p := Page(make([]byte, DefaultPageSize))
 		node := NewInternal(&p)
 
-		offset := 12 + 8*int(test.cell)
+		offset := 12 + 12*int(test.cell)
 		copy(p[offset:offset+4], test.data)
 		// Set Num Keys to cell + 1, to avoid retrieving RightChild
 		copy(p[NumKeysOffset:NumKeysOffset+NumKeysSize], []byte{byte(test.cell + 1), 0x0})
@@ -95,14 +95,14 @@ func TestSetChildPointer(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		p := Page(make([]byte, PageSize))
+		p := Page(make([]byte, DefaultPageSize))
 		node := NewInternal(&p)
 
 		// Set Num Keys to cell + 1, to avoid using RightChild
 		copy(p[NumKeysOffset:NumKeysOffset+NumKeysSize], []byte{byte(test.cell + 1), 0x0})
 
 		node.SetChildPointer(test.cell, test.data)
-		offset := 12 + 8*int(test.cell)
+		offset := 12 + 12*int(test.cell)
 		actualResult := p[offset : offset+4]
 
 		if !bytesMatch(test.expectedResult, actualResult) {
@@ -116,17 +116,18 @@ func TestInternalKey(t *testing.T) {
 	tests := []struct {
 		cell           uint16
 		data           []byte
-		expectedResult uint32
+		expectedResult uint64
 	}{
-		{0, []byte{0x0, 0x1, 0x0, 0x0}, 256},
-		{1, []byte{0x1, 0x1, 0x1, 0x1}, 16843009},
+		{0, []byte{0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}, 256},
+		{1, []byte{0x1, 0x1, 0x1, 0x1, 0x0, 0x0, 0x0, 0x0}, 16843009},
+		{2, []byte{0x1, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0}, 1<<32 + 1},
 	}
 
 	for _, test := range tests {
-		p := Page(make([]byte, PageSize))
+		p := Page(make([]byte, DefaultPageSize))
 		node := NewInternal(&p)
-		offset := 16 + 8*int(test.cell)
-		copy(p[offset:offset+4], test.data)
+		offset := 16 + 12*int(test.cell)
+		copy(p[offset:offset+8], test.data)
 		actualResult := node.InternalKey(test.cell)
 
 		if test.expectedResult != actualResult {
@@ -139,19 +140,20 @@ func TestInternalKey(t *testing.T) {
 func TestSetTestInternalKey(t *testing.T) {
 	tests := []struct {
 		cell           uint16
-		data           uint32
+		data           uint64
 		expectedResult []byte
 	}{
-		{0, 257, []byte{0x1, 0x1, 0x0, 0x0}},
-		{1, 16843010, []byte{0x2, 0x1, 0x1, 0x1}},
+		{0, 257, []byte{0x1, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}},
+		{1, 16843010, []byte{0x2, 0x1, 0x1, 0x1, 0x0, 0x0, 0x0, 0x0}},
+		{2, 1<<32 + 2, []byte{0x2, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0}},
 	}
 
 	for _, test := range tests {
-		p := Page(make([]byte, PageSize))
+		p := Page(make([]byte, DefaultPageSize))
 		node := NewInternal(&p)
 		node.SetInternalKey(test.cell, test.data)
-		offset := 16 + 8*int(test.cell)
-		actualResult := p[offset : offset+4]
+		offset := 16 + 12*int(test.cell)
+		actualResult := p[offset : offset+8]
 
 		if !bytesMatch(test.expectedResult, actualResult) {
 			t.Errorf("Incorrect data set by node.SetChildPointer, exected %+v, got %+v", test.expectedResult, actualResult)
@@ -0,0 +1,328 @@
+package data
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+/*
+Journal
++++++++
+A journal sits beside the db file while a batch of page changes is being flushed. Every page in the
+batch is written to the journal first, followed by a commit record. Only once the commit record is
+on disk are the pages copied into the db file, after which the journal is removed.
+
+If the process dies part way through, the next NewFilePager finds the journal and either replays it
+(commit record present and checksum valid) or throws it away (the db file was never touched).
+
+Header: Magic, PageSize
+Frame: PageNum, Page
+Commit Record: CommitMarker, NumPages (of the db after the commit), NumFrames, Checksum
+*/
+const (
+	JournalMagic            = "klitejnl"
+	JournalMagicOffset      = 0
+	JournalMagicSize        = 8
+	JournalPageSizeOffset   = JournalMagicOffset + JournalMagicSize
+	JournalPageSizeSize     = 4
+	JournalHeaderSize       = JournalPageSizeOffset + JournalPageSizeSize
+	JournalFramePageNumSize = 4
+	JournalCommitMarker     = uint32(0xFFFFFFFF)
+	JournalCommitSize       = 16
+	JournalMaxPagesPerWrite = 256
+
+	journalSuffix = "-journal"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// writeFile writes to a journal or db file, every write the pagers make to either goes through it
+var writeFile = func(file *os.File, b []byte, offset int64) (int, error) {
+	return file.WriteAt(b, offset)
+}
+
+// truncateFile sets the length of a db file, every truncation the pagers make goes through it
+var truncateFile = func(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
+
+type journal struct {
+	file      *os.File
+	pageSize  int
+	offset    int64
+	numFrames uint32
+	checksum  uint32
+	// pages holds every page with a frame in the journal
+	pages map[uint32]bool
+}
+
+func journalPath(dbPath string) string {
+	return dbPath + journalSuffix
+}
+
+// RemoveDBFile removes a db file and any journal and lock file beside it. It must not be open.
+func RemoveDBFile(dbPath string) error {
+	for _, path := range []string{journalPath(dbPath), lockPath(dbPath)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Remove(dbPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func createJournal(path string, pageSize int) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{file: file, pageSize: pageSize, pages: make(map[uint32]bool)}
+	header := make([]byte, JournalHeaderSize)
+	copy(header[JournalMagicOffset:JournalMagicOffset+JournalMagicSize], JournalMagic)
+	binary.LittleEndian.PutUint32(header[JournalPageSizeOffset:JournalPageSizeOffset+JournalPageSizeSize], uint32(pageSize))
+	if err := j.write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *journal) write(b []byte) error {
+	bytesWritten, err := writeFile(j.file, b, j.offset)
+	if err != nil {
+		return err
+	}
+	if bytesWritten != len(b) {
+		return fmt.Errorf("incorrect number of bytes written to journal: %d", bytesWritten)
+	}
+	j.offset += int64(bytesWritten)
+	j.checksum = crc32.Update(j.checksum, crcTable, b)
+	return nil
+}
+
+// append adds a frame holding the new contents of a page, returning where the page was written
+func (j *journal) append(pageNum uint32, page Page) (int64, error) {
+	if len(page) != j.pageSize {
+		return 0, fmt.Errorf("page %d is %d bytes, journal expects %d", pageNum, len(page), j.pageSize)
+	}
+	frame := make([]byte, JournalFramePageNumSize+j.pageSize)
+	binary.LittleEndian.PutUint32(frame[0:JournalFramePageNumSize], pageNum)
+	copy(frame[JournalFramePageNumSize:], page)
+	// Pages only reach the db file through the journal, so this is where they get their checksum
+	setChecksum(frame[JournalFramePageNumSize:])
+	offset := j.offset + JournalFramePageNumSize
+	if err := j.write(frame); err != nil {
+		return 0, err
+	}
+	j.numFrames++
+	j.pages[pageNum] = true
+	return offset, nil
+}
+
+// readFrame reads back a page that was appended at offset
+func (j *journal) readFrame(offset int64, page Page) error {
+	bytesRead, err := j.file.ReadAt(page, offset)
+	if err != nil {
+		return err
+	}
+	if bytesRead != len(page) {
+		return fmt.Errorf("incorrect number of bytes read from journal: %d", bytesRead)
+	}
+	return nil
+}
+
+// commit writes the commit record and, unless mode is SyncOff, syncs the journal. Once commit
+// returns the batch will survive a crash.
+func (j *journal) commit(numPages uint32, mode Synchronous) error {
+	record := make([]byte, JournalCommitSize)
+	binary.LittleEndian.PutUint32(record[0:4], JournalCommitMarker)
+	binary.LittleEndian.PutUint32(record[4:8], numPages)
+	binary.LittleEndian.PutUint32(record[8:12], j.numFrames)
+	checksum := crc32.Update(j.checksum, crcTable, record[0:12])
+	binary.LittleEndian.PutUint32(record[12:16], checksum)
+	if err := j.write(record); err != nil {
+		return err
+	}
+	if mode == SyncOff {
+		return nil
+	}
+	return syncFile(j.file)
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// replayJournal copies the frames of a committed journal into the db file. It returns false if the
+// journal is incomplete or damaged, in which case the db file has not been touched. The db file is
+// only synced afterwards if syncDB is set.
+func replayJournal(path string, db *os.File, syncDB bool) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	header := make([]byte, JournalHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false, nil
+	}
+	if string(header[JournalMagicOffset:JournalMagicOffset+JournalMagicSize]) != JournalMagic {
+		return false, nil
+	}
+	pageSize := int(binary.LittleEndian.Uint32(header[JournalPageSizeOffset : JournalPageSizeOffset+JournalPageSizeSize]))
+	if pageSize == 0 {
+		return false, nil
+	}
+	checksum := crc32.Update(0, crcTable, header)
+
+	// First pass, find the commit record and check the journal is intact
+	type frame struct {
+		pageNum uint32
+		offset  int64
+	}
+	frames := []frame{}
+	offset := int64(JournalHeaderSize)
+	pageNumBuf := make([]byte, JournalFramePageNumSize)
+	page := make([]byte, pageSize)
+	var numPages uint32
+	for {
+		if _, err := file.ReadAt(pageNumBuf, offset); err != nil {
+			return false, nil
+		}
+		pageNum := binary.LittleEndian.Uint32(pageNumBuf)
+		if pageNum == JournalCommitMarker {
+			record := make([]byte, JournalCommitSize)
+			if _, err := file.ReadAt(record, offset); err != nil {
+				return false, nil
+			}
+			checksum = crc32.Update(checksum, crcTable, record[0:12])
+			if checksum != binary.LittleEndian.Uint32(record[12:16]) {
+				return false, nil
+			}
+			if binary.LittleEndian.Uint32(record[8:12]) != uint32(len(frames)) {
+				return false, nil
+			}
+			numPages = binary.LittleEndian.Uint32(record[4:8])
+			break
+		}
+
+		if _, err := file.ReadAt(page, offset+JournalFramePageNumSize); err != nil {
+			return false, nil
+		}
+		checksum = crc32.Update(checksum, crcTable, pageNumBuf)
+		checksum = crc32.Update(checksum, crcTable, page)
+		frames = append(frames, frame{pageNum, offset + JournalFramePageNumSize})
+		offset += int64(JournalFramePageNumSize + pageSize)
+	}
+
+	// Second pass, copy the pages into the db in page order. Later frames for a page replace earlier
+	// ones, and runs of consecutive pages are written with a single call.
+	latest := make(map[uint32]int64, len(frames))
+	for _, f := range frames {
+		latest[f.pageNum] = f.offset
+	}
+	pageNums := make([]uint32, 0, len(latest))
+	for pageNum := range latest {
+		pageNums = append(pageNums, pageNum)
+	}
+	sort.Slice(pageNums, func(i, j int) bool { return pageNums[i] < pageNums[j] })
+
+	for start := 0; start < len(pageNums); {
+		end := start + 1
+		for end < len(pageNums) && end-start < JournalMaxPagesPerWrite && pageNums[end] == pageNums[end-1]+1 {
+			end++
+		}
+
+		buffer := make([]byte, (end-start)*pageSize)
+		for i, pageNum := range pageNums[start:end] {
+			if _, err := file.ReadAt(buffer[i*pageSize:(i+1)*pageSize], latest[pageNum]); err != nil {
+				return false, err
+			}
+		}
+		bytesWritten, err := writeFile(db, buffer, int64(pageNums[start])*int64(pageSize))
+		if err != nil {
+			return false, err
+		}
+		if bytesWritten != len(buffer) {
+			return false, fmt.Errorf("incorrect number of bytes written: %d", bytesWritten)
+		}
+		start = end
+	}
+
+	if err := truncateFile(db, int64(numPages)*int64(pageSize)); err != nil {
+		return false, err
+	}
+	if !syncDB {
+		return true, nil
+	}
+	return true, syncFile(db)
+}
+
+// commitJournal commits j, copies its pages into the db file and removes it, syncing as much as mode
+// asks for along the way. If it fails j is left open, so the frames in it can still be read.
+func commitJournal(j *journal, dbPath string, db *os.File, numPages uint32, mode Synchronous) error {
+	if err := j.commit(numPages, mode); err != nil {
+		return err
+	}
+
+	path := journalPath(dbPath)
+	if mode == SyncFull {
+		// Make sure the journal can be found again after a power failure
+		if err := syncDir(path); err != nil {
+			return err
+		}
+	}
+	replayed, err := replayJournal(path, db, mode == SyncFull)
+	if err != nil {
+		return err
+	}
+	if !replayed {
+		return fmt.Errorf("journal could not be replayed")
+	}
+	if err := j.close(); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if mode == SyncFull {
+		return syncDir(path)
+	}
+	return nil
+}
+
+// resumeJournal replays or discards the journal left behind by a flush that failed, as recoverJournal
+// does on open, and returns the length of the db file afterwards. The journal may have been committed
+// and only partly copied into the db file, so it has to be dealt with before another is created over it.
+func resumeJournal(dbPath string, db *os.File) (int64, error) {
+	if err := recoverJournal(dbPath, db); err != nil {
+		return 0, err
+	}
+	info, err := db.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// recoverJournal replays or discards a hot journal left behind by a flush that did not complete
+func recoverJournal(dbPath string, db *os.File) error {
+	path := journalPath(dbPath)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Recovery is rare, so always make sure the replayed pages are on disk before the journal goes
+	if _, err := replayJournal(path, db, true); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
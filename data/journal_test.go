@@ -0,0 +1,361 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDBFile writes pages straight to a db file, checksummed as if they had been flushed
+func writeDBFile(filename string, pages ...Page) {
+	contents := []byte{}
+	for _, page := range pages {
+		setChecksum(page)
+		contents = append(contents, page...)
+	}
+	os.WriteFile(filename, contents, 0644)
+}
+
+func TestFlushRemovesJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, err := NewFilePager(filename)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	page, _ := pager.Page(1)
+	(*page)[0] = 0x7
+	if err := pager.Flush(); err != nil {
+		t.Errorf("unexpected error, got %+v", err)
+	}
+	pager.Close()
+
+	if _, err := os.Stat(journalPath(filename)); !os.IsNotExist(err) {
+		t.Errorf("expected journal to be removed after flush")
+	}
+
+	contents, _ := os.ReadFile(filename)
+	if len(contents) != 2*DefaultPageSize {
+		t.Errorf("incorrect file length, expected %d, got %d", 2*DefaultPageSize, len(contents))
+	}
+	if contents[DefaultPageSize] != 0x7 {
+		t.Errorf("incorrect byte at start of page 1, expected %d, got %d", 0x7, contents[DefaultPageSize])
+	}
+}
+
+func TestRecoverCommittedJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	os.WriteFile(filename, make([]byte, DefaultPageSize), 0644)
+
+	// Simulate a crash after the journal was committed but before the db was written
+	j, _ := createJournal(journalPath(filename), DefaultPageSize)
+	page := Page(make([]byte, DefaultPageSize))
+	page[0] = 0x1
+	j.append(0, page)
+	page[0] = 0x2
+	j.append(1, page)
+	j.commit(2, SyncFull)
+	j.close()
+
+	pager, err := NewFilePager(filename)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+
+	if _, err := os.Stat(journalPath(filename)); !os.IsNotExist(err) {
+		t.Errorf("expected journal to be removed after recovery")
+	}
+
+	if pager.GetNextUnusedPageNum() != 2 {
+		t.Errorf("incorrect number of pages, expected %d, got %d", 2, pager.GetNextUnusedPageNum())
+	}
+
+	for i, expected := range []byte{0x1, 0x2} {
+		p, _ := pager.Page(uint32(i))
+		if (*p)[0] != expected {
+			t.Errorf("incorrect byte at start of page %d, expected %d, got %d", i, expected, (*p)[0])
+		}
+	}
+}
+
+func TestDiscardIncompleteJournal(t *testing.T) {
+	tests := []struct {
+		name     string
+		truncate int64
+	}{
+		{"missing commit record", JournalCommitSize},
+		{"torn commit record", JournalCommitSize / 2},
+		{"torn frame", JournalCommitSize + DefaultPageSize/2},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		original := Page(make([]byte, DefaultPageSize))
+		original[0] = 0x9
+		writeDBFile(filename, original)
+
+		j, _ := createJournal(journalPath(filename), DefaultPageSize)
+		page := Page(make([]byte, DefaultPageSize))
+		page[0] = 0x1
+		j.append(0, page)
+		j.append(1, page)
+		j.commit(2, SyncFull)
+		j.file.Truncate(j.offset - test.truncate)
+		j.close()
+
+		pager, err := NewFilePager(filename)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", test.name, err)
+		}
+
+		if _, err := os.Stat(journalPath(filename)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected journal to be removed", test.name)
+		}
+
+		if pager.GetNextUnusedPageNum() != 1 {
+			t.Errorf("%s: incorrect number of pages, expected %d, got %d", test.name, 1, pager.GetNextUnusedPageNum())
+		}
+
+		p, _ := pager.Page(0)
+		if (*p)[0] != 0x9 {
+			t.Errorf("%s: incorrect byte at start of page 0, expected %d, got %d", test.name, 0x9, (*p)[0])
+		}
+		pager.Close()
+	}
+}
+
+func TestDiscardCorruptJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	writeDBFile(filename, make([]byte, DefaultPageSize))
+
+	j, _ := createJournal(journalPath(filename), DefaultPageSize)
+	page := Page(make([]byte, DefaultPageSize))
+	j.append(0, page)
+	j.commit(1, SyncFull)
+	// Flip a byte in the frame so the checksum no longer matches
+	j.file.WriteAt([]byte{0xFF}, JournalHeaderSize+JournalFramePageNumSize+10)
+	j.close()
+
+	pager, err := NewFilePager(filename)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+
+	p, _ := pager.Page(0)
+	if (*p)[10] != 0x0 {
+		t.Errorf("corrupt journal was replayed, expected %d, got %d", 0x0, (*p)[10])
+	}
+}
+
+func TestReplayUsesLatestFrame(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	db, _ := os.Create(filename)
+	defer db.Close()
+
+	path := journalPath(filename)
+	j, _ := createJournal(path, DefaultPageSize)
+	page := Page(make([]byte, DefaultPageSize))
+	for _, frame := range []struct {
+		pageNum uint32
+		value   byte
+	}{{3, 0x3}, {1, 0x1}, {2, 0x2}, {1, 0x4}} {
+		page[0] = frame.value
+		j.append(frame.pageNum, page)
+	}
+	j.commit(4, SyncFull)
+	j.close()
+
+	replayed, err := replayJournal(path, db, true)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if !replayed {
+		t.Errorf("expected journal to be replayed")
+	}
+
+	contents, _ := os.ReadFile(filename)
+	if len(contents) != 4*DefaultPageSize {
+		t.Errorf("incorrect file length, expected %d, got %d", 4*DefaultPageSize, len(contents))
+	}
+	for pageNum, expected := range []byte{0x0, 0x4, 0x2, 0x3} {
+		if contents[pageNum*DefaultPageSize] != expected {
+			t.Errorf("incorrect byte at start of page %d, expected %d, got %d", pageNum, expected, contents[pageNum*DefaultPageSize])
+		}
+	}
+}
+
+// errCrash is returned by file writes once a simulated crash has happened
+var errCrash = errors.New("crashed")
+
+// crashAtWrite makes the write or truncation numbered at, counting from 0, the last to reach the file,
+// and only the first half of it, as if the process died part way through. Every write after it
+// fails. It returns whether the crash happened, and a function that puts the file writes back.
+func crashAtWrite(at int) (func() bool, func()) {
+	write, truncate := writeFile, truncateFile
+	count := 0
+	writeFile = func(file *os.File, b []byte, offset int64) (int, error) {
+		count++
+		switch {
+		case count-1 < at:
+			return write(file, b, offset)
+		case count-1 == at:
+			write(file, b[:len(b)/2], offset)
+		}
+		return 0, errCrash
+	}
+	truncateFile = func(file *os.File, size int64) error {
+		count++
+		if count-1 < at {
+			return truncate(file, size)
+		}
+		return errCrash
+	}
+	return func() bool { return count > at }, func() { writeFile, truncateFile = write, truncate }
+}
+
+// abandon closes a pager's files without flushing it, as if its process had died
+func abandon(pager Pager) {
+	switch p := pager.(type) {
+	case *FilePager:
+		if p.journal != nil {
+			p.journal.close()
+		}
+		p.fileDescriptor.Close()
+		p.writerLock.Close()
+	case *MmapPager:
+		// The mapping holds the file, and its lock, open until it is unmapped. Read-only pagers close
+		// without flushing.
+		p.readOnly = true
+		p.Close()
+	}
+}
+
+func TestCrashDuringFlush(t *testing.T) {
+	const oldNumPages = 8
+	for _, pt := range pagerTypes {
+		for _, newNumPages := range []uint32{oldNumPages + 4, oldNumPages - 4} {
+			crashes := 0
+			for at := 0; ; at++ {
+				filename := filepath.Join(t.TempDir(), "test.db")
+				pager, _ := Open(filename, pt.opts)
+				writePages(pager, oldNumPages, 0x1)
+				pager.Close()
+
+				pager, err := Open(filename, pt.opts)
+				if err != nil {
+					t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+				}
+				pager.Truncate(newNumPages)
+				writePages(pager, newNumPages, 0x2)
+
+				crashed, restore := crashAtWrite(at)
+				err = pager.Flush()
+				restore()
+				if !crashed() {
+					if err != nil {
+						t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+					}
+					pager.Close()
+				} else {
+					if !errors.Is(err, errCrash) {
+						t.Errorf("%s: expected %v, got %+v", pt.name, errCrash, err)
+					}
+					abandon(pager)
+					crashes++
+				}
+
+				// Opening the db again recovers it to one commit or the other, never a mix
+				pager, err = Open(filename, pt.opts)
+				if err != nil {
+					t.Fatalf("%s: crash at write %d: unexpected error reopening, got %+v", pt.name, at, err)
+				}
+				numPages, value := uint32(oldNumPages), byte(0x1)
+				if pager.GetNextUnusedPageNum() == newNumPages {
+					numPages, value = newNumPages, 0x2
+				}
+				if pager.GetNextUnusedPageNum() != numPages {
+					t.Errorf("%s: crash at write %d: incorrect number of pages, got %d", pt.name, at, pager.GetNextUnusedPageNum())
+				}
+				for i := uint32(0); i < numPages; i++ {
+					page, err := pager.Page(i)
+					if err != nil || (*page)[0] != value || (*page)[1] != byte(i) {
+						t.Errorf("%s: crash at write %d: incorrect page %d, expected %d, got %+v", pt.name, at, i, value, err)
+					}
+				}
+				pager.Close()
+
+				if !crashed() {
+					if value != 0x2 {
+						t.Errorf("%s: expected the new commit once nothing crashed", pt.name)
+					}
+					break
+				}
+			}
+			if crashes < 3 {
+				t.Errorf("%s: expected a crash at each write of the flush, got %d crashes", pt.name, crashes)
+			}
+		}
+	}
+}
+
+// failWrites makes every write to the file at path reach it only half written and fail, returning a
+// function that puts the file writes back
+func failWrites(path string) func() {
+	write := writeFile
+	writeFile = func(file *os.File, b []byte, offset int64) (int, error) {
+		if file.Name() != path {
+			return write(file, b, offset)
+		}
+		write(file, b[:len(b)/2], offset)
+		return 0, errCrash
+	}
+	return func() { writeFile = write }
+}
+
+func TestFlushAfterFailedReplay(t *testing.T) {
+	const numPages = 4
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, _ := Open(filename, pt.opts)
+		writePages(pager, numPages, 0x1)
+		pager.Close()
+
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(pager, numPages, 0x2)
+
+		// The journal is committed, but copying it into the db file fails part way
+		restore := failWrites(filename)
+		err = pager.Flush()
+		restore()
+		if !errors.Is(err, errCrash) {
+			t.Errorf("%s: expected %v, got %+v", pt.name, errCrash, err)
+		}
+
+		// The next flush fails before its own journal is committed, then the process dies
+		restore = failWrites(journalPath(filename))
+		err = pager.Flush()
+		restore()
+		if !errors.Is(err, errCrash) {
+			t.Errorf("%s: expected %v, got %+v", pt.name, errCrash, err)
+		}
+		abandon(pager)
+
+		pager, err = Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error reopening, got %+v", pt.name, err)
+		}
+		for i := uint32(0); i < numPages; i++ {
+			page, err := pager.Page(i)
+			if err != nil || (*page)[0] != 0x2 || (*page)[1] != byte(i) {
+				t.Errorf("%s: incorrect page %d, expected %d, got %+v", pt.name, i, 0x2, err)
+			}
+		}
+		pager.Close()
+	}
+}
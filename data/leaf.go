@@ -16,26 +16,43 @@ const (
 
 	LeafNodeHeaderSize = GenericHeaderSize + NumCellsSize + NextLeafPointerSize
 
-	LeafNodeKeySize   = uint16(unsafe.Sizeof(uint32(0)))
+	LeafNodeKeySize   = uint16(unsafe.Sizeof(uint64(0)))
 	LeafNodeKeyOffset = 0
 
 	LeafNodeValueSize   = uint16(unsafe.Sizeof(IndexItem{}))
 	LeafNodeValueOffset = LeafNodeKeySize + LeafNodeKeyOffset
 
-	LeafNodeCellSize        = LeafNodeKeySize + LeafNodeValueSize
-	LeafNodeSpaceForCells   = PageSize - LeafNodeHeaderSize
-	LeafNodeMaxCells        = LeafNodeSpaceForCells / LeafNodeCellSize
-	LeafNodeRightSplitCount = (LeafNodeMaxCells + 1) / 2
-	LeafNodeLeftSplitCount  = LeafNodeMaxCells + 1 - LeafNodeRightSplitCount
+	LeafNodeCellSize = LeafNodeKeySize + LeafNodeValueSize
 )
 
+// LeafNodeMaxCells is the number of cells that fit in a leaf node on a page of pageSize bytes
+func LeafNodeMaxCells(pageSize int) uint16 {
+	return uint16(pageSize-PageReservedSize-int(LeafNodeHeaderSize)) / LeafNodeCellSize
+}
+
+// LeafNodeRightSplitCount is the number of cells that move to the new node when a full leaf splits
+func LeafNodeRightSplitCount(pageSize int) uint16 {
+	return (LeafNodeMaxCells(pageSize) + 1) / 2
+}
+
+// LeafNodeLeftSplitCount is the number of cells that stay in a full leaf when it splits
+func LeafNodeLeftSplitCount(pageSize int) uint16 {
+	return LeafNodeMaxCells(pageSize) + 1 - LeafNodeRightSplitCount(pageSize)
+}
+
+// LeafNodeMinCells is the fewest cells a leaf other than the root can hold before it borrows from,
+// or is merged with, a sibling
+func LeafNodeMinCells(pageSize int) uint16 {
+	return LeafNodeMaxCells(pageSize) / 2
+}
+
 func NewLeaf(p *Page) *Node {
-	n := Node{p}
+	n := Node{page: p}
 	n.SetType(LeafNode)
 	n.SetIsRoot(false)
 
 	n.SetNumCells(0)
-	//n.SetNextLeaf(0)
+	n.SetNextLeaf(0)
 	return &n
 }
 
@@ -65,13 +82,13 @@ func (n *Node) setNodeCell(cellNum uint16, cell []byte) {
 	copy((*n.page)[cellOffset:cellOffset+LeafNodeCellSize], cell)
 }
 
-func (n *Node) GetNodeKey(cellNum uint16) uint32 {
-	return binary.LittleEndian.Uint32(n.getNodeCell(cellNum)[LeafNodeKeyOffset : LeafNodeKeyOffset+LeafNodeKeySize])
+func (n *Node) GetNodeKey(cellNum uint16) uint64 {
+	return binary.LittleEndian.Uint64(n.getNodeCell(cellNum)[LeafNodeKeyOffset : LeafNodeKeyOffset+LeafNodeKeySize])
 }
 
-func (n *Node) SetNodeKey(cellNum uint16, key uint32) {
+func (n *Node) SetNodeKey(cellNum uint16, key uint64) {
 	cell := n.getNodeCell(cellNum)
-	binary.LittleEndian.PutUint32(cell[LeafNodeKeyOffset:LeafNodeKeyOffset+LeafNodeKeySize], key)
+	binary.LittleEndian.PutUint64(cell[LeafNodeKeyOffset:LeafNodeKeyOffset+LeafNodeKeySize], key)
 }
 
 func (n *Node) GetNodeValue(cellNum uint16) IndexItem {
@@ -83,3 +100,12 @@ func (n *Node) SetNodeValue(cellNum uint16, r IndexItem) {
 	cell := n.getNodeCell(cellNum)
 	copy(cell[LeafNodeKeySize:LeafNodeKeySize+LeafNodeValueSize], Serialise(r))
 }
+
+// removeNodeCell removes a cell, moving the cells after it down
+func (n *Node) removeNodeCell(cellNum uint16) {
+	numCells := n.NumCells()
+	for i := cellNum; i+1 < numCells; i++ {
+		n.setNodeCell(i, n.getNodeCell(i+1))
+	}
+	n.SetNumCells(numCells - 1)
+}
@@ -5,21 +5,22 @@ import "testing"
 func TestSetKey(t *testing.T) {
 	tests := []struct {
 		cell           uint16
-		key            uint32
+		key            uint64
 		expectedValues []byte
 	}{
-		{0, 7, []byte{7, 0, 0, 0}},
-		{1, 256, []byte{0, 1, 0, 0}},
+		{0, 7, []byte{7, 0, 0, 0, 0, 0, 0, 0}},
+		{1, 256, []byte{0, 1, 0, 0, 0, 0, 0, 0}},
+		{2, 1<<32 + 1, []byte{1, 0, 0, 0, 1, 0, 0, 0}},
 	}
 
 	for i, test := range tests {
-		p := Page(make([]byte, PageSize))
+		p := Page(make([]byte, DefaultPageSize))
 		leaf := NewNode(&p)
 		leaf.SetType(LeafNode)
 
 		leaf.SetNodeKey(test.cell, test.key)
-		offset := 12 + test.cell*16
-		bytes := (*leaf.page)[offset : offset+4]
+		offset := 12 + test.cell*20
+		bytes := (*leaf.page)[offset : offset+8]
 
 		if !bytesMatch(bytes, test.expectedValues) {
 			t.Errorf("unexpected key for test %d, expected %+v, got %+v", i, test.expectedValues, bytes)
@@ -29,18 +30,19 @@ func TestSetKey(t *testing.T) {
 
 func TestGetKey(t *testing.T) {
 	tests := []struct {
-		data          [4]byte
+		data          [8]byte
 		cell          uint16
-		expectedValue uint32
+		expectedValue uint64
 	}{
-		{[4]byte{7, 0, 0, 0}, 0, 7},
-		{[4]byte{0, 1, 0, 0}, 1, 256},
+		{[8]byte{7, 0, 0, 0, 0, 0, 0, 0}, 0, 7},
+		{[8]byte{0, 1, 0, 0, 0, 0, 0, 0}, 1, 256},
+		{[8]byte{1, 0, 0, 0, 1, 0, 0, 0}, 2, 1<<32 + 1},
 	}
 
 	for i, test := range tests {
-		p := Page(make([]byte, PageSize))
-		offset := 12 + test.cell*16
-		copy(p[offset:offset+4], test.data[:])
+		p := Page(make([]byte, DefaultPageSize))
+		offset := 12 + test.cell*20
+		copy(p[offset:offset+8], test.data[:])
 		leaf := NewNode(&p)
 		if leaf.GetNodeKey(test.cell) != test.expectedValue {
 			t.Errorf("unexpected value for test %d, expected %d, got %d", i, test.expectedValue, leaf.GetNodeKey(test.cell))
@@ -61,7 +63,7 @@ func TestGetLeafValue(t *testing.T) {
 		{3, 11, 12},
 	}
 
-	page := Page{0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 1, 0, 0, 0, 5, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 2, 0, 0, 0, 8, 0, 0, 0, 0, 0, 9, 0, 0, 0, 10, 0, 3, 0, 0, 0, 11, 0, 0, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	page := Page{0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0, 0, 9, 0, 0, 0, 10, 0, 3, 0, 0, 0, 0, 0, 0, 0, 11, 0, 0, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	leaf := NewNode(&page)
 	for _, test := range tests {
 		r := leaf.GetNodeValue(test.cell)
@@ -86,11 +88,11 @@ func TestSetLeafValue(t *testing.T) {
 		{1, 259, 6, []byte{3, 1, 0, 0, 0, 0, 6, 0, 0, 0}},
 	}
 
-	page := Page(make([]byte, PageSize))
+	page := Page(make([]byte, DefaultPageSize))
 	leaf := NewNode(&page)
 	for _, test := range tests {
 		leaf.SetNodeValue(test.cell, IndexItem{test.pageNum, 0, test.length})
-		bytes := (*leaf.page)[16+test.cell*16 : 26+test.cell*16]
+		bytes := (*leaf.page)[20+test.cell*20 : 30+test.cell*20]
 
 		if !bytesMatch(bytes, test.expectedData) {
 			t.Errorf("incorrect data set for cell %d, expected %+v, got %+v", test.cell, test.expectedData, bytes)
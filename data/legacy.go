@@ -0,0 +1,61 @@
+package data
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+/*
+Before version 0.15.0 keys were 32 bits, so the cells of both kinds of node were 4 bytes shorter.
+The node headers have not changed, and neither has the position of the child pointer in an internal
+cell, so the pages of a narrow tree can still be found. Narrow trees are only read to upgrade them.
+*/
+const (
+	narrowKeySize              = uint16(unsafe.Sizeof(uint32(0)))
+	narrowInternalNodeCellSize = InternalNodeChildSize + narrowKeySize
+)
+
+// NarrowTreePages returns the page numbers of every node in a tree written before keys were 64 bits,
+// and the page numbers of its leaves in key order
+func NarrowTreePages(p Pager, rootPageNum uint32) ([]uint32, []uint32, error) {
+	pages, leaves := []uint32{}, []uint32{}
+	seen := make(map[uint32]bool)
+	numPages := p.GetNextUnusedPageNum()
+	toVisit := []uint32{rootPageNum}
+	for len(toVisit) > 0 {
+		pageNum := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if pageNum == 0 || pageNum >= numPages {
+			return nil, nil, corruptf("tree has child %d which is not a page in the file", pageNum)
+		}
+		if seen[pageNum] {
+			return nil, nil, corruptf("page %d is in the tree more than once", pageNum)
+		}
+		seen[pageNum] = true
+		pages = append(pages, pageNum)
+
+		page, err := p.Page(pageNum)
+		if err != nil {
+			return nil, nil, err
+		}
+		n := NewNode(page)
+		switch n.Type() {
+		case LeafNode:
+			leaves = append(leaves, pageNum)
+		case InternalNode:
+			numKeys := n.NumKeys()
+			if int(InternalNodeHeaderSize)+int(numKeys)*int(narrowInternalNodeCellSize) > page.UsableSize() {
+				return nil, nil, corruptf("internal node %d has %d keys, more than fit", pageNum, numKeys)
+			}
+			toVisit = append(toVisit, n.RightChild())
+			for i := int(numKeys) - 1; i >= 0; i-- {
+				cellOffset := InternalNodeHeaderSize + uint16(i)*narrowInternalNodeCellSize
+				cell := (*page)[cellOffset : cellOffset+narrowInternalNodeCellSize]
+				toVisit = append(toVisit, binary.LittleEndian.Uint32(cell[0:InternalNodeChildSize]))
+			}
+		default:
+			return nil, nil, corruptf("page %d has unknown node type %d", pageNum, n.Type())
+		}
+	}
+	return pages, leaves, nil
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeNarrowRoot writes an internal node with 32 bit keys to pageNum, with a cell for each of
+// children and rightChild to the right of them
+func writeNarrowRoot(t *testing.T, pager Pager, pageNum uint32, children []uint32, rightChild uint32) {
+	t.Helper()
+	page, err := pager.Page(pageNum)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	n := NewInternal(page)
+	n.SetIsRoot(true)
+	n.SetNumKeys(uint16(len(children)))
+	n.SetRightChild(rightChild)
+	for i, child := range children {
+		cellOffset := InternalNodeHeaderSize + uint16(i)*narrowInternalNodeCellSize
+		binary.LittleEndian.PutUint32((*page)[cellOffset:], child)
+		binary.LittleEndian.PutUint32((*page)[cellOffset+InternalNodeChildSize:], uint32(i+1)*10)
+	}
+}
+
+func TestNarrowTreePages(t *testing.T) {
+	pager := NewMemoryPager(MinPageSize)
+	pager.Page(0)
+	pageNums := []uint32{}
+	for i := 0; i < 4; i++ {
+		pageNum, _ := pager.AllocatePage()
+		pageNums = append(pageNums, pageNum)
+	}
+	root := pageNums[0]
+	for _, pageNum := range pageNums[1:] {
+		page, _ := pager.Page(pageNum)
+		NewLeaf(page)
+	}
+	// The leaves are not in page order, so their order can only come from the root
+	writeNarrowRoot(t, pager, root, []uint32{pageNums[3], pageNums[1]}, pageNums[2])
+
+	pages, leaves, err := NarrowTreePages(pager, root)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if expected := []uint32{root, pageNums[3], pageNums[1], pageNums[2]}; !cmp.Equal(pages, expected) {
+		t.Errorf("incorrect pages, expected %+v, got %+v", expected, pages)
+	}
+	if expected := []uint32{pageNums[3], pageNums[1], pageNums[2]}; !cmp.Equal(leaves, expected) {
+		t.Errorf("incorrect leaves, expected %+v, got %+v", expected, leaves)
+	}
+}
+
+func TestNarrowTreePagesWithLoop(t *testing.T) {
+	pager := NewMemoryPager(MinPageSize)
+	pager.Page(0)
+	root, _ := pager.AllocatePage()
+	leaf, _ := pager.AllocatePage()
+	page, _ := pager.Page(leaf)
+	NewLeaf(page)
+	writeNarrowRoot(t, pager, root, []uint32{leaf}, root)
+
+	if _, _, err := NarrowTreePages(pager, root); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected %v, got %+v", ErrCorrupt, err)
+	}
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned when another process holds a lock on the database that conflicts with ours
+var ErrLocked = errors.New("database is locked")
+
+// lockRetryInterval is how long to wait between attempts to take a lock while the busy timeout runs
+const lockRetryInterval = 10 * time.Millisecond
+
+// lockSuffix names the file beside the db file that a writer locks for as long as it is open
+const lockSuffix = "-lock"
+
+/*
+Locking
++++++++
+A writer holds a shared lock on the db file for as long as it has it open, and only an exclusive lock
+while it is being opened, when any journal is recovered, and while Flush copies a committed journal
+into it. Readers only hold a shared lock during a read transaction (see BeginRead), so a reader never
+sees a db file part way through changing, and every page it reads in a transaction comes from the
+same commit. A read transaction holds up the writer's flushes until it ends, they fail with ErrLocked
+once the busy timeout runs out and can be tried again. Readers that are open between transactions do
+not hold anything up.
+
+Writers also hold an exclusive lock on the lock file beside the db file for as long as they are open,
+so there is only ever one writer. A reader that finds a journal and no writer holding the lock file
+knows the journal was left behind by a flush that did not complete.
+*/
+
+func lockPath(dbPath string) string {
+	return dbPath + lockSuffix
+}
+
+// lockFile takes an advisory lock on file, shared or exclusive. If the lock is held by another
+// process lockFile keeps trying until timeout has passed, then returns ErrLocked.
+func lockFile(file *os.File, exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryLockFile(file, exclusive)
+		if err != ErrLocked || !time.Now().Before(deadline) {
+			return err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// lockForCommit upgrades a writer's shared lock on the db file to an exclusive one, so a journal can
+// be copied into it, and returns a function that downgrades it again
+func lockForCommit(file *os.File, timeout time.Duration) (func(), error) {
+	if err := lockFile(file, true, timeout); err != nil {
+		// Changing a lock drops the one that was held, so it has to be taken again
+		lockFile(file, false, 0)
+		return nil, err
+	}
+	return func() { lockFile(file, false, 0) }, nil
+}
+
+// checkHotJournal returns an error if there is a journal beside the db file at dbPath that was left
+// behind by a flush that did not complete. An open writer's journal has not been committed yet, so
+// the db file is as it was.
+func checkHotJournal(dbPath string) error {
+	if _, err := os.Stat(journalPath(dbPath)); err == nil && !writerIsOpen(dbPath) {
+		return fmt.Errorf("database has a journal to recover, open it read-write first")
+	}
+	return nil
+}
+
+// lockForRead takes a reader's shared lock on the db file at dbPath, and returns the file's length
+func lockForRead(dbPath string, file *os.File, pageSize int, timeout time.Duration) (int64, error) {
+	if err := lockFile(file, false, timeout); err != nil {
+		return 0, err
+	}
+	if err := checkHotJournal(dbPath); err != nil {
+		unlockFile(file)
+		return 0, err
+	}
+	fileLength, err := file.Seek(0, 2)
+	if err != nil {
+		unlockFile(file)
+		return 0, err
+	}
+	if fileLength%int64(pageSize) != 0 {
+		unlockFile(file)
+		return 0, corruptf("db file is not a whole number of pages")
+	}
+	return fileLength, nil
+}
+
+// writerIsOpen returns whether a writer has the database at dbPath open
+func writerIsOpen(dbPath string) bool {
+	file, err := os.Open(lockPath(dbPath))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return tryLockFile(file, false) == ErrLocked
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package data
+
+import "os"
+
+// Advisory locking is only implemented for unix systems, elsewhere every open succeeds
+func tryLockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,244 @@
+//go:build unix
+
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecondWriterIsLockedOut(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		if _, err := Open(filename, pt.opts); err != ErrLocked {
+			t.Errorf("%s: expected %v, got %+v", pt.name, ErrLocked, err)
+		}
+
+		pager.Close()
+		second, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error once first pager was closed, got %+v", pt.name, err)
+		} else {
+			second.Close()
+		}
+	}
+}
+
+func TestSharedLocks(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	open := func() *os.File {
+		file, _ := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+		return file
+	}
+
+	reader1, reader2, writer := open(), open(), open()
+	defer reader1.Close()
+	defer reader2.Close()
+	defer writer.Close()
+
+	if err := lockFile(reader1, false, 0); err != nil {
+		t.Errorf("unexpected error, got %+v", err)
+	}
+	if err := lockFile(reader2, false, 0); err != nil {
+		t.Errorf("readers should share the database, got %+v", err)
+	}
+	if err := lockFile(writer, true, 0); err != ErrLocked {
+		t.Errorf("expected %v while readers hold the database, got %+v", ErrLocked, err)
+	}
+}
+
+func TestBusyTimeoutWaitsForLock(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := NewFilePager(filename)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		pager.Close()
+	}()
+
+	second, err := OpenFilePager(filename, Options{BusyTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	second.Close()
+
+	third, _ := NewFilePager(filename)
+	defer third.Close()
+	start := time.Now()
+	if _, err := OpenFilePager(filename, Options{BusyTimeout: 30 * time.Millisecond}); err != ErrLocked {
+		t.Errorf("expected %v, got %+v", ErrLocked, err)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Errorf("gave up before the busy timeout")
+	}
+}
+
+func TestReaderOpensWhileWriterIsOpen(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		writer, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		defer writer.Close()
+		writePages(writer, 1, 0x1)
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		opts := pt.opts
+		opts.ReadOnly = true
+		reader, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error opening a reader, got %+v", pt.name, err)
+		}
+		if page, err := reader.Page(0); err != nil || (*page)[0] != 0x1 {
+			t.Errorf("%s: could not read page 0, got %+v", pt.name, err)
+		}
+
+		// The writer cannot commit under an open reader
+		writePages(writer, 1, 0x2)
+		if err := writer.Flush(); err != ErrLocked {
+			t.Errorf("%s: expected %v, got %+v", pt.name, ErrLocked, err)
+		}
+		if page, err := reader.Page(0); err != nil || (*page)[0] != 0x1 {
+			t.Errorf("%s: reader saw an uncommitted change, got %+v", pt.name, err)
+		}
+		reader.Close()
+
+		// Once the reader has gone the flush can be tried again
+		if err := writer.Flush(); err != nil {
+			t.Errorf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		contents, _ := os.ReadFile(filename)
+		if contents[0] != 0x2 {
+			t.Errorf("%s: incorrect byte in page 0, expected %d, got %d", pt.name, 0x2, contents[0])
+		}
+	}
+}
+
+func TestReaderOpensWhileWriterHasSpilledPages(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	writer, _ := OpenFilePager(filename, Options{CacheSize: MinCacheSize})
+	numPages := uint32(2 * MinCacheSize)
+	writePages(writer, numPages, 0x1)
+	writer.Flush()
+	writePages(writer, numPages, 0x2)
+	if writer.journal == nil {
+		t.Fatalf("expected dirty pages to have been spilled to the journal")
+	}
+
+	// The journal belongs to the open writer, so it is not one left behind to recover
+	reader, err := OpenFilePager(filename, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	for i := uint32(0); i < numPages; i++ {
+		if page, err := reader.Page(i); err != nil || (*page)[0] != 0x1 {
+			t.Errorf("incorrect page %d, got %+v", i, err)
+		}
+	}
+	reader.Close()
+
+	// Once the writer has crashed it is
+	writer.journal.close()
+	writer.fileDescriptor.Close()
+	writer.writerLock.Close()
+	if _, err := OpenFilePager(filename, Options{ReadOnly: true}); err == nil {
+		t.Errorf("expected an error opening a reader with a journal to recover")
+	}
+}
+
+func TestCloseWhileReaderIsOpen(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		writer, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(writer, 1, 0x1)
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		opts := pt.opts
+		opts.ReadOnly = true
+		reader, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error opening a reader, got %+v", pt.name, err)
+		}
+		if _, err := reader.Page(0); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		// The changes cannot be flushed under the reader, which Close has to say
+		writePages(writer, 1, 0x2)
+		if err := writer.Close(); err != ErrLocked {
+			t.Errorf("%s: expected %v, got %+v", pt.name, ErrLocked, err)
+		}
+		reader.Close()
+	}
+}
+
+func TestReaderOnlyLocksInReadTransaction(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		writer, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		defer writer.Close()
+		writePages(writer, 1, 0x1)
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		opts := pt.opts
+		opts.ReadOnly = true
+		reader, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error opening a reader, got %+v", pt.name, err)
+		}
+		defer reader.Close()
+
+		endRead, err := BeginRead(reader)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		if page, err := reader.Page(0); err != nil || (*page)[0] != 0x1 {
+			t.Errorf("%s: could not read page 0, got %+v", pt.name, err)
+		}
+		writePages(writer, 1, 0x2)
+		if err := writer.Flush(); err != ErrLocked {
+			t.Errorf("%s: expected %v during a read transaction, got %+v", pt.name, ErrLocked, err)
+		}
+		endRead()
+
+		// An open reader outside a read transaction does not hold the writer up
+		writePages(writer, 2, 0x3)
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		// The next read transaction sees the commit
+		endRead, err = BeginRead(reader)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		if reader.GetNextUnusedPageNum() != 2 {
+			t.Errorf("%s: incorrect number of pages, expected %d, got %d", pt.name, 2, reader.GetNextUnusedPageNum())
+		}
+		for i := uint32(0); i < 2; i++ {
+			if page, err := reader.Page(i); err != nil || (*page)[0] != 0x3 {
+				t.Errorf("%s: incorrect page %d, got %+v", pt.name, i, err)
+			}
+		}
+		endRead()
+	}
+}
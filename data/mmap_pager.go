@@ -0,0 +1,379 @@
+//go:build unix
+
+package data
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// MmapGrowth is the smallest step the mapping grows by, so appending to the file does not remap it
+// on every flush
+const MmapGrowth = 64 * 1024 * 1024
+
+// MmapPager serves pages straight out of a private memory mapping of the db file, so reading a page
+// does not need a syscall. Changing a page copies it (copy on write) rather than touching the file;
+// Flush writes dirty pages through the journal like the FilePager does. The OS copies a whole OS page
+// at a time, and a copied OS page no longer sees writes to the file, so db pages can be no smaller
+// than OS pages or a page would miss writes made to its neighbours.
+type MmapPager struct {
+	filename       string
+	fileDescriptor *os.File
+	writerLock     *os.File
+	busyTimeout    time.Duration
+	fileLength     int64
+	mapping        []byte
+	// Mappings that have been replaced are kept until Close, as callers may still hold pages in them
+	oldMappings [][]byte
+	// Pages past the end of the file live on the heap until they are flushed
+	newPages map[uint32]Page
+	dirty    map[uint32]bool
+	// Pages whose checksum has been checked since they were mapped
+	verified      map[uint32]bool
+	readOnly      bool
+	skipChecksums bool
+	pageSize      int
+	sync          Synchronous
+	changeCount   uint64
+	stats         Stats
+	// Set when pages have been truncated, as changed pages past the new end of the file would still
+	// be in the mapping if the file grew again
+	truncated bool
+	// Set when a flush failed, leaving its journal behind to be replayed or discarded
+	failedFlush bool
+	// Set while a reader is in a read transaction
+	reading  bool
+	NumPages uint32
+}
+
+func OpenMmapPager(filename string, opts Options) (*MmapPager, error) {
+	pageSize, err := opts.pageSize()
+	if err != nil {
+		return nil, err
+	}
+	if pageSize < os.Getpagesize() {
+		return nil, fmt.Errorf("invalid page size %d, must be at least the OS page size of %d to use mmap", pageSize, os.Getpagesize())
+	}
+	file, writerLock, fileLength, err := openDBFile(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MmapPager{
+		filename:       filename,
+		fileDescriptor: file,
+		writerLock:     writerLock,
+		busyTimeout:    opts.BusyTimeout,
+		fileLength:     fileLength,
+		newPages:       make(map[uint32]Page),
+		dirty:          make(map[uint32]bool),
+		verified:       make(map[uint32]bool),
+		readOnly:       opts.ReadOnly,
+		skipChecksums:  opts.SkipChecksums,
+		pageSize:       pageSize,
+		sync:           opts.Synchronous,
+		NumPages:       uint32(fileLength / int64(pageSize)),
+	}
+	if err := p.remap(); err != nil {
+		file.Close()
+		if writerLock != nil {
+			writerLock.Close()
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// remap grows the mapping to cover the whole file
+func (p *MmapPager) remap() error {
+	if p.mapping != nil && int64(len(p.mapping)) >= p.fileLength {
+		return nil
+	}
+
+	size := int64(2 * len(p.mapping))
+	if size < p.fileLength {
+		size = p.fileLength
+	}
+	if size == 0 || size%MmapGrowth != 0 {
+		size += MmapGrowth - size%MmapGrowth
+	}
+
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	if p.readOnly {
+		// Any write to a read-only database faults rather than going unnoticed
+		prot = syscall.PROT_READ
+	}
+	mapping, err := syscall.Mmap(int(p.fileDescriptor.Fd()), 0, int(size), prot, syscall.MAP_PRIVATE)
+	if err != nil {
+		return err
+	}
+	if p.mapping != nil {
+		p.oldMappings = append(p.oldMappings, p.mapping)
+	}
+	p.mapping = mapping
+	return nil
+}
+
+// beginRead starts a read transaction if the pager is a reader and is not in one. The mapping sees
+// what has been written to the file since the last one, but the pages in it have to be checked again.
+func (p *MmapPager) beginRead() (bool, error) {
+	if !p.readOnly || p.reading {
+		return false, nil
+	}
+	fileLength, err := lockForRead(p.filename, p.fileDescriptor, p.pageSize, p.busyTimeout)
+	if err != nil {
+		return false, err
+	}
+	p.fileLength = fileLength
+	p.verified = make(map[uint32]bool)
+	p.NumPages = p.mappedPages()
+	if err := p.remap(); err != nil {
+		unlockFile(p.fileDescriptor)
+		return false, err
+	}
+	p.reading = true
+	return true, nil
+}
+
+func (p *MmapPager) endRead() {
+	if p.reading {
+		unlockFile(p.fileDescriptor)
+		p.reading = false
+	}
+}
+
+func (p *MmapPager) mappedPages() uint32 {
+	return uint32(p.fileLength / int64(p.pageSize))
+}
+
+func (p *MmapPager) Page(pageNum uint32) (*Page, error) {
+	if err := checkPageNum(pageNum); err != nil {
+		return nil, err
+	}
+	if _, err := p.beginRead(); err != nil {
+		return nil, err
+	}
+
+	var page Page
+	if newPage, found := p.newPages[pageNum]; found {
+		page = newPage
+		p.stats.CacheHits++
+	} else if pageNum < p.mappedPages() && pageNum < p.NumPages {
+		offset := int64(pageNum) * int64(p.pageSize)
+		page = Page(p.mapping[offset : offset+int64(p.pageSize) : offset+int64(p.pageSize)])
+		if !p.verified[pageNum] && !p.dirty[pageNum] {
+			// The first time a page is used is when it is read in from the file
+			if !p.skipChecksums {
+				if err := verifyChecksum(pageNum, page); err != nil {
+					return nil, err
+				}
+			}
+			p.verified[pageNum] = true
+			p.stats.CacheMisses++
+			p.stats.PagesRead++
+		} else {
+			p.stats.CacheHits++
+		}
+	} else {
+		if p.readOnly {
+			return nil, fmt.Errorf("%w: page %d does not exist", ErrPageOutOfBounds, pageNum)
+		}
+		page = make([]byte, p.pageSize)
+		p.newPages[pageNum] = page
+		// Pages past the end of the file only exist once they have been flushed
+		p.dirty[pageNum] = true
+		p.stats.CacheMisses++
+		p.stats.PagesAllocated++
+	}
+
+	if pageNum >= p.NumPages {
+		p.NumPages = pageNum + 1
+	}
+	return &page, nil
+}
+
+func (p *MmapPager) MarkDirty(pageNum uint32) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if pageNum >= p.NumPages {
+		return fmt.Errorf("page %d has not been loaded", pageNum)
+	}
+	p.dirty[pageNum] = true
+	p.changeCount++
+	return nil
+}
+
+func (p *MmapPager) ChangeCount() uint64 {
+	return p.changeCount
+}
+
+func (p *MmapPager) Stats() Stats {
+	stats := p.stats
+	stats.FileSize = p.fileLength
+	return stats
+}
+
+func (p *MmapPager) Truncate(numPages uint32) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	for pageNum := range p.dirty {
+		if pageNum >= numPages {
+			delete(p.dirty, pageNum)
+			delete(p.newPages, pageNum)
+		}
+	}
+	if p.NumPages > numPages {
+		p.NumPages = numPages
+		p.truncated = true
+	}
+	p.changeCount++
+	return nil
+}
+
+func (p *MmapPager) PageSize() int {
+	return p.pageSize
+}
+
+func (p *MmapPager) GetNextUnusedPageNum() uint32 {
+	return p.NumPages
+}
+
+func (p *MmapPager) AllocatePage() (uint32, error) {
+	if p.readOnly {
+		return 0, ErrReadOnly
+	}
+	return appendPage(p)
+}
+
+func (p *MmapPager) FreePage(pageNum uint32) error {
+	return ErrNoFreeList
+}
+
+func (p *MmapPager) Flush() error {
+	if len(p.dirty) == 0 && !p.truncated {
+		return nil
+	}
+	start := time.Now()
+
+	unlock, err := lockForCommit(p.fileDescriptor, p.busyTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	pageNums := make([]uint32, 0, len(p.dirty))
+	for pageNum := range p.dirty {
+		pageNums = append(pageNums, pageNum)
+	}
+	sort.Slice(pageNums, func(i, j int) bool { return pageNums[i] < pageNums[j] })
+
+	if err := p.resumeJournal(); err != nil {
+		return err
+	}
+	j, err := createJournal(journalPath(p.filename), p.pageSize)
+	if err != nil {
+		return err
+	}
+	for _, pageNum := range pageNums {
+		page, _ := p.Page(pageNum)
+		if _, err := j.append(pageNum, *page); err != nil {
+			j.close()
+			return err
+		}
+	}
+	if err := commitJournal(j, p.filename, p.fileDescriptor, p.NumPages, p.sync); err != nil {
+		j.close()
+		p.failedFlush = true
+		return err
+	}
+
+	p.fileLength = int64(p.NumPages) * int64(p.pageSize)
+	for _, pageNum := range pageNums {
+		// The copy in the mapping has no checksum, only the one in the file does
+		p.verified[pageNum] = true
+	}
+	p.stats.recordFlush(start, len(j.pages), j.offset+int64(len(j.pages))*int64(p.pageSize))
+	p.dirty = make(map[uint32]bool)
+	p.newPages = make(map[uint32]Page)
+	if p.truncated {
+		// Start a fresh mapping of the file, without the changes made to pages before truncating
+		p.oldMappings = append(p.oldMappings, p.mapping)
+		p.mapping = nil
+		p.verified = make(map[uint32]bool)
+		p.truncated = false
+	}
+	return p.remap()
+}
+
+// resumeJournal deals with the journal of a failed flush, see resumeJournal. The db file must be locked
+// for a commit.
+func (p *MmapPager) resumeJournal() error {
+	if !p.failedFlush {
+		return nil
+	}
+	fileLength, err := resumeJournal(p.filename, p.fileDescriptor)
+	if err != nil {
+		return err
+	}
+	p.fileLength = fileLength
+	p.failedFlush = false
+	return p.remap()
+}
+
+// lockAndResumeJournal locks the db file for a commit while dealing with the journal of a failed flush
+func (p *MmapPager) lockAndResumeJournal() error {
+	unlock, err := lockForCommit(p.fileDescriptor, p.busyTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return p.resumeJournal()
+}
+
+// Discard drops every change made since the last Flush, mapping the db file afresh
+func (p *MmapPager) Discard() error {
+	if p.readOnly {
+		return nil
+	}
+	if p.failedFlush {
+		// A committed journal survives a crash, so it cannot be discarded either
+		if err := p.lockAndResumeJournal(); err != nil {
+			return err
+		}
+	}
+	p.dirty = make(map[uint32]bool)
+	p.newPages = make(map[uint32]Page)
+	p.oldMappings = append(p.oldMappings, p.mapping)
+	p.mapping = nil
+	p.verified = make(map[uint32]bool)
+	p.truncated = false
+	p.NumPages = p.mappedPages()
+	p.changeCount++
+	return p.remap()
+}
+
+// Close flushes the pager, then unmaps and closes the db file. Changes that could not be flushed are
+// lost, and the error is returned.
+func (p *MmapPager) Close() error {
+	var err error
+	if !p.readOnly {
+		err = p.Flush()
+	}
+	for _, mapping := range append(p.oldMappings, p.mapping) {
+		syscall.Munmap(mapping)
+	}
+	p.oldMappings = nil
+	p.mapping = nil
+	if closeErr := p.fileDescriptor.Close(); err == nil {
+		err = closeErr
+	}
+	if p.writerLock != nil {
+		p.writerLock.Close()
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package data
+
+import "fmt"
+
+// MmapPager is only available on unix systems
+type MmapPager struct {
+	FilePager
+}
+
+func OpenMmapPager(filename string, opts Options) (*MmapPager, error) {
+	return nil, fmt.Errorf("mmap pager is not supported on this platform")
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapPagerRejectsPagesSmallerThanOSPages(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, err := OpenMmapPager(filename, Options{PageSize: MinPageSize})
+	if MinPageSize < os.Getpagesize() {
+		if err == nil {
+			pager.Close()
+			t.Errorf("expected an error for %d byte pages with %d byte OS pages", MinPageSize, os.Getpagesize())
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	pager.Close()
+}
+
+func TestMmapPagerSmallestPageSize(t *testing.T) {
+	pageSize := MinPageSize
+	for pageSize < os.Getpagesize() {
+		pageSize *= 2
+	}
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, err := OpenMmapPager(filename, Options{PageSize: pageSize})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	// Each flush grows the file by a page and changes the page before it, which is in the mapping
+	const numPages = 20
+	for pageNum := uint32(0); pageNum < numPages; pageNum++ {
+		if pageNum > 0 {
+			pager.MarkDirty(pageNum - 1)
+			previous, _ := pager.Page(pageNum - 1)
+			(*previous)[1] = 0x2
+		}
+		page, _ := pager.Page(pageNum)
+		(*page)[0] = byte(pageNum)
+		if err := pager.Flush(); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+
+	check := func() {
+		for pageNum := uint32(0); pageNum < numPages; pageNum++ {
+			page, err := pager.Page(pageNum)
+			if err != nil {
+				t.Errorf("unexpected error for page %d, got %+v", pageNum, err)
+			} else if (*page)[0] != byte(pageNum) || (pageNum < numPages-1 && (*page)[1] != 0x2) {
+				t.Errorf("incorrect page %d, got %+v", pageNum, (*page)[:2])
+			}
+		}
+	}
+	check()
+	pager.Close()
+
+	pager, err = OpenMmapPager(filename, Options{PageSize: pageSize, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+	check()
+}
@@ -38,13 +38,18 @@ const (
 )
 
 type Node struct {
-	page *Page
+	page    *Page
+	pageNum uint32
 }
 
 func NewNode(page *Page) *Node {
 	return &Node{page: page}
 }
 
+func (n *Node) pageSize() int {
+	return len(*n.page)
+}
+
 func (n *Node) Type() NodeType {
 	return NodeType((*n.page)[NodeTypeOffset : NodeTypeOffset+NodeTypeSize][0])
 }
@@ -73,7 +78,7 @@ func (n *Node) SetParentPointer(parent uint32) {
 	binary.LittleEndian.PutUint32((*n.page)[ParentPointerOffset:ParentPointerOffset+ParentPointerSize], parent)
 }
 
-func (n *Node) GetMaxKey() (uint32, error) {
+func (n *Node) GetMaxKey() (uint64, error) {
 	switch n.Type() {
 	case InternalNode:
 		numKeys := n.NumKeys()
@@ -67,7 +67,7 @@ func TestSetIsRoot(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		page := Page(make([]byte, PageSize))
+		page := Page(make([]byte, DefaultPageSize))
 		n := NewNode(&page)
 		n.SetIsRoot(test.isRoot)
 
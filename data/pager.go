@@ -1,153 +1,724 @@
 package data
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 )
 
-const PageSize = 4096
-const MAXPAGES = uint32(1000000)
+// Page sizes are chosen when a database is created. Offsets within a page are stored as uint16, so
+// pages can be no larger than 64K.
+const (
+	DefaultPageSize = 4096
+	MinPageSize     = 1024
+	MaxPageSize     = 65536
+)
+
+// MaxPageNum is the largest page number a database can use. Page numbers are uint32, which allows
+// databases of 16TB with the default page size; the last value is kept back to mark commit records in
+// the journal.
+const MaxPageNum = JournalCommitMarker - 1
+
+// ErrReadOnly is returned by any attempt to change a database opened read-only
+var ErrReadOnly = errors.New("database is read only")
 
 type Page []byte
 
+// Pager hands out pages that callers mutate directly. Callers must call MarkDirty on a page before
+// changing it, otherwise the change will not be written by Flush. A page may be evicted from the cache
+// once MinCacheSize other pages have been fetched, so callers should fetch pages again rather than
+// hold on to them across operations.
+//
+// Every page from a pager is PageSize bytes long.
+//
+// New pages should be obtained from AllocatePage, which reuses freed pages when the pager has a
+// free-list (see FreeListPager) and otherwise grows the file.
+//
+// ChangeCount goes up every time a page is marked dirty, so a caller can tell whether anything has
+// changed between two points in time.
+//
+// Truncate throws away every page from numPages on. The file shrinks when the change is flushed.
+//
+// Stats returns counts of the work the pager has done since it was opened.
+//
+// Close flushes any changes before closing the pager, returning the error if they could not be.
 type Pager interface {
 	Page(page uint32) (*Page, error)
+	MarkDirty(page uint32) error
 	GetNextUnusedPageNum() uint32
-	Close()
+	AllocatePage() (uint32, error)
+	FreePage(page uint32) error
+	PageSize() int
+	ChangeCount() uint64
+	Truncate(numPages uint32) error
+	Stats() Stats
+	Close() error
 	Flush() error
 }
 
+// discarder is a pager that can drop every change made to it since it was last flushed. Pages
+// returned before Discard must not be used after it.
+type discarder interface {
+	Discard() error
+}
+
+// reader is a read-only pager, which only locks the db file during read transactions
+type reader interface {
+	// beginRead starts a read transaction unless one is open, returning whether it did
+	beginRead() (bool, error)
+	endRead()
+}
+
+func beginRead(p Pager) (bool, error) {
+	if r, ok := p.(reader); ok {
+		return r.beginRead()
+	}
+	return false, nil
+}
+
+func endRead(p Pager) {
+	if r, ok := p.(reader); ok {
+		r.endRead()
+	}
+}
+
+// BeginRead starts a read transaction on a read-only pager, unless it is in one, and returns a function
+// that ends it. Every page read in a read transaction comes from the same commit, and writers cannot
+// flush until it ends. Reading a page outside of one starts one, which lasts until it is ended or the
+// pager is closed. Pages returned before a read transaction must not be used in it. For other pagers
+// BeginRead does nothing.
+func BeginRead(p Pager) (func(), error) {
+	if _, err := beginRead(p); err != nil {
+		return nil, err
+	}
+	return func() { endRead(p) }, nil
+}
+
+// Discard drops every change made to p since it was last flushed, or returns ErrCannotDiscard if p
+// cannot, as a MemoryPager holds its only copy of each page
+func Discard(p Pager) error {
+	d, ok := p.(discarder)
+	if !ok {
+		return ErrCannotDiscard
+	}
+	return d.Discard()
+}
+
+type Options struct {
+	// CacheSize is the maximum number of pages held in memory, DefaultCacheSize if zero
+	CacheSize int
+	// Mmap selects the MmapPager rather than the FilePager, which needs pages at least as big as the OS's
+	Mmap bool
+	// BusyTimeout is how long to keep retrying when another process has the database locked
+	BusyTimeout time.Duration
+	// ReadOnly opens an existing database without any way to change it, under a shared lock
+	ReadOnly bool
+	// PageSize is the size of the pages in the db file, DefaultPageSize if zero
+	PageSize int
+	// Synchronous is how much Flush syncs to disk, SyncFull if not set
+	Synchronous Synchronous
+	// Key encrypts the database with AES-GCM, see EncryptedPager. Nil for an unencrypted database.
+	Key []byte
+	// SkipChecksums reads pages from the db file without checking their checksums, for files written
+	// before pages had them. Pages are still given checksums as they are written.
+	SkipChecksums bool
+}
+
+func checkPageNum(pageNum uint32) error {
+	if pageNum > MaxPageNum {
+		return fmt.Errorf("%w: page %d, max page: %d", ErrPageOutOfBounds, pageNum, MaxPageNum)
+	}
+	return nil
+}
+
+func (opts Options) pageSize() (int, error) {
+	if opts.PageSize == 0 {
+		return DefaultPageSize, nil
+	}
+	if opts.PageSize < MinPageSize || opts.PageSize > MaxPageSize || opts.PageSize&(opts.PageSize-1) != 0 {
+		return 0, fmt.Errorf("invalid page size %d, must be a power of two from %d to %d", opts.PageSize, MinPageSize, MaxPageSize)
+	}
+	return opts.PageSize, nil
+}
+
 type MemoryPager struct {
-	pages    [MAXPAGES]Page
-	nextPage uint32
+	pages       map[uint32]Page
+	nextPage    uint32
+	pageSize    int
+	changeCount uint64
+	stats       Stats
+}
+
+// NewMemoryPager returns a MemoryPager with pages of pageSize bytes. A zero MemoryPager uses
+// DefaultPageSize.
+func NewMemoryPager(pageSize int) *MemoryPager {
+	return &MemoryPager{pageSize: pageSize}
+}
+
+func (mp *MemoryPager) PageSize() int {
+	if mp.pageSize == 0 {
+		return DefaultPageSize
+	}
+	return mp.pageSize
 }
 
 func (mp *MemoryPager) GetNextUnusedPageNum() uint32 {
 	return mp.nextPage
 }
 
-func (mp *MemoryPager) Page(page uint32) (*Page, error) {
-	if page > MAXPAGES {
-		return nil, fmt.Errorf("page out of bounds, max pages: %d", MAXPAGES)
+func (mp *MemoryPager) Page(pageNum uint32) (*Page, error) {
+	if err := checkPageNum(pageNum); err != nil {
+		return nil, err
+	}
+
+	if mp.pages == nil {
+		mp.pages = make(map[uint32]Page)
+	}
+	if mp.pages[pageNum] == nil {
+		mp.pages[pageNum] = make([]byte, mp.PageSize())
+		mp.stats.CacheMisses++
+		mp.stats.PagesAllocated++
+	} else {
+		mp.stats.CacheHits++
 	}
 
-	if mp.pages[page] == nil {
-		mp.pages[page] = make([]byte, PageSize)
+	if pageNum >= mp.nextPage {
+		mp.nextPage = pageNum + 1
 	}
 
-	if page >= mp.nextPage {
-		mp.nextPage = page + 1
+	page := mp.pages[pageNum]
+	return &page, nil
+}
+
+func (mp *MemoryPager) MarkDirty(page uint32) error {
+	mp.changeCount++
+	return nil
+}
+
+func (mp *MemoryPager) ChangeCount() uint64 {
+	return mp.changeCount
+}
+
+// Stats only counts calls to Page and pages allocated, as a MemoryPager has no file
+func (mp *MemoryPager) Stats() Stats {
+	return mp.stats
+}
+
+func (mp *MemoryPager) Truncate(numPages uint32) error {
+	for pageNum := range mp.pages {
+		if pageNum >= numPages {
+			delete(mp.pages, pageNum)
+		}
+	}
+	if mp.nextPage > numPages {
+		mp.nextPage = numPages
 	}
+	mp.changeCount++
+	return nil
+}
+
+func (mp *MemoryPager) AllocatePage() (uint32, error) {
+	return appendPage(mp)
+}
 
-	return &mp.pages[page], nil
+func (mp *MemoryPager) FreePage(page uint32) error {
+	return ErrNoFreeList
 }
 
-func (mp *MemoryPager) Close() {}
+func (mp *MemoryPager) Close() error { return nil }
 
 func (mp *MemoryPager) Flush() error { return nil }
 
 type FilePager struct {
+	filename       string
 	fileDescriptor *os.File
+	writerLock     *os.File
+	busyTimeout    time.Duration
 	fileLength     int64
-	pages          [MAXPAGES]Page
-	NumPages       uint32
+	cache          *pageCache
+	journal        *journal
+	spilled        map[uint32]int64
+	// Set when a flush failed, leaving its journal behind to be replayed or discarded
+	failedFlush bool
+	// Set while a reader is in a read transaction
+	reading       bool
+	readOnly      bool
+	skipChecksums bool
+	pageSize      int
+	sync          Synchronous
+	changeCount   uint64
+	stats         Stats
+	NumPages      uint32
 }
 
 func NewFilePager(filename string) (*FilePager, error) {
-	p := FilePager{}
+	return OpenFilePager(filename, Options{})
+}
+
+func OpenFilePager(filename string, opts Options) (*FilePager, error) {
+	pageSize, err := opts.pageSize()
+	if err != nil {
+		return nil, err
+	}
+	p := FilePager{cache: newPageCache(opts.CacheSize), spilled: make(map[uint32]int64), pageSize: pageSize}
+	file, writerLock, fileLength, err := openDBFile(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	p.filename = filename
+	p.fileDescriptor = file
+	p.writerLock = writerLock
+	p.busyTimeout = opts.BusyTimeout
+	p.fileLength = fileLength
+	p.readOnly = opts.ReadOnly
+	p.skipChecksums = opts.SkipChecksums
+	p.sync = opts.Synchronous
+	p.NumPages = uint32(p.fileLength / int64(pageSize))
+
+	return &p, nil
+}
+
+// Open opens a db file with the pager selected by opts, encrypting it if opts has a key
+func Open(filename string, opts Options) (Pager, error) {
+	var pager Pager
+	if opts.Mmap {
+		mmapPager, err := OpenMmapPager(filename, opts)
+		if err != nil {
+			return nil, err
+		}
+		pager = mmapPager
+	} else {
+		filePager, err := OpenFilePager(filename, opts)
+		if err != nil {
+			return nil, err
+		}
+		pager = filePager
+	}
+
+	if opts.Key == nil {
+		return pager, nil
+	}
+	encrypted, err := NewEncryptedPager(pager, opts)
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+// openDBFile opens, or creates, a db file, locks it and recovers any journal left behind by a failed
+// flush. Writers are also given the lock file that keeps other writers out, which they hold until
+// they close. Read-only databases must already exist, and are only locked while they are checked.
+func openDBFile(filename string, opts Options) (*os.File, *os.File, int64, error) {
+	pageSize, err := opts.pageSize()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if opts.Synchronous < SyncFull || opts.Synchronous > SyncOff {
+		return nil, nil, 0, fmt.Errorf("unknown synchronous mode %d", opts.Synchronous)
+	}
+
 	var file *os.File
+	if opts.ReadOnly {
+		file, err := os.OpenFile(filename, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		fileLength, err := lockForRead(filename, file, pageSize, opts.BusyTimeout)
+		if err != nil {
+			file.Close()
+			return nil, nil, 0, err
+		}
+		// The first read transaction locks the file again
+		unlockFile(file)
+		return file, nil, fileLength, nil
+	}
+
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		file, err = os.Create(filename)
 		if err != nil {
-			return nil, err
+			return nil, nil, 0, err
 		}
 	} else {
-		file, err = os.OpenFile(filename, os.O_RDWR, os.ModeExclusive)
+		file, err = os.OpenFile(filename, os.O_RDWR, 0644)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, 0, err
 		}
 	}
-	p.fileDescriptor = file
 
-	p.fileLength, _ = p.fileDescriptor.Seek(0, 2)
-	for i, _ := range p.pages {
-		p.pages[i] = nil
+	writerLock, err := os.OpenFile(lockPath(filename), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		file.Close()
+		return nil, nil, 0, err
+	}
+	closeFiles := func() {
+		file.Close()
+		writerLock.Close()
+	}
+	if err := lockFile(writerLock, true, opts.BusyTimeout); err != nil {
+		closeFiles()
+		return nil, nil, 0, err
+	}
+
+	// The journal must not be touched while anyone else has the db file open
+	if err := lockFile(file, true, opts.BusyTimeout); err != nil {
+		closeFiles()
+		return nil, nil, 0, err
 	}
-	p.NumPages = uint32(p.fileLength) / uint32(PageSize)
 
-	if p.fileLength%int64(PageSize) != 0 {
-		return nil, fmt.Errorf("db file is not a whole number of pages, corrupt file")
+	// A journal left behind means a flush did not complete
+	if err := recoverJournal(filename, file); err != nil {
+		closeFiles()
+		return nil, nil, 0, err
 	}
-	return &p, nil
+
+	// Readers can open the db file alongside us until the next flush
+	if err := lockFile(file, false, 0); err != nil {
+		closeFiles()
+		return nil, nil, 0, err
+	}
+
+	file, fileLength, err := checkDBFile(file, pageSize)
+	if err != nil {
+		writerLock.Close()
+		return nil, nil, 0, err
+	}
+	return file, writerLock, fileLength, nil
 }
 
-func (p *FilePager) Close() {
-	p.Flush()
-	p.fileDescriptor.Close()
+// checkDBFile returns the length of an opened db file, closing it if the file is not valid
+func checkDBFile(file *os.File, pageSize int) (*os.File, int64, error) {
+	fileLength, err := file.Seek(0, 2)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	if fileLength%int64(pageSize) != 0 {
+		file.Close()
+		return nil, 0, corruptf("db file is not a whole number of pages")
+	}
+	return file, fileLength, nil
 }
 
-func (p *FilePager) Flush() error {
-	for i, page := range p.pages {
+// Close flushes the pager, then closes its files. Changes that could not be flushed are lost, and the
+// error is returned.
+func (p *FilePager) Close() error {
+	var err error
+	if !p.readOnly {
+		err = p.Flush()
+	}
+	if closeErr := p.fileDescriptor.Close(); err == nil {
+		err = closeErr
+	}
+	if p.writerLock != nil {
+		p.writerLock.Close()
+	}
+	return err
+}
+
+// MarkDirty records that a page has been, or is about to be, changed so the next Flush writes it
+func (p *FilePager) MarkDirty(pageNum uint32) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if !p.cache.markDirty(pageNum) {
+		return fmt.Errorf("page %d is not in the cache", pageNum)
+	}
+	p.changeCount++
+	return nil
+}
+
+func (p *FilePager) ChangeCount() uint64 {
+	return p.changeCount
+}
 
-		if page == nil {
-			continue
+func (p *FilePager) Truncate(numPages uint32) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	p.cache.truncate(numPages)
+	for pageNum := range p.spilled {
+		if pageNum >= numPages {
+			delete(p.spilled, pageNum)
 		}
+	}
+	if p.NumPages > numPages {
+		p.NumPages = numPages
+	}
+	p.changeCount++
+	return nil
+}
 
-		position := int64(i * int(PageSize))
-		_, err := p.fileDescriptor.Seek(position, 0)
-		if err != nil {
+// Flush writes the dirty pages to the journal, commits it, then copies the pages into the db file.
+// A crash at any point leaves either the old or the new contents of every page, never a mix. Flush
+// returns ErrLocked, changing nothing, if readers still have the db file open once the busy timeout
+// has run out.
+func (p *FilePager) Flush() error {
+	dirty := p.cache.dirtyPages()
+	if len(dirty) == 0 && p.journal == nil && int64(p.NumPages)*int64(p.pageSize) == p.fileLength {
+		return nil
+	}
+	start := time.Now()
+
+	unlock, err := lockForCommit(p.fileDescriptor, p.busyTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := p.resumeJournal(); err != nil {
+		return err
+	}
+	if err := p.openJournal(); err != nil {
+		return err
+	}
+
+	for _, entry := range dirty {
+		if _, err := p.journal.append(entry.pageNum, entry.page); err != nil {
 			return err
 		}
+	}
 
-		bytesWritten, err := p.fileDescriptor.Write(page)
+	j := p.journal
+	p.journal = nil
+	if err := commitJournal(j, p.filename, p.fileDescriptor, p.NumPages, p.sync); err != nil {
+		if restoreErr := p.restoreSpilled(j); restoreErr != nil {
+			err = fmt.Errorf("%w, then reading back the spilled pages: %v", err, restoreErr)
+		}
+		j.close()
+		p.failedFlush = true
+		return err
+	}
+	p.fileLength = int64(p.NumPages) * int64(p.pageSize)
+	for _, entry := range dirty {
+		entry.dirty = false
+	}
+	p.spilled = make(map[uint32]int64)
+	p.stats.recordFlush(start, len(j.pages), j.offset+int64(len(j.pages))*int64(p.pageSize))
 
-		if err != nil {
+	return nil
+}
+
+// Discard drops every change made since the last Flush, leaving the pager as the db file is
+func (p *FilePager) Discard() error {
+	if p.readOnly {
+		return nil
+	}
+	if p.failedFlush {
+		// A committed journal survives a crash, so it cannot be discarded either
+		if err := p.lockAndResumeJournal(); err != nil {
 			return err
 		}
+	}
+	p.cache.discardDirty()
+	p.spilled = make(map[uint32]int64)
+	p.NumPages = uint32(p.fileLength / int64(p.pageSize))
+	p.changeCount++
+	if p.journal == nil {
+		return nil
+	}
+	j := p.journal
+	p.journal = nil
+	j.close()
+	return os.Remove(journalPath(p.filename))
+}
+
+// resumeJournal deals with the journal of a failed flush, see resumeJournal. The db file must be locked
+// for a commit.
+func (p *FilePager) resumeJournal() error {
+	if !p.failedFlush {
+		return nil
+	}
+	fileLength, err := resumeJournal(p.filename, p.fileDescriptor)
+	if err != nil {
+		return err
+	}
+	p.fileLength = fileLength
+	p.failedFlush = false
+	return nil
+}
+
+// lockAndResumeJournal locks the db file for a commit while dealing with the journal of a failed flush
+func (p *FilePager) lockAndResumeJournal() error {
+	unlock, err := lockForCommit(p.fileDescriptor, p.busyTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return p.resumeJournal()
+}
+
+// beginRead starts a read transaction if the pager is a reader and is not in one. The db file may have
+// changed since the last one, so every cached page is dropped.
+func (p *FilePager) beginRead() (bool, error) {
+	if !p.readOnly || p.reading {
+		return false, nil
+	}
+	fileLength, err := lockForRead(p.filename, p.fileDescriptor, p.pageSize, p.busyTimeout)
+	if err != nil {
+		return false, err
+	}
+	p.cache.truncate(0)
+	p.fileLength = fileLength
+	p.NumPages = uint32(fileLength / int64(p.pageSize))
+	p.reading = true
+	return true, nil
+}
+
+func (p *FilePager) endRead() {
+	if p.reading {
+		unlockFile(p.fileDescriptor)
+		p.reading = false
+	}
+}
+
+func (p *FilePager) openJournal() error {
+	if p.journal != nil {
+		return nil
+	}
+	j, err := createJournal(journalPath(p.filename), p.pageSize)
+	if err != nil {
+		return err
+	}
+	p.journal = j
+	return nil
+}
+
+// restoreSpilled reads the pages spilled to j back into the cache as dirty pages, so they are written
+// again by the next flush, and forgets where they were in j. A spilled page still in the cache was read
+// back from j, and may have changed since, so it is kept and marked dirty.
+func (p *FilePager) restoreSpilled(j *journal) error {
+	for pageNum, offset := range p.spilled {
+		if !p.cache.markDirty(pageNum) {
+			page := make(Page, p.pageSize)
+			if err := j.readFrame(offset, page); err != nil {
+				return err
+			}
+			p.cache.put(pageNum, page, true)
+		}
+		delete(p.spilled, pageNum)
+	}
+	return nil
+}
 
-		if bytesWritten != int(PageSize) {
-			return fmt.Errorf("incorrect number of bytes written: %d", bytesWritten)
+// evict makes room in the cache for another page. Dirty pages are spilled to the journal rather than
+// the db file so the batch they belong to is still committed, or discarded, as a whole.
+func (p *FilePager) evict() error {
+	for p.cache.full() {
+		victim := p.cache.victim()
+		if victim.dirty {
+			if p.failedFlush {
+				if err := p.lockAndResumeJournal(); err != nil {
+					return err
+				}
+			}
+			if err := p.openJournal(); err != nil {
+				return err
+			}
+			offset, err := p.journal.append(victim.pageNum, victim.page)
+			if err != nil {
+				return err
+			}
+			p.spilled[victim.pageNum] = offset
 		}
+		p.cache.remove(victim.pageNum)
 	}
 	return nil
 }
 
+func (p *FilePager) CacheStats() CacheStats {
+	return p.cache.stats()
+}
+
+func (p *FilePager) Stats() Stats {
+	stats := p.stats
+	cache := p.cache.stats()
+	stats.CacheHits, stats.CacheMisses = cache.Hits, cache.Misses
+	stats.CachedPages, stats.CacheCapacity = cache.Size, cache.Capacity
+	stats.FileSize = p.fileLength
+	return stats
+}
+
+func (p *FilePager) PageSize() int {
+	return p.pageSize
+}
+
 func (p *FilePager) GetNextUnusedPageNum() uint32 {
 	return p.NumPages
 }
 
+func (p *FilePager) AllocatePage() (uint32, error) {
+	if p.readOnly {
+		return 0, ErrReadOnly
+	}
+	return appendPage(p)
+}
+
+func (p *FilePager) FreePage(pageNum uint32) error {
+	return ErrNoFreeList
+}
+
 func (p *FilePager) Page(pageNum uint32) (*Page, error) {
-	if pageNum > MAXPAGES {
-		return nil, fmt.Errorf("pageNum out of bounds, max pages: %d", MAXPAGES)
+	if err := checkPageNum(pageNum); err != nil {
+		return nil, err
+	}
+	if _, err := p.beginRead(); err != nil {
+		return nil, err
 	}
 
-	if p.pages[pageNum] == nil {
-		p.pages[pageNum] = make([]byte, PageSize)
-		num_pages := p.fileLength / int64(PageSize)
+	page, found := p.cache.get(pageNum)
+	if !found {
+		if err := p.evict(); err != nil {
+			return nil, err
+		}
+
+		page = make([]byte, p.pageSize)
+		dirty := false
+		num_pages := p.fileLength / int64(p.pageSize)
 
-		if p.fileLength%int64(PageSize) != 0 {
+		if p.fileLength%int64(p.pageSize) != 0 {
 			num_pages += 1
 		}
 
-		if int64(pageNum) < num_pages {
-			p.fileDescriptor.Seek(int64(pageNum)*int64(PageSize), 0)
-
-			bytesRead, err := p.fileDescriptor.Read(p.pages[pageNum])
+		if offset, spilled := p.spilled[pageNum]; spilled {
+			// The latest version of the page is in the journal, waiting to be committed
+			if err := p.journal.readFrame(offset, page); err != nil {
+				return nil, err
+			}
+			p.stats.PagesRead++
+			if err := verifyChecksum(pageNum, page); err != nil {
+				return nil, err
+			}
+		} else if int64(pageNum) >= num_pages || pageNum >= p.NumPages {
+			if p.readOnly {
+				return nil, fmt.Errorf("%w: page %d does not exist", ErrPageOutOfBounds, pageNum)
+			}
+			// Pages past the end of the file only exist once they have been flushed
+			dirty = true
+			p.stats.PagesAllocated++
+		} else {
+			bytesRead, err := p.fileDescriptor.ReadAt(page, int64(pageNum)*int64(p.pageSize))
 			if err != nil {
 				return nil, err
 			}
-			if bytesRead == -1 {
+			if bytesRead != p.pageSize {
 				return nil, fmt.Errorf("error reading file")
 			}
-
+			p.stats.PagesRead++
+			if !p.skipChecksums {
+				if err := verifyChecksum(pageNum, page); err != nil {
+					return nil, err
+				}
+			}
 		}
-
+		p.cache.put(pageNum, page, dirty)
 	}
 
 	if pageNum >= p.NumPages {
 		p.NumPages = pageNum + 1
 	}
-	page := p.pages[pageNum]
 	return &page, nil
 }
@@ -0,0 +1,268 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pagerTypes lists the file backed pagers that the pager level tests are run against
+var pagerTypes = []struct {
+	name string
+	opts Options
+}{
+	{"file", Options{}},
+	{"mmap", Options{Mmap: true}},
+}
+
+func TestFlushOnlyWritesDirtyPages(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		writeDBFile(filename, make([]byte, DefaultPageSize), make([]byte, DefaultPageSize))
+
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		readPage, _ := pager.Page(0)
+		(*readPage)[0] = 0x1 // changed without being marked dirty, so should not be written
+
+		writePage, _ := pager.Page(1)
+		pager.MarkDirty(1)
+		(*writePage)[0] = 0x2
+
+		if err := pager.Flush(); err != nil {
+			t.Errorf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		pager.Close()
+
+		contents, _ := os.ReadFile(filename)
+		if contents[0] != 0x0 {
+			t.Errorf("%s: clean page was written, expected %d, got %d", pt.name, 0x0, contents[0])
+		}
+		if contents[DefaultPageSize] != 0x2 {
+			t.Errorf("%s: dirty page was not written, expected %d, got %d", pt.name, 0x2, contents[DefaultPageSize])
+		}
+	}
+}
+
+func TestNewPagesAreDirty(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		pager.Page(0)
+		page, _ := pager.Page(1)
+		(*page)[0] = 0x1
+		pager.Close()
+
+		contents, _ := os.ReadFile(filename)
+		if len(contents) != 2*DefaultPageSize {
+			t.Errorf("%s: incorrect file length, expected %d, got %d", pt.name, 2*DefaultPageSize, len(contents))
+		} else if contents[DefaultPageSize] != 0x1 {
+			t.Errorf("%s: incorrect byte at start of page 1, expected %d, got %d", pt.name, 0x1, contents[DefaultPageSize])
+		}
+	}
+}
+
+func TestMarkDirtyUnloadedPage(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		if err := pager.MarkDirty(3); err == nil {
+			t.Errorf("%s: expected an error marking a page that has not been loaded", pt.name)
+		}
+		pager.Close()
+	}
+}
+
+func TestPagesSurviveReopen(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		// Flush part way through so some pages are read back from the file and some are new
+		writePages(pager, 10, 0x1)
+		pager.Flush()
+		writePages(pager, 20, 0x2)
+		pager.Close()
+
+		pager, err = Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		if pager.GetNextUnusedPageNum() != 20 {
+			t.Errorf("%s: incorrect number of pages, expected %d, got %d", pt.name, 20, pager.GetNextUnusedPageNum())
+		}
+		for i := uint32(0); i < 20; i++ {
+			page, _ := pager.Page(i)
+			if (*page)[0] != 0x2 || (*page)[1] != byte(i) {
+				t.Errorf("%s: incorrect contents for page %d", pt.name, i)
+			}
+		}
+		pager.Close()
+	}
+}
+
+func TestReadOnlyPager(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, _ := Open(filename, pt.opts)
+		page, _ := pager.Page(0)
+		(*page)[0] = 0x1
+		pager.Close()
+		before, _ := os.ReadFile(filename)
+
+		opts := pt.opts
+		opts.ReadOnly = true
+		pager, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		page, err = pager.Page(0)
+		if err != nil || (*page)[0] != 0x1 {
+			t.Errorf("%s: could not read page 0, got %+v", pt.name, err)
+		}
+		if err := pager.MarkDirty(0); err != ErrReadOnly {
+			t.Errorf("%s: expected %v marking a page dirty, got %+v", pt.name, ErrReadOnly, err)
+		}
+		if _, err := pager.AllocatePage(); err != ErrReadOnly {
+			t.Errorf("%s: expected %v allocating a page, got %+v", pt.name, ErrReadOnly, err)
+		}
+		if _, err := pager.Page(1); err == nil {
+			t.Errorf("%s: expected an error reading past the end of the file", pt.name)
+		}
+
+		// Other readers can share the database, writers cannot
+		reader, err := Open(filename, opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error opening second reader, got %+v", pt.name, err)
+		} else {
+			reader.Close()
+		}
+		if _, err := Open(filename, pt.opts); err != ErrLocked {
+			t.Errorf("%s: expected %v opening a writer, got %+v", pt.name, ErrLocked, err)
+		}
+
+		pager.Close()
+		after, _ := os.ReadFile(filename)
+		if !bytes.Equal(before, after) {
+			t.Errorf("%s: read-only pager changed the db file", pt.name)
+		}
+	}
+}
+
+func TestReadOnlyRequiresExistingFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Open(filename, Options{ReadOnly: true}); err == nil {
+		t.Errorf("expected an error opening a missing file read-only")
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("read-only open created the db file")
+	}
+}
+
+func TestPageNumbersPastOneMillion(t *testing.T) {
+	pager := &MemoryPager{}
+	for _, pageNum := range []uint32{2000000, MaxPageNum} {
+		if _, err := pager.Page(pageNum); err != nil {
+			t.Errorf("unexpected error for page %d, got %+v", pageNum, err)
+		}
+	}
+	if _, err := pager.Page(MaxPageNum + 1); !errors.Is(err, ErrPageOutOfBounds) {
+		t.Errorf("expected %v for page %d, got %+v", ErrPageOutOfBounds, uint32(MaxPageNum+1), err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(pager, 20, 0x1)
+		pager.Flush()
+		// Some pages past the new end are changed but not yet flushed
+		writePages(pager, 15, 0x2)
+
+		if err := pager.Truncate(10); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		if pager.GetNextUnusedPageNum() != 10 {
+			t.Errorf("%s: incorrect number of pages, expected %d, got %d", pt.name, 10, pager.GetNextUnusedPageNum())
+		}
+		if err := pager.Flush(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		if info, _ := os.Stat(filename); info.Size() != 10*DefaultPageSize {
+			t.Errorf("%s: incorrect file length, expected %d, got %d", pt.name, 10*DefaultPageSize, info.Size())
+		}
+
+		// A page past the end comes back empty
+		page, err := pager.Page(12)
+		if err != nil || (*page)[0] != 0x0 {
+			t.Errorf("%s: page past the end was not empty, got %+v", pt.name, err)
+		}
+		pager.Close()
+
+		pager, _ = Open(filename, pt.opts)
+		for i := uint32(0); i < 10; i++ {
+			page, _ := pager.Page(i)
+			if (*page)[0] != 0x2 || (*page)[1] != byte(i) {
+				t.Errorf("%s: incorrect contents for page %d", pt.name, i)
+			}
+		}
+		pager.Close()
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		opts := pt.opts
+		// A small cache makes some of the changed pages spill to the journal before they are discarded
+		opts.CacheSize = MinCacheSize
+		pager, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(pager, 20, 0x1)
+		pager.Flush()
+		writePages(pager, 100, 0x2)
+
+		if err := pager.(discarder).Discard(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		if pager.GetNextUnusedPageNum() != 20 {
+			t.Errorf("%s: incorrect number of pages, expected %d, got %d", pt.name, 20, pager.GetNextUnusedPageNum())
+		}
+		for i := uint32(0); i < 20; i++ {
+			page, _ := pager.Page(i)
+			if (*page)[0] != 0x1 || (*page)[1] != byte(i) {
+				t.Errorf("%s: incorrect contents for page %d", pt.name, i)
+			}
+		}
+		if _, err := os.Stat(journalPath(filename)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the journal to be removed, got %+v", pt.name, err)
+		}
+		pager.Close()
+		if info, _ := os.Stat(filename); info.Size() != 20*DefaultPageSize {
+			t.Errorf("%s: incorrect file length, expected %d, got %d", pt.name, 20*DefaultPageSize, info.Size())
+		}
+	}
+}
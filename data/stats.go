@@ -0,0 +1,49 @@
+package data
+
+import "time"
+
+// Stats counts the work a pager has done since it was opened
+type Stats struct {
+	// PagesRead is the number of pages read from the db file or journal
+	PagesRead uint64
+	// PagesWritten is the number of pages flushed into the db file
+	PagesWritten uint64
+	// CacheHits and CacheMisses count the calls to Page that did and did not find the page in memory
+	CacheHits   uint64
+	CacheMisses uint64
+	// CachedPages is how many pages are in memory, of at most CacheCapacity. Zero for pagers without
+	// a bounded cache.
+	CachedPages   int
+	CacheCapacity int
+	// BytesFlushed is the number of bytes Flush wrote to the journal and the db file
+	BytesFlushed uint64
+	// PagesAllocated is the number of pages added to the end of the file
+	PagesAllocated uint64
+	// PagesReused is the number of pages allocated from the free-list
+	PagesReused uint64
+	// Flushes is the number of flushes that wrote anything, FlushTime how long they took altogether
+	// and LastFlushTime how long the latest took
+	Flushes       uint64
+	FlushTime     time.Duration
+	LastFlushTime time.Duration
+	// FileSize is the size of the db file in bytes, as of the last flush
+	FileSize int64
+}
+
+// MeanFlushTime returns the average time a flush took
+func (s Stats) MeanFlushTime() time.Duration {
+	if s.Flushes == 0 {
+		return 0
+	}
+	return s.FlushTime / time.Duration(s.Flushes)
+}
+
+// recordFlush adds a flush that started at start and wrote bytes bytes, including pages pages into the
+// db file
+func (s *Stats) recordFlush(start time.Time, pages int, bytes int64) {
+	s.LastFlushTime = time.Since(start)
+	s.FlushTime += s.LastFlushTime
+	s.Flushes++
+	s.PagesWritten += uint64(pages)
+	s.BytesFlushed += uint64(bytes)
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPagerStats(t *testing.T) {
+	for _, pt := range pagerTypes {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		pager, err := Open(filename, pt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+		writePages(pager, 10, 0x1)
+		if err := pager.Flush(); err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+		}
+
+		stats := pager.Stats()
+		if stats.PagesAllocated != 10 || stats.PagesWritten != 10 || stats.Flushes != 1 {
+			t.Errorf("%s: incorrect stats after writing, got %+v", pt.name, stats)
+		}
+		// Every page goes through the journal as well as into the db file
+		if stats.BytesFlushed <= 20*DefaultPageSize {
+			t.Errorf("%s: incorrect bytes flushed, expected more than %d, got %d", pt.name, 20*DefaultPageSize, stats.BytesFlushed)
+		}
+		if stats.FileSize != 10*DefaultPageSize {
+			t.Errorf("%s: incorrect file size, expected %d, got %d", pt.name, 10*DefaultPageSize, stats.FileSize)
+		}
+		pager.Close()
+
+		pager, _ = Open(filename, pt.opts)
+		for i := 0; i < 2; i++ {
+			for pageNum := uint32(0); pageNum < 5; pageNum++ {
+				pager.Page(pageNum)
+			}
+		}
+		stats = pager.Stats()
+		if stats.PagesRead != 5 || stats.CacheMisses != 5 || stats.CacheHits != 5 {
+			t.Errorf("%s: incorrect stats after reading, got %+v", pt.name, stats)
+		}
+		if stats.Flushes != 0 || stats.MeanFlushTime() != 0 {
+			t.Errorf("%s: unexpected flushes, got %+v", pt.name, stats)
+		}
+		pager.Close()
+	}
+}
+
+func TestFreeListPagerStats(t *testing.T) {
+	pager := NewFreeListPager(NewMemoryPager(MinPageSize), 0, 0)
+	pager.Page(0)
+	pageNum, _ := pager.AllocatePage()
+	pager.FreePage(pageNum)
+	pager.AllocatePage()
+
+	if stats := pager.Stats(); stats.PagesAllocated != 2 || stats.PagesReused != 1 {
+		t.Errorf("incorrect stats, expected %d allocated and %d reused, got %+v", 2, 1, stats)
+	}
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Synchronous controls how hard Flush works to make sure a batch has reached the disk before it
+// returns, trading durability for throughput
+type Synchronous int
+
+const (
+	// SyncFull fsyncs the journal when it is committed, the db file before the journal is removed and
+	// the directory holding them. A batch that has been flushed survives a power failure.
+	SyncFull Synchronous = iota
+	// SyncNormal only fsyncs the journal when it is committed. A crash of the process loses nothing,
+	// but a power failure can lose the last few batches, or on some file systems damage the db.
+	SyncNormal
+	// SyncOff never fsyncs and leaves it to the operating system to write the pages out. A crash of
+	// the process loses nothing, a power failure can lose or damage anything.
+	SyncOff
+)
+
+func (s Synchronous) String() string {
+	switch s {
+	case SyncFull:
+		return "full"
+	case SyncNormal:
+		return "normal"
+	case SyncOff:
+		return "off"
+	}
+	return fmt.Sprintf("Synchronous(%d)", int(s))
+}
+
+// ParseSynchronous returns the mode named by s, one of off, normal or full
+func ParseSynchronous(s string) (Synchronous, error) {
+	for _, mode := range []Synchronous{SyncOff, SyncNormal, SyncFull} {
+		if s == mode.String() {
+			return mode, nil
+		}
+	}
+	return SyncFull, fmt.Errorf("unknown synchronous mode %q, expected off, normal or full", s)
+}
+
+// syncFile flushes a file to disk, every fsync the pagers make goes through it
+var syncFile = func(file *os.File) error {
+	return file.Sync()
+}
+
+// syncDir makes the creation or removal of a file in the directory holding path durable. Windows
+// cannot open a directory to sync it, nor needs to.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return syncFile(dir)
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSynchronousModes(t *testing.T) {
+	tests := []struct {
+		mode          Synchronous
+		expectedSyncs int
+	}{
+		{SyncOff, 0},
+		// The journal
+		{SyncNormal, 1},
+		// The journal, the directory once it is created, the db file and the directory once the
+		// journal is removed
+		{SyncFull, 4},
+	}
+
+	defer func(f func(*os.File) error) { syncFile = f }(syncFile)
+	for _, pt := range pagerTypes {
+		for _, test := range tests {
+			syncs := 0
+			syncFile = func(file *os.File) error {
+				syncs++
+				return file.Sync()
+			}
+
+			opts := pt.opts
+			opts.Synchronous = test.mode
+			pager, err := Open(filepath.Join(t.TempDir(), "test.db"), opts)
+			if err != nil {
+				t.Fatalf("%s: unexpected error, got %+v", pt.name, err)
+			}
+			pager.Page(0)
+			pager.MarkDirty(0)
+			if err := pager.Flush(); err != nil {
+				t.Errorf("%s %s: unexpected error, got %+v", pt.name, test.mode, err)
+			}
+			if syncs != test.expectedSyncs {
+				t.Errorf("%s %s: incorrect number of syncs, expected %d, got %d", pt.name, test.mode, test.expectedSyncs, syncs)
+			}
+			pager.Close()
+		}
+	}
+}
+
+func TestParseSynchronous(t *testing.T) {
+	for _, mode := range []Synchronous{SyncOff, SyncNormal, SyncFull} {
+		parsed, err := ParseSynchronous(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("incorrect mode parsed, expected %s, got %s, %+v", mode, parsed, err)
+		}
+	}
+	if _, err := ParseSynchronous("sometimes"); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
+
+func TestUnknownSynchronousMode(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "test.db"), Options{Synchronous: SyncOff + 1}); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
@@ -9,49 +9,81 @@ func NewTree(pager Pager, rootPageNum uint32) *Tree {
 	return &Tree{pager: pager, rootPageNum: rootPageNum}
 }
 
-func (t *Tree) Get(key uint32) IndexItem {
-	rootPage, _ := t.pager.Page(t.rootPageNum)
-	root := &Node{page: rootPage}
+// Get returns the item stored against key, or ErrKeyNotFound
+func (t *Tree) Get(key uint64) (IndexItem, error) {
+	c, found, err := t.find(key)
+	if err != nil {
+		return IndexItem{}, err
+	}
+	if !found {
+		return IndexItem{}, ErrKeyNotFound
+	}
+	return c.Node.GetNodeValue(c.Index), nil
+}
 
-	switch root.Type() {
-	case LeafNode:
-		c, found := t.leafNodeFind(root, key)
-		if found {
-			return c.Node.GetNodeValue(c.Index)
-		}
-	case InternalNode:
-		c, found := t.internalNodeFind(root, key)
-		if found {
-			return c.Node.GetNodeValue(c.Index)
+// Insert adds key to the tree. Keys already in the tree are left unchanged.
+func (t *Tree) Insert(key uint64, data IndexItem) error {
+	// Add to tree
+	c, found, err := t.find(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if err := t.pager.MarkDirty(c.Node.pageNum); err != nil {
+			return err
 		}
+		return t.leafInsert(c, key, data)
 	}
-	return IndexItem{}
-
+	return nil
 }
 
-func (t *Tree) Insert(key uint32, data IndexItem) {
-	// Add to tree
-	rootPage, _ := t.pager.Page(t.rootPageNum)
-	root := &Node{page: rootPage}
-	var c Cursor
-	var found bool
-	switch root.Type() {
-	case LeafNode:
-		c, found = t.leafNodeFind(root, key)
+// node fetches a page and wraps it as a Node
+func (t *Tree) node(pageNum uint32) (*Node, error) {
+	page, err := t.pager.Page(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{page: page, pageNum: pageNum}, nil
+}
 
-	case InternalNode:
-		c, found = t.internalNodeFind(root, key)
+// isRoot reports whether n is the tree's root. Indexes created by older versions never set the root
+// flag, so the page number is checked as well
+func (t *Tree) isRoot(n *Node) bool {
+	return n.IsRoot() || n.pageNum == t.rootPageNum
+}
 
+// dirtyNode fetches a page that is about to be changed
+func (t *Tree) dirtyNode(pageNum uint32) (*Node, error) {
+	n, err := t.node(pageNum)
+	if err != nil {
+		return nil, err
 	}
-	if !found {
-		t.leafInsert(c, key, data)
+	if err := t.pager.MarkDirty(pageNum); err != nil {
+		return nil, err
 	}
+	return n, nil
+}
 
+// find returns the position in the tree the key should be in, starting from the root
+func (t *Tree) find(key uint64) (Cursor, bool, error) {
+	root, err := t.node(t.rootPageNum)
+	if err != nil {
+		return Cursor{}, false, err
+	}
+
+	switch root.Type() {
+	case LeafNode:
+		c, found := t.leafNodeFind(root, key)
+		return c, found, nil
+	case InternalNode:
+		return t.internalNodeFind(root, key)
+	}
+	return Cursor{}, false, corruptf("page %d has unknown node type %d", root.pageNum, root.Type())
 }
 
-func (t *Tree) internalNodeFind(n *Node, key uint32) (Cursor, bool) {
-	numKeys := n.NumKeys()
-	minIndex, maxIndex := uint16(0), numKeys
+// internalKeyIndex returns the index of the child that key belongs in
+func (n *Node) internalKeyIndex(key uint64) uint16 {
+	minIndex, maxIndex := uint16(0), n.NumKeys()
 	for minIndex != maxIndex {
 		index := (minIndex + maxIndex) / 2
 
@@ -59,122 +91,163 @@ func (t *Tree) internalNodeFind(n *Node, key uint32) (Cursor, bool) {
 		if keyToRight >= key {
 			maxIndex = index
 		} else {
-			minIndex += 1
+			minIndex = index + 1
 		}
 	}
+	return minIndex
+}
 
-	childPageNum := n.ChildPointer(minIndex)
-	childPage, _ := t.pager.Page(childPageNum)
-	child := Node{page: childPage}
+func (t *Tree) internalNodeFind(n *Node, key uint64) (Cursor, bool, error) {
+	child, err := t.node(n.ChildPointer(n.internalKeyIndex(key)))
+	if err != nil {
+		return Cursor{}, false, err
+	}
 
 	switch child.Type() {
 	case LeafNode:
-		return t.leafNodeFind(&child, key)
+		c, found := t.leafNodeFind(child, key)
+		return c, found, nil
 	case InternalNode:
-		return t.internalNodeFind(&child, key)
+		return t.internalNodeFind(child, key)
 	}
-	return Cursor{}, false
+	return Cursor{}, false, corruptf("page %d has unknown node type %d", child.pageNum, child.Type())
 }
 
-func (t *Tree) internalInsert(n *Node, key uint32, rightChildPageNum uint32) {
-	// If node is already full, need to call internalSplitAndInsert
-	numKeys := n.NumKeys()
-	if numKeys >= InternalNodeMaxCells {
-		t.internalSplitAndInsert(n, key, rightChildPageNum)
-		return
+// maxKey returns the largest key under a node
+func (t *Tree) maxKey(n *Node) (uint64, error) {
+	for n.Type() == InternalNode {
+		var err error
+		n, err = t.node(n.RightChild())
+		if err != nil {
+			return 0, err
+		}
 	}
+	return n.GetMaxKey()
+}
 
-	// Find where it should go
-	minIndex, maxIndex := uint16(0), numKeys
-	for minIndex != maxIndex {
-		index := (minIndex + maxIndex) / 2
-
-		keyToRight := n.InternalKey(index)
-		if keyToRight >= key {
-			maxIndex = index
-		} else {
-			minIndex += 1
-		}
+// internalInsert adds a cell pointing at childPageNum, with key as the largest key the child can
+// hold, to the internal node n
+func (t *Tree) internalInsert(n *Node, key uint64, childPageNum uint32) error {
+	// If node is already full, need to call internalSplitAndInsert
+	numKeys := n.NumKeys()
+	if numKeys >= InternalNodeMaxCells(n.pageSize()) {
+		return t.internalSplitAndInsert(n, key, childPageNum)
 	}
 
 	// Find position of first key larger than it.
 	// Shuffle all keys and child pointers from that position one to the right
 	// Add new key and child pointer
+	index := n.internalKeyIndex(key)
 	n.SetNumKeys(numKeys + 1)
 	// If not at the end, move cells over to make room
-	for idx := numKeys; idx > minIndex; idx-- {
-		n.SetInternalKey(idx, n.InternalKey(idx-1))
-		n.SetChildPointer(idx, n.ChildPointer(idx-1))
+	for idx := numKeys; idx > index; idx-- {
+		n.moveInternalCell(idx-1, idx)
 	}
-	n.SetInternalKey(minIndex, key)
-
-	n.SetChildPointer(minIndex, rightChildPageNum)
+	n.SetInternalKey(index, key)
+	n.SetChildPointer(index, childPageNum)
+	return nil
+}
 
+/*
+insertSplitChild records in parent that its child leftPageNum has been split, keeping keys up to and
+including leftMaxKey, and that the rest of its keys are now in rightPageNum. The right node takes
+over the left node's slot in the parent, and the left node is inserted in front of it.
+*/
+func (t *Tree) insertSplitChild(parent *Node, leftPageNum uint32, leftMaxKey uint64, rightPageNum uint32) error {
+	parent.SetChildPointer(parent.internalKeyIndex(leftMaxKey), rightPageNum)
+	return t.internalInsert(parent, leftMaxKey, leftPageNum)
 }
 
-func (t *Tree) internalSplitAndInsert(n *Node, key uint32, rightChildPageNum uint32) {
-	nextPageNum := t.pager.GetNextUnusedPageNum()
-	newPage, _ := t.pager.Page(nextPageNum) // TODO handle error
-	newInternal := NewInternal(newPage)
+func (t *Tree) internalSplitAndInsert(n *Node, key uint64, childPageNum uint32) error {
+	newPageNum, err := t.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	newInternal, err := t.node(newPageNum)
+	if err != nil {
+		return err
+	}
+	NewInternal(newInternal.page)
 
-	// Find where the key should go
+	// Lay out every cell, including the new one, then share them out between the two nodes
 	numKeys := n.NumKeys()
-	minIndex, maxIndex := uint16(0), numKeys
-	for minIndex != maxIndex {
-		index := (minIndex + maxIndex) / 2
-
-		keyToRight := n.InternalKey(index)
-		if keyToRight >= key {
-			maxIndex = index
-		} else {
-			minIndex += 1
+	index := n.internalKeyIndex(key)
+	keys := make([]uint64, 0, numKeys+1)
+	children := make([]uint32, 0, numKeys+1)
+	for i := uint16(0); i < numKeys; i++ {
+		if i == index {
+			keys = append(keys, key)
+			children = append(children, childPageNum)
 		}
+		keys = append(keys, n.InternalKey(i))
+		children = append(children, n.ChildPointer(i))
 	}
+	if index == numKeys {
+		keys = append(keys, key)
+		children = append(children, childPageNum)
+	}
+	rightChild := n.RightChild()
+
+	// The left node keeps the first cells, with the child of the next cell as its right child. The
+	// key of that cell is the largest key in the left node, and is passed up to the parent.
+	leftCount := InternalNodeLeftSplitCount(n.pageSize()) - 1
+	n.SetNumKeys(leftCount)
+	for i := uint16(0); i < leftCount; i++ {
+		n.SetInternalKey(i, keys[i])
+		n.SetChildPointer(i, children[i])
+	}
+	n.SetRightChild(children[leftCount])
+	leftMaxKey := keys[leftCount]
+
+	rightCount := uint16(len(keys)) - leftCount - 1
+	newInternal.SetNumKeys(rightCount)
+	for i := uint16(0); i < rightCount; i++ {
+		newInternal.SetInternalKey(i, keys[leftCount+1+i])
+		newInternal.SetChildPointer(i, children[leftCount+1+i])
+	}
+	newInternal.SetRightChild(rightChild)
 
-	for i := int16(InternalNodeMaxCells); i >= 0; i-- {
-		index := uint16(i)
-		var destination *Node
-
-		if index >= InternalNodeLeftSplitCount {
-			destination = newInternal
-
-		} else {
-			destination = n
-
-		}
-
-		newIndex := index % InternalNodeLeftSplitCount
-		if index == minIndex {
-			destination.SetInternalKey(newIndex, key)
-			destination.SetChildPointer(newIndex, rightChildPageNum)
-		} else {
-			if index > minIndex {
-				destination.setInternalCell(newIndex, n.internalCell(index-1))
-			} else {
-				destination.setInternalCell(newIndex, n.internalCell(index))
-			}
-		}
+	// Update Parent
+	if t.isRoot(n) {
+		return t.splitRoot(leftMaxKey, newPageNum)
+	}
 
+	parentPageNum := n.ParentPointer()
+	newInternal.SetParentPointer(parentPageNum)
+	if err := t.setParentPointers(newInternal, newPageNum); err != nil {
+		return err
+	}
+	parent, err := t.dirtyNode(parentPageNum)
+	if err != nil {
+		return err
 	}
+	return t.insertSplitChild(parent, n.pageNum, leftMaxKey, newPageNum)
+}
 
-	n.SetNumKeys(InternalNodeLeftSplitCount - 1)
-	newInternal.SetNumKeys(numKeys + 1 - (InternalNodeLeftSplitCount - 1))
+// setParentPointers points every child of an internal node back at it, see setParent
+func (t *Tree) setParentPointers(n *Node, pageNum uint32) error {
+	children := make([]uint32, 0, n.NumKeys()+1)
+	for i := uint16(0); i <= n.NumKeys(); i++ {
+		children = append(children, n.ChildPointer(i))
+	}
+	return t.setParent(children, pageNum)
+}
 
-	// Update Parent
-	if n.IsRoot() {
-		t.CreateNewRoot(nextPageNum)
-	} else {
-		// Update parent
-		parentPage, _ := t.pager.Page(n.ParentPointer())
-		parent := Node{page: parentPage}
-		maxKey, _ := n.GetMaxKey()
-		t.internalInsert(&parent, maxKey, nextPageNum)
-		newInternal.SetParentPointer(n.ParentPointer())
+// setParent points each of children at the parent pageNum. Fetching the children may evict any node
+// fetched before, so callers do this last, or fetch again the nodes they still need afterwards.
+func (t *Tree) setParent(children []uint32, pageNum uint32) error {
+	for _, childPageNum := range children {
+		child, err := t.dirtyNode(childPageNum)
+		if err != nil {
+			return err
+		}
+		child.SetParentPointer(pageNum)
 	}
+	return nil
 }
 
 // leafNodeFind returns the position in the node the key should be in. The key may not actually be present
-func (t *Tree) leafNodeFind(n *Node, key uint32) (Cursor, bool) {
+func (t *Tree) leafNodeFind(n *Node, key uint64) (Cursor, bool) {
 	numCells := n.NumCells()
 	minIndex := uint16(0)
 	onePastMaxIndex := numCells
@@ -199,13 +272,12 @@ func (t *Tree) leafNodeFind(n *Node, key uint32) (Cursor, bool) {
 	return c, false
 }
 
-func (t *Tree) leafInsert(c Cursor, key uint32, data IndexItem) {
+func (t *Tree) leafInsert(c Cursor, key uint64, data IndexItem) error {
 	n := c.Node
 	// If leaf is already full, need to call leafSplitAndInsert
 	numCells := n.NumCells()
-	if numCells >= LeafNodeMaxCells {
-		t.leafSplitAndInsert(c, key, data)
-		return
+	if numCells >= LeafNodeMaxCells(n.pageSize()) {
+		return t.leafSplitAndInsert(c, key, data)
 	}
 
 	// Make space for new cell if it is not at the right hand end
@@ -219,24 +291,33 @@ func (t *Tree) leafInsert(c Cursor, key uint32, data IndexItem) {
 	n.SetNumCells(numCells + 1)
 	n.SetNodeKey(c.Index, key)
 	n.SetNodeValue(c.Index, data)
+	return nil
 }
 
-func (t *Tree) leafSplitAndInsert(c Cursor, key uint32, value IndexItem) {
-	nextPageNum := t.pager.GetNextUnusedPageNum()
-	newPage, _ := t.pager.Page(nextPageNum) // TODO handle error
+func (t *Tree) leafSplitAndInsert(c Cursor, key uint64, value IndexItem) error {
+	nextPageNum, err := t.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	newPage, err := t.pager.Page(nextPageNum)
+	if err != nil {
+		return err
+	}
 	newLeaf := NewLeaf(newPage)
 
 	// Divide cells between nodes
-	for i := int16(LeafNodeMaxCells); i >= 0; i-- {
+	pageSize := c.Node.pageSize()
+	leftSplitCount := LeafNodeLeftSplitCount(pageSize)
+	for i := int16(LeafNodeMaxCells(pageSize)); i >= 0; i-- {
 		index := uint16(i)
 		var destinationLeaf *Node
-		if index >= LeafNodeLeftSplitCount {
+		if index >= leftSplitCount {
 			destinationLeaf = newLeaf
 		} else {
 			destinationLeaf = c.Node
 		}
 
-		newIndex := index % LeafNodeLeftSplitCount
+		newIndex := index % leftSplitCount
 		if index == c.Index {
 			destinationLeaf.SetNodeKey(newIndex, key)
 			destinationLeaf.SetNodeValue(newIndex, value)
@@ -251,45 +332,153 @@ func (t *Tree) leafSplitAndInsert(c Cursor, key uint32, value IndexItem) {
 	}
 
 	// Update Number of cells
-	c.Node.SetNumCells(LeafNodeLeftSplitCount)
-	newLeaf.SetNumCells(LeafNodeRightSplitCount)
+	c.Node.SetNumCells(leftSplitCount)
+	newLeaf.SetNumCells(LeafNodeRightSplitCount(pageSize))
+
+	// The new leaf comes straight after the old one
+	newLeaf.SetNextLeaf(c.Node.GetNextLeaf())
+	c.Node.SetNextLeaf(nextPageNum)
 
 	// Update Parent
-	if c.Node.IsRoot() {
-		t.CreateNewRoot(nextPageNum)
-	} else {
-		// Update parent
-		parentPage, _ := t.pager.Page(c.Node.ParentPointer())
-		parent := Node{page: parentPage}
-		maxKey, _ := c.Node.GetMaxKey()
-		t.internalInsert(&parent, maxKey, nextPageNum)
-		newLeaf.SetParentPointer(c.Node.ParentPointer())
+	if t.isRoot(c.Node) {
+		return t.CreateNewRoot(nextPageNum)
 	}
-}
 
-func (t *Tree) CreateNewRoot(rightChildPageNum uint32) {
-	currentRootPage, _ := t.pager.Page(t.rootPageNum)
+	parentPageNum := c.Node.ParentPointer()
+	newLeaf.SetParentPointer(parentPageNum)
+	parent, err := t.dirtyNode(parentPageNum)
+	if err != nil {
+		return err
+	}
+	maxKey, err := c.Node.GetMaxKey()
+	if err != nil {
+		return err
+	}
+	return t.insertSplitChild(parent, c.Node.pageNum, maxKey, nextPageNum)
+}
 
-	leftChildPageNum := t.pager.GetNextUnusedPageNum()
+// CreateNewRoot splits the root, moving its contents to a new left child and making rightChildPageNum
+// the right child, so the root page never moves
+func (t *Tree) CreateNewRoot(rightChildPageNum uint32) error {
+	root, err := t.node(t.rootPageNum)
+	if err != nil {
+		return err
+	}
+	leftChildMaxKey, err := t.maxKey(root)
+	if err != nil {
+		return err
+	}
+	return t.splitRoot(leftChildMaxKey, rightChildPageNum)
+}
 
-	leftChildPage, _ := t.pager.Page(leftChildPageNum)
+// splitRoot does the work of CreateNewRoot when the largest key staying on the left is already known
+func (t *Tree) splitRoot(leftChildMaxKey uint64, rightChildPageNum uint32) error {
+	currentRoot, err := t.dirtyNode(t.rootPageNum)
+	if err != nil {
+		return err
+	}
 
-	// TODO Deal with err
+	leftChildPageNum, err := t.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	leftChild, err := t.node(leftChildPageNum)
+	if err != nil {
+		return err
+	}
+	rightChild, err := t.dirtyNode(rightChildPageNum)
+	if err != nil {
+		return err
+	}
 
-	copy(*leftChildPage, *currentRootPage)
-	leftChild := Node{page: leftChildPage}
+	copy(*leftChild.page, *currentRoot.page)
 	leftChild.SetIsRoot(false)
 	leftChild.SetParentPointer(t.rootPageNum)
+	rightChild.SetParentPointer(t.rootPageNum)
 
-	root := NewInternal(currentRootPage)
+	root := NewInternal(currentRoot.page)
 	root.SetIsRoot(true)
 	root.SetNumKeys(1)
 	root.SetChildPointer(0, leftChildPageNum)
-	leftChildMaxKey, _ := leftChild.GetMaxKey()
 	root.SetInternalKey(0, leftChildMaxKey)
 	root.SetChildPointer(1, rightChildPageNum)
 
-	rightChildPage, _ := t.pager.Page(rightChildPageNum)
-	rightChild := Node{page: rightChildPage}
-	rightChild.SetParentPointer(t.rootPageNum)
+	if leftChild.Type() == InternalNode {
+		if err := t.setParentPointers(leftChild, leftChildPageNum); err != nil {
+			return err
+		}
+		rightChild, err = t.node(rightChildPageNum)
+		if err != nil {
+			return err
+		}
+		return t.setParentPointers(rightChild, rightChildPageNum)
+	}
+	return nil
+}
+
+// Pages returns the page numbers of every node in the tree
+func (t *Tree) Pages() ([]uint32, error) {
+	pages := []uint32{}
+	toVisit := []uint32{t.rootPageNum}
+	for len(toVisit) > 0 {
+		pageNum := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		pages = append(pages, pageNum)
+
+		n, err := t.node(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Type() {
+		case LeafNode:
+		case InternalNode:
+			for i := uint16(0); i <= n.NumKeys(); i++ {
+				toVisit = append(toVisit, n.ChildPointer(i))
+			}
+		default:
+			return nil, corruptf("page %d has unknown node type %d", pageNum, n.Type())
+		}
+	}
+	return pages, nil
+}
+
+// Walk calls fn with every key and item in the tree, in key order, stopping at the first error
+func (t *Tree) Walk(fn func(key uint64, item IndexItem) error) error {
+	return t.walk(t.rootPageNum, fn)
+}
+
+func (t *Tree) walk(pageNum uint32, fn func(key uint64, item IndexItem) error) error {
+	n, err := t.node(pageNum)
+	if err != nil {
+		return err
+	}
+
+	// The node is read up front, as fn or walking the children may evict its page
+	switch n.Type() {
+	case LeafNode:
+		numCells := n.NumCells()
+		keys := make([]uint64, numCells)
+		items := make([]IndexItem, numCells)
+		for i := uint16(0); i < numCells; i++ {
+			keys[i], items[i] = n.GetNodeKey(i), n.GetNodeValue(i)
+		}
+		for i := range keys {
+			if err := fn(keys[i], items[i]); err != nil {
+				return err
+			}
+		}
+	case InternalNode:
+		children := make([]uint32, n.NumKeys()+1)
+		for i := range children {
+			children[i] = n.ChildPointer(uint16(i))
+		}
+		for _, child := range children {
+			if err := t.walk(child, fn); err != nil {
+				return err
+			}
+		}
+	default:
+		return corruptf("page %d has unknown node type %d", pageNum, n.Type())
+	}
+	return nil
 }
@@ -1,9 +1,13 @@
 package data
 
-import "testing"
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
 
 func TestNewRoot(t *testing.T) {
-	page := Page(make([]byte, PageSize))
+	page := Page(make([]byte, DefaultPageSize))
 	node := NewInternal(&page)
 	node.SetChildPointer(0, 0)
 	node.SetNumKeys(1)
@@ -18,7 +22,7 @@ func TestNewRoot(t *testing.T) {
 }
 
 func TestNewInternal(t *testing.T) {
-	page := Page(make([]byte, PageSize))
+	page := Page(make([]byte, DefaultPageSize))
 
 	node := NewInternal(&page)
 
@@ -36,7 +40,7 @@ func TestNewInternal(t *testing.T) {
 }
 
 func TestInternalInsertAtEnd(t *testing.T) {
-	page := Page(make([]byte, PageSize))
+	page := Page(make([]byte, DefaultPageSize))
 
 	node := NewInternal(&page)
 
@@ -46,25 +50,25 @@ func TestInternalInsertAtEnd(t *testing.T) {
 	node.SetChildPointer(0, 0)
 	node.SetNumKeys(1)
 
-	for i := uint32(1); i < uint32(InternalNodeMaxCells); i++ {
-		node.SetInternalKey(uint16(i), i)
+	for i := uint32(1); i < uint32(InternalNodeMaxCells(DefaultPageSize)); i++ {
+		node.SetInternalKey(uint16(i), uint64(i))
 		node.SetChildPointer(uint16(i), i)
 		node.SetNumKeys(uint16(i + 1))
 	}
 
-	node.SetChildPointer(InternalNodeMaxCells, uint32(InternalNodeMaxCells))
-	if node.RightChild() != uint32(InternalNodeMaxCells) {
-		t.Errorf("unexpected value for right child, expected %d, got %d", uint32(InternalNodeMaxCells), node.RightChild())
+	node.SetChildPointer(InternalNodeMaxCells(DefaultPageSize), uint32(InternalNodeMaxCells(DefaultPageSize)))
+	if node.RightChild() != uint32(InternalNodeMaxCells(DefaultPageSize)) {
+		t.Errorf("unexpected value for right child, expected %d, got %d", uint32(InternalNodeMaxCells(DefaultPageSize)), node.RightChild())
 	}
 
 }
 
 func TestInternalInsertAtBeginning(t *testing.T) {
-	page := Page(make([]byte, PageSize))
+	page := Page(make([]byte, DefaultPageSize))
 
 	node := NewInternal(&page)
-	// Num Keys = 1, Right Child = 2, cell0: key=16, child=1
-	copy(page[6:20], []byte{0x1, 0x0, 0x2, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x9, 0x0, 0x0, 0x0})
+	// Num Keys = 1, Right Child = 2, cell0: key=9, child=1
+	copy(page[6:24], []byte{0x1, 0x0, 0x2, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x9, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0})
 
 	tree := Tree{}
 	tree.rootPageNum = 0
@@ -81,11 +85,11 @@ func TestInternalInsertAtBeginning(t *testing.T) {
 }
 
 func TestInternalInsertInMiddle(t *testing.T) {
-	page := Page(make([]byte, PageSize))
+	page := Page(make([]byte, DefaultPageSize))
 
 	node := NewInternal(&page)
 	// Num Keys = 2, Right Child = 3, cell0: key=9, child=1, cell1: key=16, child=2
-	copy(page[6:28], []byte{0x2, 0x0, 0x3, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x9, 0x0, 0x0, 0x0, 0x2, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x0})
+	copy(page[6:36], []byte{0x2, 0x0, 0x3, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x9, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0})
 
 	tree := Tree{}
 	tree.rootPageNum = 0
@@ -123,9 +127,9 @@ func TestSplitInternal(t *testing.T) {
 	root := NewInternal(rootPage)
 	root.SetIsRoot(true)
 
-	for i := uint16(1); i <= InternalNodeMaxCells; i++ {
+	for i := uint16(1); i <= InternalNodeMaxCells(DefaultPageSize); i++ {
 		p.Page(uint32(i))
-		root.SetInternalKey(i-1, uint32(i))
+		root.SetInternalKey(i-1, uint64(i))
 		root.SetNumKeys(i)
 		root.SetChildPointer(i-1, uint32(i))
 	}
@@ -133,23 +137,18 @@ func TestSplitInternal(t *testing.T) {
 	if root.RightChild() != uint32(0) {
 		t.Errorf("unexpected value for root.RightChild, expected %d, got %d", uint32(0), root.RightChild())
 	}
-	root.SetNumKeys(InternalNodeMaxCells)
-	p.Page(uint32(InternalNodeMaxCells + 1))
-	root.SetChildPointer(InternalNodeMaxCells, uint32(InternalNodeMaxCells+1))
+	root.SetNumKeys(InternalNodeMaxCells(DefaultPageSize))
+	p.Page(uint32(InternalNodeMaxCells(DefaultPageSize) + 1))
+	root.SetChildPointer(InternalNodeMaxCells(DefaultPageSize), uint32(InternalNodeMaxCells(DefaultPageSize)+1))
 
 	expectedRightPageNum := p.GetNextUnusedPageNum()
 	expectedLeftPageNum := expectedRightPageNum + 1
 
-	// Everything in indexes greater than or equal to InternalNodeLeftSplitCount should be in right node.
-	// So the expected ket will be the max key in Left Node, which should be the key in
-	// InternalNodeLeftSplitCount-1
-	//expectedMaxLeftKey := root.InternalKey(InternalNodeLeftSplitCount - 1)
-
-	if root.RightChild() != uint32(InternalNodeMaxCells+1) {
-		t.Errorf("unexpected value for root.RightCHild, expected %d, got %d", uint32(InternalNodeMaxCells+1), root.RightChild())
+	if root.RightChild() != uint32(InternalNodeMaxCells(DefaultPageSize)+1) {
+		t.Errorf("unexpected value for root.RightCHild, expected %d, got %d", uint32(InternalNodeMaxCells(DefaultPageSize)+1), root.RightChild())
 	}
 
-	tree.internalSplitAndInsert(root, uint32(InternalNodeMaxCells+1), uint32(InternalNodeMaxCells+2))
+	tree.internalSplitAndInsert(root, uint64(InternalNodeMaxCells(DefaultPageSize)+1), uint32(InternalNodeMaxCells(DefaultPageSize)+2))
 
 	if tree.rootPageNum != 0 {
 		t.Errorf("unexpected value for tree.rootPageNum, expected %d, got %d", 0, tree.rootPageNum)
@@ -162,11 +161,12 @@ func TestSplitInternal(t *testing.T) {
 		t.Errorf("unexpected value for root.RightChild(), expected %d, got %d", expectedRightPageNum, root.ChildPointer(1))
 	}
 
+	// Everything in indexes greater than or equal to InternalNodeLeftSplitCount(DefaultPageSize) should be in right node
 	leftPage, _ := tree.pager.Page(expectedLeftPageNum)
 	leftNode := Node{page: leftPage}
 
 	for i := uint16(0); i <= 3; i++ {
-		if leftNode.InternalKey(i) != uint32(i+1) {
+		if leftNode.InternalKey(i) != uint64(i+1) {
 			t.Errorf("unexpected value for leftNode.InternalKey(), expected %d, got %d", i+1, leftNode.InternalKey(i))
 		}
 	}
@@ -174,9 +174,9 @@ func TestSplitInternal(t *testing.T) {
 	rightNode := Node{page: rightPage}
 
 	for i := uint16(4); i < 7; i++ {
-		actualIndex := i % InternalNodeLeftSplitCount
-		if rightNode.InternalKey(actualIndex) != uint32(1+i+InternalNodeLeftSplitCount) {
-			t.Errorf("unexpected value for rightNode.InternalKey(), expected %d, got %d", 1+i+InternalNodeLeftSplitCount, rightNode.InternalKey(actualIndex))
+		actualIndex := i % InternalNodeLeftSplitCount(DefaultPageSize)
+		if rightNode.InternalKey(actualIndex) != uint64(1+i+InternalNodeLeftSplitCount(DefaultPageSize)) {
+			t.Errorf("unexpected value for rightNode.InternalKey(), expected %d, got %d", 1+i+InternalNodeLeftSplitCount(DefaultPageSize), rightNode.InternalKey(actualIndex))
 		}
 	}
 
@@ -184,7 +184,7 @@ func TestSplitInternal(t *testing.T) {
 
 func TestLeafFind(t *testing.T) {
 	tests := []struct {
-		key              uint32
+		key              uint64
 		expectedPosition uint16
 		expectedFound    bool
 	}{
@@ -196,8 +196,8 @@ func TestLeafFind(t *testing.T) {
 		{4, 3, false}, // key is not present but would be in index 3 if it were inserted
 	}
 
-	page := Page(make([]byte, PageSize))
-	copy(page[0:], []byte{0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 2, 0, 0, 0, 5, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 3, 0, 0, 0, 8, 0, 0, 0, 0, 0, 9, 0, 0, 0, 10, 0, 5, 0, 0, 0, 11, 0, 0, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	page := Page(make([]byte, DefaultPageSize))
+	copy(page[0:], []byte{0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0, 0, 9, 0, 0, 0, 10, 0, 5, 0, 0, 0, 0, 0, 0, 0, 11, 0, 0, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0})
 	leaf := NewNode(&page)
 	tree := Tree{}
 	for _, test := range tests {
@@ -213,19 +213,19 @@ func TestLeafFind(t *testing.T) {
 }
 
 func TestLeafInsert(t *testing.T) {
-	page := Page(make([]byte, PageSize))
+	page := Page(make([]byte, DefaultPageSize))
 	leaf := NewNode(&page)
 	leaf.SetType(LeafNode)
 	tests := []struct {
 		cell          uint16
-		key           uint32
+		key           uint64
 		value         IndexItem
 		expectedBytes []byte
 	}{
-		{0, 1, IndexItem{1, 0, 2}, []byte{1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0}},
-		{1, 2, IndexItem{3, 0, 4}, []byte{2, 0, 0, 0, 3, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0}},
-		{2, 3, IndexItem{5, 0, 6}, []byte{3, 0, 0, 0, 5, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0}},
-		{3, 4, IndexItem{7, 0, 8}, []byte{4, 0, 0, 0, 7, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0, 0}},
+		{0, 1, IndexItem{1, 0, 2}, []byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0}},
+		{1, 2, IndexItem{3, 0, 4}, []byte{2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0}},
+		{2, 3, IndexItem{5, 0, 6}, []byte{3, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0}},
+		{3, 4, IndexItem{7, 0, 8}, []byte{4, 0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0, 0}},
 		//{4, 5, Record{9, 10}, []byte{5, 0, 0, 0, 9, 0, 0, 0, 10, 0, 0, 0}},
 	}
 
@@ -250,9 +250,9 @@ func TestLeafSplit(t *testing.T) {
 	tree.rootPageNum = 1
 	leaf := NewLeaf(leafPage)
 	leaf.SetIsRoot(true)
-	for i := uint32(0); i < uint32(LeafNodeMaxCells)+1; i++ {
+	for i := uint64(0); i < uint64(LeafNodeMaxCells(DefaultPageSize))+1; i++ {
 		c, _ := tree.leafNodeFind(leaf, i)
-		tree.leafInsert(c, i, IndexItem{i, 0, i})
+		tree.leafInsert(c, i, IndexItem{uint32(i), 0, uint32(i)})
 	}
 
 	if tree.pager.GetNextUnusedPageNum() != 4 {
@@ -274,9 +274,9 @@ func TestLeafSplit(t *testing.T) {
 		t.Errorf("unexpected number of keys in root node, expected %d, got %d", 1, root.NumKeys())
 	}
 
-	// Because we counted up from 0 and the LeafNodeLeftSplitCount = 171, the max key in left node should be 170
-	if root.InternalKey(0) != uint32(LeafNodeLeftSplitCount)-1 {
-		t.Errorf("unexpected value for key 0 in root node, expected %d, got %d", uint32(LeafNodeLeftSplitCount)-1, root.InternalKey(0))
+	// Because we counted up from 0, the max key in left node should be one less than LeafNodeLeftSplitCount(DefaultPageSize)
+	if root.InternalKey(0) != uint64(LeafNodeLeftSplitCount(DefaultPageSize))-1 {
+		t.Errorf("unexpected value for key 0 in root node, expected %d, got %d", uint64(LeafNodeLeftSplitCount(DefaultPageSize))-1, root.InternalKey(0))
 	}
 
 	leftNodePageNum := root.ChildPointer(0)
@@ -287,16 +287,16 @@ func TestLeafSplit(t *testing.T) {
 		t.Errorf("unexpected left child page num, expected %d, got %d", 3, leftNodePageNum)
 	}
 
-	if leftNode.NumCells() != LeafNodeLeftSplitCount {
-		t.Errorf("unexpected number of cells in left child, expected %d, got %d", LeafNodeLeftSplitCount, leftNode.NumCells())
+	if leftNode.NumCells() != LeafNodeLeftSplitCount(DefaultPageSize) {
+		t.Errorf("unexpected number of cells in left child, expected %d, got %d", LeafNodeLeftSplitCount(DefaultPageSize), leftNode.NumCells())
 	}
 
 	leftNodeMaxKey, _ := leftNode.GetMaxKey()
-	if leftNodeMaxKey != uint32(LeafNodeLeftSplitCount)-1 {
-		t.Errorf("unexpected value for leftNode.GetMaxKey, expected %d, got %d", uint32(LeafNodeLeftSplitCount)-1, leftNodeMaxKey)
+	if leftNodeMaxKey != uint64(LeafNodeLeftSplitCount(DefaultPageSize))-1 {
+		t.Errorf("unexpected value for leftNode.GetMaxKey, expected %d, got %d", uint64(LeafNodeLeftSplitCount(DefaultPageSize))-1, leftNodeMaxKey)
 	}
-	if leftNode.GetNodeKey(LeafNodeLeftSplitCount-1) != uint32(LeafNodeLeftSplitCount)-1 {
-		t.Errorf("unexpected value for leftNode.cell[170], expected %d, got %d", uint32(LeafNodeLeftSplitCount)-1, leftNode.GetNodeKey(LeafNodeLeftSplitCount))
+	if leftNode.GetNodeKey(LeafNodeLeftSplitCount(DefaultPageSize)-1) != uint64(LeafNodeLeftSplitCount(DefaultPageSize))-1 {
+		t.Errorf("unexpected value for last leftNode.cell key, expected %d, got %d", uint64(LeafNodeLeftSplitCount(DefaultPageSize))-1, leftNode.GetNodeKey(LeafNodeLeftSplitCount(DefaultPageSize)-1))
 	}
 
 	if leftNode.ParentPointer() != 1 {
@@ -310,19 +310,19 @@ func TestLeafSplit(t *testing.T) {
 	rightPage, _ := tree.pager.Page(rightNodePageNum)
 	rightNode := Node{page: rightPage}
 
-	if rightNode.NumCells() != LeafNodeRightSplitCount {
-		t.Errorf("unexpected number of cells in right child, expected %d, got %d", LeafNodeRightSplitCount, rightNode.NumCells())
+	if rightNode.NumCells() != LeafNodeRightSplitCount(DefaultPageSize) {
+		t.Errorf("unexpected number of cells in right child, expected %d, got %d", LeafNodeRightSplitCount(DefaultPageSize), rightNode.NumCells())
 	}
 
 	rightNodeMaxKey, _ := rightNode.GetMaxKey()
-	if rightNodeMaxKey != uint32(LeafNodeMaxCells) {
-		t.Errorf("unexpected value for leftNode.GetMaxKey, expected %d, got %d", uint32(LeafNodeMaxCells), rightNodeMaxKey)
+	if rightNodeMaxKey != uint64(LeafNodeMaxCells(DefaultPageSize)) {
+		t.Errorf("unexpected value for rightNode.GetMaxKey, expected %d, got %d", uint64(LeafNodeMaxCells(DefaultPageSize)), rightNodeMaxKey)
 	}
-	if rightNode.GetNodeKey(LeafNodeRightSplitCount-1) != uint32(LeafNodeMaxCells) {
-		t.Errorf("unexpected value for last rightNode.cell key, expected %d, got %d", LeafNodeMaxCells, rightNode.GetNodeKey(LeafNodeRightSplitCount-1))
+	if rightNode.GetNodeKey(LeafNodeRightSplitCount(DefaultPageSize)-1) != uint64(LeafNodeMaxCells(DefaultPageSize)) {
+		t.Errorf("unexpected value for last rightNode.cell key, expected %d, got %d", LeafNodeMaxCells(DefaultPageSize), rightNode.GetNodeKey(LeafNodeRightSplitCount(DefaultPageSize)-1))
 	}
-	if rightNode.GetNodeKey(0) != uint32(LeafNodeLeftSplitCount) {
-		t.Errorf("unexpected value for rightNode.cell[0], expected %d, got %d", uint32(LeafNodeRightSplitCount), rightNode.GetNodeKey(0))
+	if rightNode.GetNodeKey(0) != uint64(LeafNodeLeftSplitCount(DefaultPageSize)) {
+		t.Errorf("unexpected value for rightNode.cell[0], expected %d, got %d", uint64(LeafNodeLeftSplitCount(DefaultPageSize)), rightNode.GetNodeKey(0))
 	}
 	if rightNode.ParentPointer() != 1 {
 		t.Errorf("unexpected value for right node's parent, expected %d, got %d", 1, rightNode.ParentPointer())
@@ -387,11 +387,107 @@ func TestInsertLeaf(t *testing.T) {
 		t.Errorf("unexpected number of cells in node after insert, expected %d, got %d", 1, node.NumCells())
 	}
 
-	for i := uint32(2); i < uint32(LeafNodeMaxCells)*2; i++ {
-		tree.Insert(i, IndexItem{1 + 1, 0, i + 2})
+	for i := uint64(2); i < uint64(LeafNodeMaxCells(DefaultPageSize))*2; i++ {
+		tree.Insert(i, IndexItem{1 + 1, 0, uint32(i) + 2})
 	}
 
 	if node.Type() != InternalNode {
 		t.Errorf("unexpected node type for root after inserting too many records, expected %s, got %s", InternalNode, LeafNode)
 	}
 }
+
+func TestInsertWithSmallPages(t *testing.T) {
+	pager := NewMemoryPager(MinPageSize)
+	pager.Page(0)
+	rootPage, _ := pager.Page(1)
+	NewLeaf(rootPage).SetIsRoot(true)
+	tree := NewTree(pager, 1)
+
+	numKeys := uint64(LeafNodeMaxCells(MinPageSize)) + 1
+	for i := uint64(0); i < numKeys; i++ {
+		tree.Insert(i, IndexItem{uint32(i), 0, uint32(i)})
+	}
+
+	root := Node{page: rootPage}
+	if root.Type() != InternalNode {
+		t.Errorf("unexpected node type for root, expected %s, got %s", InternalNode, root.Type())
+	}
+	for i := uint64(0); i < numKeys; i++ {
+		if item, _ := tree.Get(i); uint64(item.Length) != i {
+			t.Errorf("unexpected value for key %d, expected %d, got %d", i, i, item.Length)
+		}
+	}
+}
+
+// newTestTree returns a tree with an empty root leaf on page 1 of pager
+func newTestTree(pager Pager) *Tree {
+	pager.Page(0)
+	rootPage, _ := pager.Page(1)
+	pager.MarkDirty(1)
+	NewLeaf(rootPage).SetIsRoot(true)
+	return NewTree(pager, 1)
+}
+
+func TestInsertManyKeys(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	filePager, _ := OpenFilePager(filename, Options{PageSize: MinPageSize, CacheSize: MinCacheSize})
+	defer filePager.Close()
+
+	tests := []struct {
+		name    string
+		pager   Pager
+		numKeys uint64
+		order   func(i uint64) uint64
+	}{
+		{"ascending", &MemoryPager{}, 100000, func(i uint64) uint64 { return i }},
+		{"descending", NewMemoryPager(MinPageSize), 20000, func(i uint64) uint64 { return 20000 - i }},
+		{"shuffled", NewMemoryPager(MinPageSize), 20000, func(i uint64) uint64 { return (i * 7919) % 20000 }},
+		{"small cache", filePager, 20000, func(i uint64) uint64 { return (i * 7919) % 20000 }},
+	}
+
+	for _, test := range tests {
+		tree := newTestTree(test.pager)
+		for i := uint64(0); i < test.numKeys; i++ {
+			key := test.order(i)
+			if err := tree.Insert(key, IndexItem{uint32(key), 0, uint32(key) + 1}); err != nil {
+				t.Fatalf("%s: unexpected error inserting %d, got %+v", test.name, key, err)
+			}
+		}
+
+		for i := uint64(0); i < test.numKeys; i++ {
+			key := test.order(i)
+			item, err := tree.Get(key)
+			if err != nil || uint64(item.Length) != key+1 {
+				t.Errorf("%s: incorrect value for key %d, got %+v, %+v", test.name, key, item, err)
+				break
+			}
+		}
+
+		if _, err := tree.Get(test.numKeys + 1); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: expected %v, got %+v", test.name, ErrKeyNotFound, err)
+		}
+		checkParentPointers(t, test.name, tree, tree.rootPageNum)
+		if problems := tree.Check(nil); len(problems) != 0 {
+			t.Errorf("%s: unexpected problems, got %+v", test.name, problems)
+		}
+	}
+}
+
+// checkParentPointers checks every node under pageNum points back at its parent
+func checkParentPointers(t *testing.T, name string, tree *Tree, pageNum uint32) {
+	n, _ := tree.node(pageNum)
+	if n.Type() != InternalNode {
+		return
+	}
+	children := []uint32{}
+	for i := uint16(0); i <= n.NumKeys(); i++ {
+		children = append(children, n.ChildPointer(i))
+	}
+	for _, childPageNum := range children {
+		child, _ := tree.node(childPageNum)
+		if child.ParentPointer() != pageNum {
+			t.Errorf("%s: incorrect parent for page %d, expected %d, got %d", name, childPageNum, pageNum, child.ParentPointer())
+		}
+		checkParentPointers(t, name, tree, childPageNum)
+	}
+}
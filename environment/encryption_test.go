@@ -0,0 +1,80 @@
+package environment
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+)
+
+func TestEncryptedEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.db")
+	key := bytes.Repeat([]byte{0x1}, 32)
+	env, err := Open(filename, data.Options{Key: key})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if err := env.Initialise(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	stream, _ := env.GetStream()
+	for i := 0; i < 50; i++ {
+		stream.Add(bytes.Repeat([]byte{byte(i)}, i*100))
+	}
+	if err := env.Vacuum(); err != nil {
+		t.Errorf("unexpected error vacuuming, got %+v", err)
+	}
+	backupFile := filepath.Join(dir, "backup.db")
+	if err := env.Backup(backupFile); err != nil {
+		t.Fatalf("unexpected error backing up, got %+v", err)
+	}
+	newKey := bytes.Repeat([]byte{0x2}, 32)
+	if err := env.Rekey(newKey); err != nil {
+		t.Fatalf("unexpected error rekeying, got %+v", err)
+	}
+	env.Pager().Close()
+
+	if _, err := Open(filename, data.Options{}); !errors.Is(err, data.ErrEncrypted) {
+		t.Errorf("expected %v opening without a key, got %+v", data.ErrEncrypted, err)
+	}
+	if _, err := Open(filename, data.Options{Key: key}); !errors.Is(err, data.ErrWrongKey) {
+		t.Errorf("expected %v opening with the old key, got %+v", data.ErrWrongKey, err)
+	}
+
+	// The backup was taken before rekeying, so is still under the old key
+	for _, db := range []struct {
+		filename string
+		key      []byte
+	}{{filename, newKey}, {backupFile, key}} {
+		env, err := Open(db.filename, data.Options{Key: db.key})
+		if err != nil {
+			t.Fatalf("%s: unexpected error, got %+v", db.filename, err)
+		}
+		if problems := env.Check(); len(problems) != 0 {
+			t.Errorf("%s: unexpected problems, got %+v", db.filename, problems)
+		}
+		stream, _ := env.GetStream()
+		for i := 0; i < 50; i++ {
+			record, err := stream.Get(uint64(i))
+			if err != nil || !bytes.Equal(record.Data, bytes.Repeat([]byte{byte(i)}, i*100)) {
+				t.Errorf("%s: incorrect record for key %d, got %+v", db.filename, i, err)
+			}
+		}
+		env.Pager().Close()
+	}
+}
+
+func TestRekeyUnencrypted(t *testing.T) {
+	env, err := Open(filepath.Join(t.TempDir(), "test.db"), data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer env.Pager().Close()
+	env.Initialise()
+	if err := env.Rekey(bytes.Repeat([]byte{0x1}, 32)); !errors.Is(err, data.ErrNotEncrypted) {
+		t.Errorf("expected %v, got %+v", data.ErrNotEncrypted, err)
+	}
+}
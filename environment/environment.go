@@ -2,13 +2,21 @@ package environment
 
 import (
 	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
 	"unsafe"
 
 	"github.com/gilmae/klite/data"
 	"github.com/gilmae/klite/store"
 )
 
-var VERSION = []uint8{0, 9, 1}
+// VERSION is the format version of the databases this version of klite writes. Databases with an
+// older version are upgraded when they are opened, see Upgrade.
+var VERSION = []uint8{0, 15, 0}
+
+// BackupStepSize is the number of pages Backup copies at a time
+const BackupStepSize = 256
 
 const (
 	RootPage              = uint32(0)
@@ -22,13 +30,120 @@ const (
 	VersionRevisionSize   = uint16(unsafe.Sizeof(uint8(0)))
 	StreamPageOffset      = VersionRevisionOffset + VersionRevisionSize
 	StreamPageSize        = uint16(unsafe.Sizeof(uint32(0)))
+	FreeListOffset        = StreamPageOffset + StreamPageSize
+	PageSizeOffset        = FreeListOffset + data.FreeListHeaderSize
+	PageSizeSize          = uint16(unsafe.Sizeof(uint32(0)))
 
-	StoreHeaderSize = StreamPageOffset + StreamPageSize
+	StoreHeaderSize = PageSizeOffset + PageSizeSize
 )
 
 type Environment struct {
 	pager data.Pager
 	page  *data.Page
+	// filename and opts are only known for environments opened with Open
+	filename string
+	opts     data.Options
+	// keysWidened is set once an upgrade has copied the stream of a database from before version
+	// 0.15.0 into the current layout, so the upgrades after it do not read the stream as narrow
+	keysWidened bool
+}
+
+// Open opens the database in filename. The page size in opts is only used if the database is being
+// created, an existing database is always opened with the page size recorded in its root page.
+//
+// A database from an older version is upgraded, unless it is opened read-only, after being copied
+// to filename.VERSION.bak.
+//
+// An encrypted database must be opened with the key it was created with in opts.
+func Open(filename string, opts data.Options) (*Environment, error) {
+	header, err := readFileHeader(filename)
+	if err != nil {
+		return nil, err
+	}
+	if header.encrypted && opts.Key == nil {
+		return nil, data.ErrEncrypted
+	}
+	if header.pageSize != 0 {
+		opts.PageSize = header.pageSize
+	}
+	if header.version != nil && compareVersions(header.version, checksumVersion) < 0 {
+		// The pages have no checksums to check until the database has been upgraded
+		opts.SkipChecksums = true
+	}
+
+	pager, err := data.Open(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	// A reader does not hold the database locked once it is open, see BeginRead
+	endRead, err := data.BeginRead(pager)
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
+	defer endRead()
+	env, err := NewEnvironment(pager)
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
+
+	env.filename = filename
+	env.opts = opts
+
+	if !opts.ReadOnly && env.IsInitialised() {
+		backupFile := fmt.Sprintf("%s.%s.bak", filename, VersionString(env.Version()))
+		if _, err := env.Upgrade(UpgradeOptions{BackupFile: backupFile}); err != nil {
+			pager.Close()
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// fileHeader is what Open needs to know about a db file before it opens a pager on it
+type fileHeader struct {
+	pageSize int
+	// version is nil if it is not known, as the root page of an encrypted file cannot be read yet
+	version   []uint8
+	encrypted bool
+}
+
+// readFileHeader reads the root page header of a db file without going through a pager, as the pager
+// needs to know the page size, and whether the pages have checksums, before it can read any pages.
+// The header is empty if the file does not exist or has not been initialised. The page size of an
+// encrypted file is in its plaintext header instead.
+func readFileHeader(filename string) (fileHeader, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return fileHeader{}, nil
+	}
+	if err != nil {
+		return fileHeader{}, err
+	}
+	defer file.Close()
+
+	header := make([]byte, StoreHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return fileHeader{}, nil
+	}
+	if pageSize, encrypted := data.EncryptedPageSize(header); encrypted {
+		return fileHeader{pageSize: pageSize, encrypted: true}, nil
+	}
+	if string(header[IdentifierOffset:IdentifierOffset+IdentifierSize]) != "klite" {
+		return fileHeader{}, nil
+	}
+	version := []uint8{header[VersionMajorOffset], header[VersionMinorOffset], header[VersionRevisionOffset]}
+	return fileHeader{pageSize: pageSizeFromHeader(header), version: version}, nil
+}
+
+func pageSizeFromHeader(header []byte) int {
+	pageSize := int(binary.LittleEndian.Uint32(header[PageSizeOffset : PageSizeOffset+PageSizeSize]))
+	if pageSize == 0 {
+		// Databases from before the page size was configurable always used the default
+		return data.DefaultPageSize
+	}
+	return pageSize
 }
 
 func NewEnvironment(pager data.Pager) (*Environment, error) {
@@ -37,7 +152,25 @@ func NewEnvironment(pager data.Pager) (*Environment, error) {
 		return nil, err
 	}
 
-	return &Environment{pager: pager, page: rootPage}, nil
+	// Pages are allocated and freed through the free-list held in the root page
+	freeList := data.NewFreeListPager(pager, RootPage, FreeListOffset)
+	env := &Environment{pager: freeList, page: rootPage}
+	if env.IsInitialised() {
+		if err := checkVersion(env.Version()); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// markRootDirty fetches the root page again, as it may have been evicted, and marks it dirty
+func (e *Environment) markRootDirty() error {
+	rootPage, err := e.pager.Page(RootPage)
+	if err != nil {
+		return err
+	}
+	e.page = rootPage
+	return e.pager.MarkDirty(RootPage)
 }
 
 func (e *Environment) Initialise() error {
@@ -46,11 +179,21 @@ func (e *Environment) Initialise() error {
 	if err != nil {
 		return err
 	}
+	if err := e.pager.MarkDirty(RootPage); err != nil {
+		return err
+	}
 
 	copy((*rootPage)[IdentifierOffset:IdentifierOffset+IdentifierSize], []byte("klite"))
 
 	e.SetVersion(VERSION)
-	_, streamPageNum := store.InitialiseStream(e.pager)
+	e.setPageSize(len(*rootPage))
+	_, streamPageNum, err := store.InitialiseStream(e.pager)
+	if err != nil {
+		return err
+	}
+	if err := e.markRootDirty(); err != nil {
+		return err
+	}
 	e.SetStreamPage(streamPageNum)
 
 	return nil
@@ -75,6 +218,16 @@ func (e *Environment) SetVersion(version []uint8) {
 	(*e.page)[VersionRevisionOffset] = version[2]
 }
 
+// PageSize returns the size of the database's pages, fixed when it was initialised
+func (e *Environment) PageSize() int {
+	return pageSizeFromHeader(*e.page)
+}
+
+// setPageSize records the page size in the root page, which must already be marked dirty
+func (e *Environment) setPageSize(pageSize int) {
+	binary.LittleEndian.PutUint32((*e.page)[PageSizeOffset:PageSizeOffset+PageSizeSize], uint32(pageSize))
+}
+
 func (e *Environment) StreamPage() uint32 {
 	return binary.LittleEndian.Uint32((*e.page)[StreamPageOffset : StreamPageOffset+StreamPageSize])
 }
@@ -83,10 +236,89 @@ func (e *Environment) SetStreamPage(streamPage uint32) {
 	binary.LittleEndian.PutUint32((*e.page)[StreamPageOffset:StreamPageOffset+StreamPageSize], streamPage)
 }
 
-func (e *Environment) GetStream() *store.Stream {
+// GetStream returns the database's stream. The stream of a database from before version 0.15.0
+// cannot be read until the database has been upgraded.
+func (e *Environment) GetStream() (*store.Stream, error) {
+	if e.IsInitialised() && compareVersions(e.Version(), wideKeysVersion) < 0 {
+		return nil, fmt.Errorf("%w: version %s, open it read-write to upgrade it", ErrUpgradeRequired, VersionString(e.Version()))
+	}
 	return store.NewStream(e.pager, e.StreamPage())
 }
 
+// Check returns every problem found with the database, or nil if it is sound
+func (e *Environment) Check() []error {
+	if !e.IsInitialised() {
+		return []error{fmt.Errorf("database has not been initialised")}
+	}
+	stream, err := e.GetStream()
+	if err != nil {
+		return []error{err}
+	}
+	return stream.Check()
+}
+
+// Reindex builds the stream's index again from the items in its store
+func (e *Environment) Reindex() error {
+	stream, err := e.GetStream()
+	if err != nil {
+		return err
+	}
+	return stream.Reindex()
+}
+
+// Backup copies the database to a new file at filename, encrypted with the same key if the database is
+func (e *Environment) Backup(filename string) error {
+	return data.BackupToFile(e.pager, filename, e.opts, BackupStepSize)
+}
+
+// Rekey encrypts the database again with a new key, see data.EncryptedPager.Rekey
+func (e *Environment) Rekey(key []byte) error {
+	encrypted, ok := e.pager.(*data.FreeListPager).Pager.(*data.EncryptedPager)
+	if !ok {
+		return data.ErrNotEncrypted
+	}
+	if err := encrypted.Rekey(key); err != nil {
+		return err
+	}
+	e.opts.Key = key
+	return nil
+}
+
+// BeginRead starts a read transaction, see data.BeginRead, and returns a function that ends it. The
+// database may have changed since the last one, so a read-only environment must do its reading in one.
+func (e *Environment) BeginRead() (func(), error) {
+	endRead, err := data.BeginRead(e.pager)
+	if err != nil {
+		return nil, err
+	}
+	rootPage, err := e.pager.Page(RootPage)
+	if err != nil {
+		endRead()
+		return nil, err
+	}
+	e.page = rootPage
+	return endRead, nil
+}
+
+// Discard drops every change made since the database was last flushed, see data.Discard
+func (e *Environment) Discard() error {
+	if err := data.Discard(e.pager); err != nil {
+		return err
+	}
+	e.keysWidened = false
+	rootPage, err := e.pager.Page(RootPage)
+	if err != nil {
+		return err
+	}
+	e.page = rootPage
+	return nil
+}
+
+// Stats returns counts of the work the pager has done since the database was opened
+func (e *Environment) Stats() data.Stats {
+	return e.pager.Stats()
+}
+
 func (e *Environment) Pager() data.Pager {
 	return e.pager
 }
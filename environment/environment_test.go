@@ -0,0 +1,160 @@
+package environment
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+)
+
+func TestPageSizeRecordedAtCreation(t *testing.T) {
+	for _, pageSize := range []int{data.MinPageSize, data.DefaultPageSize, 16384, data.MaxPageSize} {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		env, err := Open(filename, data.Options{PageSize: pageSize})
+		if err != nil {
+			t.Fatalf("%d: unexpected error, got %+v", pageSize, err)
+		}
+		if err := env.Initialise(); err != nil {
+			t.Fatalf("%d: unexpected error, got %+v", pageSize, err)
+		}
+
+		payload := bytes.Repeat([]byte{0x7}, 3*pageSize)
+		stream, err := env.GetStream()
+		if err != nil {
+			t.Fatalf("%d: unexpected error, got %+v", pageSize, err)
+		}
+		key, err := stream.Add(payload)
+		if err != nil {
+			t.Errorf("%d: unexpected error, got %+v", pageSize, err)
+		}
+		env.Pager().Close()
+
+		// The page size in the file wins over the one asked for
+		env, err = Open(filename, data.Options{PageSize: 2 * pageSize})
+		if err != nil {
+			t.Fatalf("%d: unexpected error reopening, got %+v", pageSize, err)
+		}
+		if env.PageSize() != pageSize || env.Pager().PageSize() != pageSize {
+			t.Errorf("incorrect page size, expected %d, got %d and %d", pageSize, env.PageSize(), env.Pager().PageSize())
+		}
+
+		stream, err = env.GetStream()
+		if err != nil {
+			t.Fatalf("%d: unexpected error, got %+v", pageSize, err)
+		}
+		record, err := stream.Get(key)
+		if err != nil {
+			t.Errorf("%d: unexpected error, got %+v", pageSize, err)
+		} else if !bytes.Equal(record.Data, payload) {
+			t.Errorf("%d: incorrect record read back", pageSize)
+		}
+		env.Pager().Close()
+	}
+}
+
+func TestInvalidPageSize(t *testing.T) {
+	for _, pageSize := range []int{512, 3000, 2 * data.MaxPageSize} {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Open(filename, data.Options{PageSize: pageSize}); err == nil {
+			t.Errorf("expected an error for page size %d", pageSize)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	env, err := Open(filepath.Join(dir, "test.db"), data.Options{PageSize: data.MinPageSize})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer env.Pager().Close()
+	if err := env.Initialise(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	stream, _ := env.GetStream()
+	for i := 0; i < 1000; i++ {
+		if _, err := stream.Add([]byte{byte(i)}); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+
+	filename := filepath.Join(dir, "backup.db")
+	if err := env.Backup(filename); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	backup, err := Open(filename, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer backup.Pager().Close()
+	if backup.PageSize() != data.MinPageSize {
+		t.Errorf("incorrect page size, expected %d, got %d", data.MinPageSize, backup.PageSize())
+	}
+	if problems := backup.Check(); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+	backupStream, err := backup.GetStream()
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	records, err := backupStream.GetFrom(0, 1000)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	for i, record := range records {
+		if record.Key != uint64(i) || !bytes.Equal(record.Data, []byte{byte(i)}) {
+			t.Errorf("incorrect record %d, got %+v", i, record)
+		}
+	}
+}
+
+func TestReaderSeesLaterCommits(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	writer, err := Open(filename, data.Options{PageSize: data.MinPageSize})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer writer.Pager().Close()
+	if err := writer.Initialise(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if err := writer.Pager().Flush(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	reader, err := Open(filename, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer reader.Pager().Close()
+
+	// Enough records to split the index, so the reader has to follow pages it has not seen
+	stream, _ := writer.GetStream()
+	for i := 0; i < 1000; i++ {
+		if _, err := stream.Add([]byte{byte(i)}); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+	if err := writer.Pager().Flush(); err != nil {
+		t.Fatalf("unexpected error flushing with a reader open, got %+v", err)
+	}
+
+	endRead, err := reader.BeginRead()
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer endRead()
+	readerStream, err := reader.GetStream()
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	records, err := readerStream.GetFrom(0, 1000)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if len(records) != 1000 {
+		t.Errorf("incorrect number of records, expected %d, got %d", 1000, len(records))
+	}
+}
@@ -0,0 +1,367 @@
+package environment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gilmae/klite/data"
+	"github.com/gilmae/klite/store"
+)
+
+// ErrNewerVersion is returned when opening a database written by a newer major version of klite,
+// whose format this version does not understand
+var ErrNewerVersion = errors.New("database was written by a newer version of klite")
+
+// ErrUpgradeRequired is returned when reading a database whose format has to be upgraded first, which
+// happens when it is opened read-write
+var ErrUpgradeRequired = errors.New("database must be upgraded before it can be read")
+
+// Upgrade migrates a database from one format version to the next
+type Upgrade struct {
+	From        []uint8
+	To          []uint8
+	Description string
+	Apply       func(e *Environment) error
+}
+
+// upgrades holds every format change, in order. Each starts at the version the one before it
+// finishes at, and the last finishes at VERSION.
+var upgrades = []Upgrade{
+	{
+		From:        []uint8{0, 9, 1},
+		To:          []uint8{0, 10, 0},
+		Description: "copy the stream into pages with room for a checksum",
+		Apply:       reserveChecksumSpace,
+	},
+	{
+		From:        []uint8{0, 10, 0},
+		To:          []uint8{0, 11, 0},
+		Description: "start a free-list with every page the stream does not use",
+		Apply:       startFreeList,
+	},
+	{
+		From:        []uint8{0, 11, 0},
+		To:          []uint8{0, 12, 0},
+		Description: "record the page size in the root page",
+		Apply:       recordPageSize,
+	},
+	{
+		From:        []uint8{0, 12, 0},
+		To:          []uint8{0, 13, 0},
+		Description: "mark the index root page as the root",
+		Apply:       markIndexRoot,
+	},
+	{
+		From:        []uint8{0, 13, 0},
+		To:          []uint8{0, 14, 0},
+		Description: "link the index leaves",
+		Apply:       linkIndexLeaves,
+	},
+	{
+		From:        []uint8{0, 14, 0},
+		To:          []uint8{0, 15, 0},
+		Description: "copy the stream into one with 64 bit keys",
+		Apply:       widenKeys,
+	},
+}
+
+// checksumVersion is the first version whose pages end with a checksum
+var checksumVersion = []uint8{0, 10, 0}
+
+// wideKeysVersion is the first version whose keys are 64 bits
+var wideKeysVersion = []uint8{0, 15, 0}
+
+type UpgradeOptions struct {
+	// DryRun returns the upgrades that would be applied without changing anything
+	DryRun bool
+	// BackupFile is where the database is copied to before it is changed, no copy is made if empty
+	BackupFile string
+}
+
+// VersionString formats a version as major.minor.revision
+func VersionString(version []uint8) string {
+	return fmt.Sprintf("%d.%d.%d", version[0], version[1], version[2])
+}
+
+func compareVersions(a []uint8, b []uint8) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// checkVersion returns ErrNewerVersion if version's format is not one this version can read
+func checkVersion(version []uint8) error {
+	if version[0] > VERSION[0] {
+		return fmt.Errorf("%w: %s, this is %s", ErrNewerVersion, VersionString(version), VersionString(VERSION))
+	}
+	return nil
+}
+
+// pendingUpgrades returns the upgrades that take a database from version to VERSION
+func pendingUpgrades(version []uint8) ([]Upgrade, error) {
+	if err := checkVersion(version); err != nil {
+		return nil, err
+	}
+
+	pending := []Upgrade{}
+	for _, u := range upgrades {
+		if compareVersions(version, VERSION) >= 0 {
+			break
+		}
+		if compareVersions(u.From, version) == 0 {
+			pending = append(pending, u)
+			version = u.To
+		}
+	}
+	if compareVersions(version, VERSION) < 0 {
+		return nil, fmt.Errorf("no upgrade from version %s", VersionString(version))
+	}
+	return pending, nil
+}
+
+/*
+Upgrade migrates the database to the format of this version of klite, returning the upgrades it
+applied. If opts.BackupFile is set the database is first copied there. The upgrades are flushed
+together, so a crash part way through leaves the database as it was. If an upgrade fails, the changes
+made so far are discarded from the pager, so they cannot be flushed later, by Close say, and the
+database is left as it was.
+*/
+func (e *Environment) Upgrade(opts UpgradeOptions) ([]Upgrade, error) {
+	pending, err := pendingUpgrades(e.Version())
+	if err != nil || len(pending) == 0 || opts.DryRun {
+		return pending, err
+	}
+
+	if opts.BackupFile != "" {
+		if err := e.backupBeforeUpgrade(opts.BackupFile); err != nil {
+			return nil, fmt.Errorf("backing up before upgrading: %w", err)
+		}
+	}
+
+	if err := e.applyUpgrades(pending); err != nil {
+		if discardErr := e.Discard(); discardErr != nil {
+			return nil, fmt.Errorf("%w, then discarding the changes: %v", err, discardErr)
+		}
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (e *Environment) applyUpgrades(pending []Upgrade) error {
+	for _, u := range pending {
+		if err := u.Apply(e); err != nil {
+			return fmt.Errorf("upgrading from %s to %s: %w", VersionString(u.From), VersionString(u.To), err)
+		}
+		if err := e.markRootDirty(); err != nil {
+			return err
+		}
+		e.SetVersion(u.To)
+	}
+	return e.pager.Flush()
+}
+
+// backupBeforeUpgrade copies the database to filename. The pages of versions before 0.10.0 use the
+// bytes a checksum is now written to, so those databases are copied byte for byte instead of through
+// a pager, which would write checksums over them.
+func (e *Environment) backupBeforeUpgrade(filename string) error {
+	if compareVersions(e.Version(), checksumVersion) >= 0 {
+		return data.BackupToFile(e.pager, filename, e.opts, BackupStepSize)
+	}
+	if e.filename == "" {
+		return fmt.Errorf("databases from before version %s can only be backed up when opened with Open", VersionString(checksumVersion))
+	}
+	return copyFile(e.filename, filename)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// reserveChecksumSpace copies the stream into pages that leave their last bytes for a checksum, which
+// versions before 0.10.0 used for items. The pages of the old stream are left unused, to be put on the
+// free-list by startFreeList. The copy is written in the current layout, so its keys are already 64
+// bits and widenKeys has nothing left to do.
+func reserveChecksumSpace(e *Environment) error {
+	streamPageNum, err := store.CopyLegacyStream(e.pager, e.StreamPage(), false)
+	if err != nil {
+		return err
+	}
+	if err := e.markRootDirty(); err != nil {
+		return err
+	}
+	e.SetStreamPage(streamPageNum)
+	e.keysWidened = true
+	return nil
+}
+
+// narrowKeys reports whether the stream still has the 32 bit keys of versions before 0.15.0
+func (e *Environment) narrowKeys() bool {
+	return compareVersions(e.Version(), wideKeysVersion) < 0 && !e.keysWidened
+}
+
+// streamPages returns the page numbers of every page the stream uses, whether or not its keys have
+// been widened yet
+func (e *Environment) streamPages() ([]uint32, error) {
+	if e.narrowKeys() {
+		return store.LegacyStreamPages(e.pager, e.StreamPage())
+	}
+	stream, err := store.NewStream(e.pager, e.StreamPage())
+	if err != nil {
+		return nil, err
+	}
+	return stream.Pages()
+}
+
+// startFreeList clears the free-list header, which versions before 0.11.0 did not have, and frees
+// every page the stream does not use. Each page is zeroed and marked dirty before it is freed, so it
+// is written back with a checksum even if it was last written before pages had them.
+func startFreeList(e *Environment) error {
+	if err := e.markRootDirty(); err != nil {
+		return err
+	}
+	for i := FreeListOffset; i < FreeListOffset+data.FreeListHeaderSize; i++ {
+		(*e.page)[i] = 0
+	}
+
+	pages, err := e.streamPages()
+	if err != nil {
+		return err
+	}
+	inUse := map[uint32]bool{RootPage: true}
+	for _, pageNum := range pages {
+		inUse[pageNum] = true
+	}
+
+	for pageNum, end := uint32(0), e.pager.GetNextUnusedPageNum(); pageNum < end; pageNum++ {
+		if inUse[pageNum] {
+			continue
+		}
+		page, err := e.pager.Page(pageNum)
+		if err != nil {
+			return err
+		}
+		if err := e.pager.MarkDirty(pageNum); err != nil {
+			return err
+		}
+		for i := range *page {
+			(*page)[i] = 0
+		}
+		if err := e.pager.FreePage(pageNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordPageSize writes the page size into the root page, where versions before 0.12.0 left zeroes
+// as every page was DefaultPageSize
+func recordPageSize(e *Environment) error {
+	if err := e.markRootDirty(); err != nil {
+		return err
+	}
+	e.setPageSize(e.pager.PageSize())
+	return nil
+}
+
+// markIndexRoot sets the root flag on the index root, which versions before 0.13.0 never did. The
+// index root is at the start of the stream header whatever the width of the keys.
+func markIndexRoot(e *Environment) error {
+	stream, err := store.NewStream(e.pager, e.StreamPage())
+	if err != nil {
+		return err
+	}
+	page, err := e.pager.Page(stream.IndexPage())
+	if err != nil {
+		return err
+	}
+	if err := e.pager.MarkDirty(stream.IndexPage()); err != nil {
+		return err
+	}
+	data.NewNode(page).SetIsRoot(true)
+	return nil
+}
+
+// linkIndexLeaves links each index leaf to the next, which versions before 0.14.0 never did
+func linkIndexLeaves(e *Environment) error {
+	stream, err := store.NewStream(e.pager, e.StreamPage())
+	if err != nil {
+		return err
+	}
+	if !e.narrowKeys() {
+		return data.NewTree(e.pager, stream.IndexPage()).LinkLeaves()
+	}
+
+	// The link to the next leaf is in the leaf header, which is the same in a narrow leaf
+	_, leaves, err := data.NarrowTreePages(e.pager, stream.IndexPage())
+	if err != nil {
+		return err
+	}
+	for i, pageNum := range leaves {
+		page, err := e.pager.Page(pageNum)
+		if err != nil {
+			return err
+		}
+		if err := e.pager.MarkDirty(pageNum); err != nil {
+			return err
+		}
+		next := uint32(0)
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		data.NewNode(page).SetNextLeaf(next)
+	}
+	return nil
+}
+
+// widenKeys copies the stream into one with 64 bit keys, which versions before 0.15.0 kept in 32 bits,
+// and frees the pages of the old stream
+func widenKeys(e *Environment) error {
+	if !e.narrowKeys() {
+		return nil
+	}
+	oldPages, err := store.LegacyStreamPages(e.pager, e.StreamPage())
+	if err != nil {
+		return err
+	}
+	streamPageNum, err := store.CopyLegacyStream(e.pager, e.StreamPage(), true)
+	if err != nil {
+		return err
+	}
+	if err := e.markRootDirty(); err != nil {
+		return err
+	}
+	e.SetStreamPage(streamPageNum)
+	e.keysWidened = true
+
+	for _, pageNum := range oldPages {
+		if err := e.pager.FreePage(pageNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
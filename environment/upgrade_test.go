@@ -0,0 +1,533 @@
+package environment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+	"github.com/gilmae/klite/store"
+)
+
+// createWithVersion creates a database then rewinds it to an older state, as if it had been written
+// by another version
+func createWithVersion(t *testing.T, filename string, version []uint8) {
+	t.Helper()
+	env, err := Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if err := env.Initialise(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	stream, _ := env.GetStream()
+	stream.Add([]byte{0x1})
+
+	env.markRootDirty()
+	env.SetVersion(version)
+	env.Pager().Close()
+}
+
+/*
+The databases in testdata were written by older versions of klite, each holding items added with
+fixturePayload. klite-0.9.1.db was written by the first version with a format version, whose index is
+only sound up to about 127 items, so it holds 120. klite-0.14.0.db was written by the last version
+with 32 bit keys. It holds 1000 items, so its index has internal nodes, and has 3 pages on its
+free-list.
+*/
+const (
+	firstVersionItems = 120
+	narrowKeysItems   = 1000
+)
+
+func fixturePayload(i int) []byte {
+	length := (i * 37) % 300
+	if i%50 == 7 {
+		length = 5000 + i
+	}
+	payload := make([]byte, length)
+	for j := range payload {
+		payload[j] = byte(i + j)
+	}
+	return payload
+}
+
+// checkFixture checks a database made from one in testdata has all numItems of its items and no
+// problems. The stream is read directly, rather than with GetStream, so a database part way through
+// its upgrades can be checked once its keys are 64 bits.
+func checkFixture(t *testing.T, env *Environment, numItems int) {
+	t.Helper()
+	stream, err := store.NewStream(env.Pager(), env.StreamPage())
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if problems := stream.Check(); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+	records, err := stream.GetFrom(0, uint16(numItems))
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	for i, record := range records {
+		if record.Key != uint64(i) || !bytes.Equal(record.Data, fixturePayload(i)) {
+			t.Errorf("incorrect record %d, got key %d and %d bytes", i, record.Key, len(record.Data))
+		}
+	}
+	if len(records) != numItems {
+		t.Errorf("incorrect number of records, expected %d, got %d", numItems, len(records))
+	}
+	if stream.NextKey() != uint64(numItems) {
+		t.Errorf("incorrect next key, expected %d, got %d", numItems, stream.NextKey())
+	}
+}
+
+// copyFixture copies a database from testdata into a temporary directory, so it can be changed
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	contents, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, contents, 0644); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	return filename
+}
+
+func TestUpgradeOnOpen(t *testing.T) {
+	filename := copyFixture(t, "klite-0.14.0.db")
+
+	// A dry run changes nothing
+	env, err := Open(filename, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if _, err := env.GetStream(); !errors.Is(err, ErrUpgradeRequired) {
+		t.Errorf("expected %v, got %+v", ErrUpgradeRequired, err)
+	}
+	pending, err := env.Upgrade(UpgradeOptions{DryRun: true})
+	if err != nil || len(pending) == 0 || compareVersions(pending[0].From, []uint8{0, 14, 0}) != 0 ||
+		compareVersions(pending[len(pending)-1].To, VERSION) != 0 {
+		t.Errorf("unexpected upgrades, got %+v, %+v", pending, err)
+	}
+	if compareVersions(env.Version(), []uint8{0, 14, 0}) != 0 {
+		t.Errorf("incorrect version after dry run, got %s", VersionString(env.Version()))
+	}
+	env.Pager().Close()
+
+	env, err = Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if compareVersions(env.Version(), VERSION) != 0 {
+		t.Errorf("incorrect version, expected %s, got %s", VersionString(VERSION), VersionString(env.Version()))
+	}
+	checkFixture(t, env, narrowKeysItems)
+	env.Pager().Close()
+
+	// The backup is the database as it was before the upgrade
+	backup, err := Open(filename+".0.14.0.bak", data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error opening backup, got %+v", err)
+	}
+	defer backup.Pager().Close()
+	if compareVersions(backup.Version(), []uint8{0, 14, 0}) != 0 {
+		t.Errorf("incorrect version of backup, got %s", VersionString(backup.Version()))
+	}
+}
+
+func TestUpToDateDatabaseIsNotBackedUp(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	createWithVersion(t, filename, VERSION)
+
+	env, err := Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	env.Pager().Close()
+
+	matches, _ := filepath.Glob(filename + ".*.bak")
+	if len(matches) != 0 {
+		t.Errorf("unexpected backups, got %+v", matches)
+	}
+}
+
+func TestNewerMajorVersionRefused(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	createWithVersion(t, filename, []uint8{VERSION[0] + 1, 0, 0})
+
+	for _, readOnly := range []bool{false, true} {
+		if _, err := Open(filename, data.Options{ReadOnly: readOnly}); !errors.Is(err, ErrNewerVersion) {
+			t.Errorf("expected %v, got %+v", ErrNewerVersion, err)
+		}
+	}
+}
+
+func TestUpgradesAreChained(t *testing.T) {
+	defer func(u []Upgrade, v []uint8) { upgrades, VERSION = u, v }(upgrades, VERSION)
+	applied := []string{}
+	step := func(name string) func(*Environment) error {
+		return func(*Environment) error {
+			applied = append(applied, name)
+			return nil
+		}
+	}
+	upgrades = []Upgrade{
+		{From: []uint8{0, 1, 0}, To: []uint8{0, 2, 0}, Description: "first", Apply: step("first")},
+		{From: []uint8{0, 2, 0}, To: []uint8{0, 2, 1}, Description: "second", Apply: step("second")},
+		{From: []uint8{0, 2, 1}, To: []uint8{1, 0, 0}, Description: "third", Apply: step("third")},
+	}
+	VERSION = []uint8{1, 0, 0}
+
+	filename := filepath.Join(t.TempDir(), "test.db")
+	createWithVersion(t, filename, []uint8{0, 2, 0})
+	env, err := Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer env.Pager().Close()
+
+	if len(applied) != 2 || applied[0] != "second" || applied[1] != "third" {
+		t.Errorf("incorrect upgrades applied, got %+v", applied)
+	}
+	if compareVersions(env.Version(), VERSION) != 0 {
+		t.Errorf("incorrect version, expected %s, got %s", VersionString(VERSION), VersionString(env.Version()))
+	}
+	if _, err := os.Stat(filename + ".0.2.0.bak"); err != nil {
+		t.Errorf("expected a backup, got %+v", err)
+	}
+}
+
+func TestNoUpgradePath(t *testing.T) {
+	if _, err := pendingUpgrades([]uint8{0, 5, 0}); err == nil {
+		t.Errorf("expected an error for a version with no upgrade")
+	}
+}
+
+func TestFileWithoutChecksumsCanBeOpened(t *testing.T) {
+	// Written by klite 0.9.1, before pages had checksums
+	filename := copyFixture(t, "klite-0.9.1.db")
+	env, err := Open(filename, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer env.Pager().Close()
+	if compareVersions(env.Version(), []uint8{0, 9, 1}) != 0 {
+		t.Errorf("incorrect version, got %s", VersionString(env.Version()))
+	}
+}
+
+func TestReserveChecksumSpace(t *testing.T) {
+	filename := copyFixture(t, "klite-0.9.1.db")
+	pager, err := data.Open(filename, data.Options{SkipChecksums: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	env, _ := NewEnvironment(pager)
+	if _, err := env.GetStream(); !errors.Is(err, ErrUpgradeRequired) {
+		t.Errorf("expected %v, got %+v", ErrUpgradeRequired, err)
+	}
+	if err := reserveChecksumSpace(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	env.markRootDirty()
+	env.SetVersion(checksumVersion)
+	pager.Close()
+
+	// Every page in use now has a checksum
+	pager, err = data.Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+	env, _ = NewEnvironment(pager)
+	checkFixture(t, env, firstVersionItems)
+}
+
+func TestStartFreeList(t *testing.T) {
+	filename := copyFixture(t, "klite-0.14.0.db")
+
+	// Versions before 0.11.0 had no free-list, so pages they stopped using were leaked
+	pager, _ := data.Open(filename, data.Options{})
+	env, _ := NewEnvironment(pager)
+	freed, _ := env.Pager().(*data.FreeListPager).FreePageCount()
+	leaked := []uint32{}
+	for i := 0; i < 3; i++ {
+		pageNum, _ := pager.AllocatePage()
+		page, _ := pager.Page(pageNum)
+		(*page)[0] = 0xFF
+		leaked = append(leaked, pageNum)
+	}
+	env.markRootDirty()
+	env.SetVersion([]uint8{0, 10, 0})
+	pager.Close()
+
+	// The pages the fixture had already freed are found again along with the leaked ones
+	pager, _ = data.Open(filename, data.Options{})
+	env, _ = NewEnvironment(pager)
+	if err := startFreeList(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	env.markRootDirty()
+	env.SetVersion([]uint8{0, 11, 0})
+	if count, _ := env.Pager().(*data.FreeListPager).FreePageCount(); count != freed+uint32(len(leaked)) {
+		t.Errorf("incorrect free page count, expected %d, got %d", freed+uint32(len(leaked)), count)
+	}
+	pager.Close()
+
+	// No page the stream uses was freed, and the leaked pages are handed out again before the file grows
+	env, err := Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer env.Pager().Close()
+	checkFixture(t, env, narrowKeysItems)
+	next := env.Pager().GetNextUnusedPageNum()
+	for range leaked {
+		pageNum, err := env.Pager().AllocatePage()
+		if err != nil || pageNum >= next {
+			t.Errorf("expected a leaked page, got %d, %+v", pageNum, err)
+		}
+	}
+}
+
+func TestStartFreeListAfterReserveChecksumSpace(t *testing.T) {
+	filename := copyFixture(t, "klite-0.9.1.db")
+	info, _ := os.Stat(filename)
+	oldPages := int(info.Size()/data.DefaultPageSize) - 1
+
+	pager, _ := data.Open(filename, data.Options{SkipChecksums: true})
+	env, _ := NewEnvironment(pager)
+	if err := reserveChecksumSpace(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	env.markRootDirty()
+	env.SetVersion(checksumVersion)
+	if err := startFreeList(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	env.markRootDirty()
+	env.SetVersion([]uint8{0, 11, 0})
+	pager.Close()
+
+	// The pages of the old stream are free, and have been given checksums
+	pager, err := data.Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+	env, _ = NewEnvironment(pager)
+	checkFixture(t, env, firstVersionItems)
+	if count, _ := env.Pager().(*data.FreeListPager).FreePageCount(); count != uint32(oldPages) {
+		t.Errorf("incorrect free page count, expected %d, got %d", oldPages, count)
+	}
+	for i := 0; i < oldPages; i++ {
+		if _, err := env.Pager().AllocatePage(); err != nil {
+			t.Errorf("unexpected error, got %+v", err)
+		}
+	}
+}
+
+func TestRecordPageSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	createWithVersion(t, filename, []uint8{0, 11, 0})
+
+	// Versions before 0.12.0 left the page size as zeroes
+	pager, _ := data.Open(filename, data.Options{})
+	env, _ := NewEnvironment(pager)
+	env.markRootDirty()
+	env.setPageSize(0)
+	pager.Close()
+
+	pager, _ = data.Open(filename, data.Options{})
+	env, _ = NewEnvironment(pager)
+	if err := recordPageSize(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	pager.Close()
+
+	contents, _ := os.ReadFile(filename)
+	if pageSize := binary.LittleEndian.Uint32(contents[PageSizeOffset:]); pageSize != data.DefaultPageSize {
+		t.Errorf("incorrect page size, expected %d, got %d", data.DefaultPageSize, pageSize)
+	}
+}
+
+func TestUpgradeFromFirstVersion(t *testing.T) {
+	filename := copyFixture(t, "klite-0.9.1.db")
+	original, _ := os.ReadFile(filename)
+
+	// Read-only, the database can only report what it needs
+	env, err := Open(filename, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if _, err := env.GetStream(); !errors.Is(err, ErrUpgradeRequired) {
+		t.Errorf("expected %v, got %+v", ErrUpgradeRequired, err)
+	}
+	pending, err := env.Upgrade(UpgradeOptions{DryRun: true})
+	if err != nil || len(pending) != len(upgrades) {
+		t.Errorf("expected every upgrade, got %+v, %+v", pending, err)
+	}
+	env.Pager().Close()
+
+	env, err = Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if compareVersions(env.Version(), VERSION) != 0 {
+		t.Errorf("incorrect version, expected %s, got %s", VersionString(VERSION), VersionString(env.Version()))
+	}
+	if env.PageSize() != data.DefaultPageSize {
+		t.Errorf("incorrect page size, expected %d, got %d", data.DefaultPageSize, env.PageSize())
+	}
+	checkFixture(t, env, firstVersionItems)
+	env.Pager().Close()
+
+	// Reopened with checksums checked, and new items written into the pages the old stream used
+	env, err = Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	checkFixture(t, env, firstVersionItems)
+	stream, _ := env.GetStream()
+	if count, _ := env.Pager().(*data.FreeListPager).FreePageCount(); count == 0 {
+		t.Errorf("expected the pages of the old stream to be free")
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := stream.Add(fixturePayload(i)); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+	if problems := env.Check(); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+	env.Pager().Close()
+
+	// The backup is the file as it was
+	backupFile := filename + ".0.9.1.bak"
+	if backup, _ := os.ReadFile(backupFile); !bytes.Equal(backup, original) {
+		t.Errorf("backup differs from the original database")
+	}
+	backup, err := Open(backupFile, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error opening backup, got %+v", err)
+	}
+	defer backup.Pager().Close()
+	if compareVersions(backup.Version(), []uint8{0, 9, 1}) != 0 {
+		t.Errorf("incorrect version of backup, got %s", VersionString(backup.Version()))
+	}
+}
+
+func TestLinkNarrowIndexLeaves(t *testing.T) {
+	filename := copyFixture(t, "klite-0.14.0.db")
+	pager, _ := data.Open(filename, data.Options{})
+	defer pager.Close()
+	env, _ := NewEnvironment(pager)
+	stream, _ := store.NewStream(pager, env.StreamPage())
+
+	// Versions before 0.13.0 did not mark the index root, and before 0.14.0 did not link the leaves
+	_, leaves, err := data.NarrowTreePages(pager, stream.IndexPage())
+	if err != nil || len(leaves) < 2 {
+		t.Fatalf("expected an index with several leaves, got %+v, %+v", leaves, err)
+	}
+	page, _ := pager.Page(stream.IndexPage())
+	pager.MarkDirty(stream.IndexPage())
+	data.NewNode(page).SetIsRoot(false)
+	for _, pageNum := range leaves {
+		page, _ := pager.Page(pageNum)
+		pager.MarkDirty(pageNum)
+		data.NewNode(page).SetNextLeaf(0)
+	}
+	env.markRootDirty()
+	env.SetVersion([]uint8{0, 12, 0})
+
+	if err := markIndexRoot(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if err := linkIndexLeaves(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if page, _ := pager.Page(stream.IndexPage()); !data.NewNode(page).IsRoot() {
+		t.Errorf("expected the index root to be marked")
+	}
+	for i, pageNum := range leaves {
+		expected := uint32(0)
+		if i+1 < len(leaves) {
+			expected = leaves[i+1]
+		}
+		if page, _ := pager.Page(pageNum); data.NewNode(page).GetNextLeaf() != expected {
+			t.Errorf("incorrect next leaf of %d, expected %d, got %d", pageNum, expected, data.NewNode(page).GetNextLeaf())
+		}
+	}
+}
+
+func TestWidenKeys(t *testing.T) {
+	filename := copyFixture(t, "klite-0.14.0.db")
+	pager, _ := data.Open(filename, data.Options{})
+	env, _ := NewEnvironment(pager)
+	if _, err := env.GetStream(); !errors.Is(err, ErrUpgradeRequired) {
+		t.Errorf("expected %v, got %+v", ErrUpgradeRequired, err)
+	}
+	oldPages, err := store.LegacyStreamPages(pager, env.StreamPage())
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	if err := widenKeys(env); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	env.markRootDirty()
+	env.SetVersion(wideKeysVersion)
+	pager.Close()
+
+	// The copy took the pages that were already free before growing the file, and the pages of the old
+	// stream are free in their place
+	pager, err = data.Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+	env, _ = NewEnvironment(pager)
+	checkFixture(t, env, narrowKeysItems)
+	if count, _ := env.Pager().(*data.FreeListPager).FreePageCount(); count != uint32(len(oldPages)) {
+		t.Errorf("incorrect free page count, expected %d, got %d", len(oldPages), count)
+	}
+}
+
+func TestFailedUpgradeLeavesFileUnchanged(t *testing.T) {
+	defer func(u []Upgrade) { upgrades = u }(upgrades)
+	upgrades = append([]Upgrade{}, upgrades...)
+	last := &upgrades[len(upgrades)-1]
+	apply := last.Apply
+	last.Apply = func(e *Environment) error {
+		if err := apply(e); err != nil {
+			return err
+		}
+		return errors.New("failed after its changes")
+	}
+
+	filename := copyFixture(t, "klite-0.14.0.db")
+	original, _ := os.ReadFile(filename)
+
+	// A small cache makes some of the changes spill to the journal before the upgrade fails
+	if _, err := Open(filename, data.Options{CacheSize: data.MinCacheSize}); err == nil {
+		t.Fatalf("expected an error from the failed upgrade")
+	}
+	if contents, _ := os.ReadFile(filename); !bytes.Equal(contents, original) {
+		t.Errorf("database changed by the failed upgrade, %d bytes before and %d after", len(original), len(contents))
+	}
+
+	// The upgrade can be tried again, once the backup from the failed one is out of the way
+	upgrades[len(upgrades)-1].Apply = apply
+	os.Remove(filename + ".0.14.0.bak")
+	env, err := Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer env.Pager().Close()
+	checkFixture(t, env, narrowKeysItems)
+}
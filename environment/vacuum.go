@@ -0,0 +1,81 @@
+package environment
+
+import (
+	"fmt"
+
+	"github.com/gilmae/klite/data"
+)
+
+// vacuumSuffix is added to the database's filename to name the file Vacuum builds the new database in
+const vacuumSuffix = "-vacuum"
+
+/*
+Vacuum rewrites the database without the space left behind by removed items and freed pages,
+keeping every item's key. A compacted copy is built in a temporary file beside the database, then
+copied back over it and the file is truncated. The copy back goes through the journal like any other
+change, so if Vacuum is interrupted the database is either as it was or fully vacuumed.
+*/
+func (e *Environment) Vacuum() error {
+	if e.filename == "" {
+		return fmt.Errorf("vacuum is only possible for databases opened with Open")
+	}
+	if err := e.pager.Flush(); err != nil {
+		return err
+	}
+
+	tempFile := e.filename + vacuumSuffix
+	// Anything left behind by an earlier vacuum that did not finish is of no use
+	if err := data.RemoveDBFile(tempFile); err != nil {
+		return err
+	}
+	// The temporary file is thrown away if anything goes wrong, so there is no need to sync it
+	opts := e.opts
+	opts.Mmap, opts.ReadOnly, opts.Synchronous = false, false, data.SyncOff
+	tempPager, err := data.Open(tempFile, opts)
+	if err != nil {
+		return err
+	}
+	defer data.RemoveDBFile(tempFile)
+	defer tempPager.Close()
+
+	temp, err := NewEnvironment(tempPager)
+	if err != nil {
+		return err
+	}
+	if err := temp.Initialise(); err != nil {
+		return err
+	}
+	src, err := e.GetStream()
+	if err != nil {
+		return err
+	}
+	dst, err := temp.GetStream()
+	if err != nil {
+		return err
+	}
+	if err := src.CopyTo(dst); err != nil {
+		return err
+	}
+	if err := temp.markRootDirty(); err != nil {
+		return err
+	}
+	temp.SetVersion(e.Version())
+	if err := temp.pager.Flush(); err != nil {
+		return err
+	}
+
+	backup, err := data.NewBackup(e.pager, temp.pager)
+	if err != nil {
+		return err
+	}
+	if _, err := backup.Step(-1); err != nil {
+		return err
+	}
+
+	rootPage, err := e.pager.Page(RootPage)
+	if err != nil {
+		return err
+	}
+	e.page = rootPage
+	return nil
+}
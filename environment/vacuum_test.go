@@ -0,0 +1,90 @@
+package environment
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+)
+
+func TestVacuum(t *testing.T) {
+	for _, opts := range []data.Options{{}, {Mmap: true}} {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		env, err := Open(filename, opts)
+		if err != nil {
+			t.Fatalf("mmap %t: unexpected error, got %+v", opts.Mmap, err)
+		}
+		if err := env.Initialise(); err != nil {
+			t.Fatalf("mmap %t: unexpected error, got %+v", opts.Mmap, err)
+		}
+		stream, _ := env.GetStream()
+		for i := 0; i < 200; i++ {
+			if _, err := stream.Add(bytes.Repeat([]byte{byte(i)}, i*13)); err != nil {
+				t.Fatalf("mmap %t: unexpected error, got %+v", opts.Mmap, err)
+			}
+		}
+
+		// Leave a run of free pages at the end of the file, and the old index pages free after reindexing
+		pages := []uint32{}
+		for i := 0; i < 50; i++ {
+			pageNum, _ := env.Pager().AllocatePage()
+			env.Pager().Page(pageNum)
+			pages = append(pages, pageNum)
+		}
+		for _, pageNum := range pages {
+			env.Pager().FreePage(pageNum)
+		}
+		if err := env.Reindex(); err != nil {
+			t.Fatalf("mmap %t: unexpected error, got %+v", opts.Mmap, err)
+		}
+		env.Pager().Flush()
+		before, _ := os.Stat(filename)
+
+		if err := env.Vacuum(); err != nil {
+			t.Fatalf("mmap %t: unexpected error, got %+v", opts.Mmap, err)
+		}
+		after, _ := os.Stat(filename)
+		if after.Size() >= before.Size() {
+			t.Errorf("mmap %t: file did not shrink, was %d bytes, now %d", opts.Mmap, before.Size(), after.Size())
+		}
+		if _, err := os.Stat(filename + vacuumSuffix); !os.IsNotExist(err) {
+			t.Errorf("mmap %t: temporary file was left behind, got %+v", opts.Mmap, err)
+		}
+
+		// Adding after a vacuum carries on from the same key
+		stream, _ = env.GetStream()
+		if key, err := stream.Add([]byte{0xff}); err != nil || key != 200 {
+			t.Errorf("mmap %t: incorrect key after vacuum, expected %d, got %d, %+v", opts.Mmap, 200, key, err)
+		}
+		env.Pager().Close()
+
+		env, err = Open(filename, opts)
+		if err != nil {
+			t.Fatalf("mmap %t: unexpected error reopening, got %+v", opts.Mmap, err)
+		}
+		if problems := env.Check(); len(problems) != 0 {
+			t.Errorf("mmap %t: unexpected problems, got %+v", opts.Mmap, problems)
+		}
+		if compareVersions(env.Version(), VERSION) != 0 {
+			t.Errorf("mmap %t: incorrect version, got %s", opts.Mmap, VersionString(env.Version()))
+		}
+		stream, _ = env.GetStream()
+		for i := 0; i < 200; i++ {
+			record, err := stream.Get(uint64(i))
+			if err != nil || !bytes.Equal(record.Data, bytes.Repeat([]byte{byte(i)}, i*13)) {
+				t.Errorf("mmap %t: incorrect record for key %d, got %+v", opts.Mmap, i, err)
+			}
+		}
+		env.Pager().Close()
+	}
+}
+
+func TestVacuumNeedsFilename(t *testing.T) {
+	env, _ := NewEnvironment(data.NewMemoryPager(data.DefaultPageSize))
+	env.Initialise()
+	if err := env.Vacuum(); err == nil {
+		t.Errorf("expected an error vacuuming a database without a file")
+	}
+}
@@ -16,13 +16,16 @@ func Eval(node ast.Node, env *environment.Environment) object.Object {
 		return evalProgram(node, env)
 	case *ast.SelectStatement:
 
-		stream := env.GetStream()
-		key, err := strconv.Atoi(node.Key.String())
+		stream, err := env.GetStream()
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("%s", err)}
+		}
+		key, err := strconv.ParseUint(node.Key.String(), 10, 64)
 		if err != nil {
 			return &object.Error{Message: fmt.Sprintf("%s", err)}
 		}
 		if node.Num == nil {
-			value, err := stream.Get(uint32(key))
+			value, err := stream.Get(key)
 			if err != nil {
 				return &object.Error{Message: fmt.Sprintf("%s", err)}
 			}
@@ -33,7 +36,7 @@ func Eval(node ast.Node, env *environment.Environment) object.Object {
 			if err != nil {
 				return &object.Error{Message: fmt.Sprintf("%s", err)}
 			}
-			values, err := stream.GetFrom(uint32(key), uint16(num))
+			values, err := stream.GetFrom(key, uint16(num))
 			if err != nil {
 				return &object.Error{Message: fmt.Sprintf("%s", err)}
 			}
@@ -46,12 +49,23 @@ func Eval(node ast.Node, env *environment.Environment) object.Object {
 
 		return nil
 	case *ast.InsertStatement:
-		stream := env.GetStream()
+		stream, err := env.GetStream()
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("%s", err)}
+		}
 		key, err := stream.Add([]byte(node.Argument.String()))
 		if err != nil {
 			return &object.Error{Message: fmt.Sprintf("%s", err)}
 		}
 		return &object.Integer{Value: int64(key)} // TODO should return number of rows
+	case *ast.ReindexStatement:
+		if err := env.Reindex(); err != nil {
+			return &object.Error{Message: fmt.Sprintf("%s", err)}
+		}
+	case *ast.VacuumStatement:
+		if err := env.Vacuum(); err != nil {
+			return &object.Error{Message: fmt.Sprintf("%s", err)}
+		}
 	}
 	return &object.Null{}
 }
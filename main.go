@@ -1,19 +1,72 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
+	"github.com/gilmae/klite/data"
+	"github.com/gilmae/klite/environment"
 	"github.com/gilmae/klite/repl"
 )
 
 func main() {
+	var opts data.Options
+	flag.BoolVar(&opts.Mmap, "mmap", false, "read the database through a memory mapping")
+	flag.IntVar(&opts.CacheSize, "cache", data.DefaultCacheSize, "maximum number of pages to cache")
+	flag.IntVar(&opts.PageSize, "page-size", data.DefaultPageSize, "page size to use when creating a database")
+	flag.BoolVar(&opts.ReadOnly, "readonly", false, "open the database without allowing any changes")
+	flag.DurationVar(&opts.BusyTimeout, "busy-timeout", 0, "how long to wait for another process to release the database")
+	synchronous := flag.String("sync", data.SyncFull.String(), "how much to sync to disk on each commit: off, normal or full")
+	keyFile := flag.String("key-file", "", "file holding the hex encoded key to encrypt the database with")
+	upgradeDryRun := flag.Bool("upgrade-dry-run", false, "list the upgrades opening the database would apply, then exit")
+	flag.Parse()
 
-	argv := os.Args
-	if len(argv) < 2 {
+	var err error
+	opts.Synchronous, err = data.ParseSynchronous(*synchronous)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *keyFile != "" {
+		opts.Key, err = data.ReadKeyFile(*keyFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	argv := flag.Args()
+	if len(argv) < 1 {
 		fmt.Println("Missing database")
 		os.Exit(1)
 	}
-	fmt.Println(argv[1])
-	repl.Start(argv[1], os.Stdin, os.Stdout)
+	if *upgradeDryRun {
+		listUpgrades(argv[0], opts)
+		return
+	}
+	fmt.Println(argv[0])
+	repl.Start(argv[0], opts, os.Stdin, os.Stdout)
+}
+
+func listUpgrades(dbPath string, opts data.Options) {
+	opts.ReadOnly = true
+	env, err := environment.Open(dbPath, opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer env.Pager().Close()
+
+	pending, err := env.Upgrade(environment.UpgradeOptions{DryRun: true})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(pending) == 0 {
+		fmt.Printf("Database is up to date at version %s\n", environment.VersionString(env.Version()))
+	}
+	for _, u := range pending {
+		fmt.Printf("%s -> %s: %s\n", environment.VersionString(u.From), environment.VersionString(u.To), u.Description)
+	}
 }
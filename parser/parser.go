@@ -199,6 +199,10 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseSelectStatement()
 	case token.INSERT:
 		return p.parseInsertStatement()
+	case token.REINDEX:
+		return &ast.ReindexStatement{Token: p.curToken}
+	case token.VACUUM:
+		return &ast.VacuumStatement{Token: p.curToken}
 	default:
 		return nil
 	}
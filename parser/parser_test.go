@@ -62,6 +62,34 @@ func TestInsertStatement(t *testing.T) {
 	testLiteralExpression(t, stmt.Argument, "\"abc\"")
 }
 
+func TestReindexStatement(t *testing.T) {
+	l := lexer.New("reindex;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not have enough statements. Expected %d, got %d", 1, len(program.Statements))
+	}
+	if _, ok := program.Statements[0].(*ast.ReindexStatement); !ok {
+		t.Errorf("program.Statements[0] not *ast.ReindexStatement. got %T", program.Statements[0])
+	}
+}
+
+func TestVacuumStatement(t *testing.T) {
+	l := lexer.New("vacuum;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not have enough statements. Expected %d, got %d", 1, len(program.Statements))
+	}
+	if _, ok := program.Statements[0].(*ast.VacuumStatement); !ok {
+		t.Errorf("program.Statements[0] not *ast.VacuumStatement. got %T", program.Statements[0])
+	}
+}
+
 // func TestInsertStatement(t *testing.T) {
 // 	input := []string{"insert 1 a b into topic1"}
 
@@ -6,11 +6,13 @@ import (
 
 	"io"
 	"os"
+	"strings"
 
 	"github.com/gilmae/klite/data"
 	"github.com/gilmae/klite/environment"
 	"github.com/gilmae/klite/evaluator"
 	"github.com/gilmae/klite/lexer"
+	"github.com/gilmae/klite/object"
 	"github.com/gilmae/klite/parser"
 )
 
@@ -22,21 +24,24 @@ const (
 	META_COMMAND_UNRECOGNISED_COMMAND
 )
 
-func Start(dbPath string, in io.Reader, out io.Writer) {
+func Start(dbPath string, opts data.Options, in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(os.Stdin)
-	pager, err := data.NewFilePager(dbPath)
-
+	env, err := environment.Open(dbPath, opts)
 	if err != nil {
-		fmt.Printf("Error opening table: %s", err)
-	}
-	defer pager.Close()
-
-	env, err := environment.NewEnvironment(pager)
-	if err != nil {
-		fmt.Println(err)
+		fmt.Printf("Error opening table: %s\n", err)
 		os.Exit(1)
 	}
+	pager := env.Pager()
+	defer func() {
+		if err := pager.Close(); err != nil {
+			fmt.Println(err)
+		}
+	}()
 	if !env.IsInitialised() {
+		if opts.ReadOnly {
+			fmt.Println("Database has not been initialised")
+			os.Exit(1)
+		}
 		err = env.Initialise()
 		if err != nil {
 			fmt.Println(err)
@@ -64,11 +69,7 @@ func Start(dbPath string, in io.Reader, out io.Writer) {
 			}
 		}
 
-		l := lexer.New(line)
-		p := parser.New(l)
-		program := p.ParseProgram()
-
-		result := evaluator.Eval(program, env)
+		result := execute(line, env)
 		if result != nil {
 			fmt.Printf("%s\n", result.Inspect())
 		}
@@ -77,10 +78,47 @@ func Start(dbPath string, in io.Reader, out io.Writer) {
 	}
 }
 
+// execute evaluates a line as its own batch, flushing its changes if it succeeds and discarding them if
+// it fails part way
+func execute(line string, env *environment.Environment) object.Object {
+	endRead, err := env.BeginRead()
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("%s", err)}
+	}
+	defer endRead()
+
+	l := lexer.New(line)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	result := evaluator.Eval(program, env)
+	if failed, ok := result.(*object.Error); ok {
+		if err := env.Discard(); err != nil {
+			return &object.Error{Message: fmt.Sprintf("%s, then discarding the changes: %s", failed.Message, err)}
+		}
+		return result
+	}
+	if err := env.Pager().Flush(); err != nil {
+		if discardErr := env.Discard(); discardErr != nil {
+			return &object.Error{Message: fmt.Sprintf("%s, then discarding the changes: %s", err, discardErr)}
+		}
+		return &object.Error{Message: fmt.Sprintf("%s", err)}
+	}
+	return result
+}
+
 func doMetaCommand(line string, env *environment.Environment) int {
 	// .exit is handled outside to make breaking out of the repl easier
 	// We'll add to this when there are more meta commands to handle
-	switch line {
+	endRead, err := env.BeginRead()
+	if err != nil {
+		fmt.Println(err)
+		return META_COMMAND_SUCCESS
+	}
+	defer endRead()
+
+	args := strings.Fields(line)
+	switch args[0] {
 	case ".peek":
 		// for i := uint32(0); i < pager.NumPages; i++ {
 		// 	p, err := env.Table.Pager.Page(i)
@@ -91,7 +129,11 @@ func doMetaCommand(line string, env *environment.Environment) int {
 		// }
 		return 0
 	case ".stream":
-		s := env.GetStream()
+		s, err := env.GetStream()
+		if err != nil {
+			fmt.Println(err)
+			return -1
+		}
 		fmt.Printf("Index Root Page\t\t: %d\n", s.IndexPage())
 		fmt.Printf("Store Head Page\t\t: %d\n", s.StoreHeadPage())
 		fmt.Printf("Store Tail Page\t\t: %d\n", s.StoreTailPage())
@@ -111,6 +153,53 @@ func doMetaCommand(line string, env *environment.Environment) int {
 		}
 
 		return 0
+	case ".check":
+		problems := env.Check()
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) == 0 {
+			fmt.Println("ok")
+		}
+		return META_COMMAND_SUCCESS
+	case ".backup":
+		if len(args) != 2 {
+			fmt.Println("Usage: .backup FILE")
+			return META_COMMAND_SUCCESS
+		}
+		if err := env.Backup(args[1]); err != nil {
+			fmt.Println(err)
+		}
+		return META_COMMAND_SUCCESS
+	case ".rekey":
+		if len(args) != 2 {
+			fmt.Println("Usage: .rekey KEYFILE")
+			return META_COMMAND_SUCCESS
+		}
+		key, err := data.ReadKeyFile(args[1])
+		if err != nil {
+			fmt.Println(err)
+			return META_COMMAND_SUCCESS
+		}
+		if err := env.Rekey(key); err != nil {
+			fmt.Println(err)
+		}
+		return META_COMMAND_SUCCESS
+	case ".stats":
+		stats := env.Stats()
+		fmt.Printf("Pages Read\t\t: %d\n", stats.PagesRead)
+		fmt.Printf("Pages Written\t\t: %d\n", stats.PagesWritten)
+		fmt.Printf("Cache Hits\t\t: %d\n", stats.CacheHits)
+		fmt.Printf("Cache Misses\t\t: %d\n", stats.CacheMisses)
+		fmt.Printf("Cached Pages\t\t: %d of %d\n", stats.CachedPages, stats.CacheCapacity)
+		fmt.Printf("Bytes Flushed\t\t: %d\n", stats.BytesFlushed)
+		fmt.Printf("Pages Allocated\t\t: %d\n", stats.PagesAllocated)
+		fmt.Printf("Pages Reused\t\t: %d\n", stats.PagesReused)
+		fmt.Printf("Flushes\t\t\t: %d\n", stats.Flushes)
+		fmt.Printf("Mean Flush Time\t\t: %s\n", stats.MeanFlushTime())
+		fmt.Printf("Last Flush Time\t\t: %s\n", stats.LastFlushTime)
+		fmt.Printf("File Size\t\t: %d\n", stats.FileSize)
+		return META_COMMAND_SUCCESS
 	}
 	return META_COMMAND_UNRECOGNISED_COMMAND
 }
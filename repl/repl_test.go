@@ -0,0 +1,55 @@
+package repl
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+	"github.com/gilmae/klite/environment"
+	"github.com/gilmae/klite/object"
+)
+
+func TestExecuteDiscardsFailedStatement(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	env, err := environment.Open(filename, data.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if err := env.Initialise(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if err := env.Pager().Flush(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	if result := execute("add 'a'", env); result.Type() != object.INTEGER_OBJ {
+		t.Fatalf("expected the add to succeed, got %s", result.Inspect())
+	}
+
+	// Stand in for a statement that fails after changing some pages
+	stream, _ := env.GetStream()
+	if _, err := stream.Add([]byte("b")); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if result := execute("get 100", env); result.Type() != object.ERROR_OBJ {
+		t.Fatalf("expected the get to fail, got %s", result.Inspect())
+	}
+	env.Pager().Close()
+
+	env, err = environment.Open(filename, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer env.Pager().Close()
+	stream, err = env.GetStream()
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	records, err := stream.GetFrom(0, 10)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if len(records) != 1 || string(records[0].Data) != "a" {
+		t.Errorf("expected only the flushed record, got %+v", records)
+	}
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/gilmae/klite/data"
+)
+
+type streamCheck struct {
+	stream   *Stream
+	problems []error
+}
+
+func (c *streamCheck) report(format string, a ...interface{}) {
+	c.problems = append(c.problems, fmt.Errorf("%w: %s", data.ErrCorrupt, fmt.Sprintf(format, a...)))
+}
+
+/*
+Check returns every problem it finds with the stream, or nil if it is sound. Besides checking the
+index tree (see data.Tree.Check) it walks the store nodes from the head to the tail, checking their
+links and free positions, and checks every index entry against the header of the item it points to.
+Finally it follows the items from the first to the last, checking they are the ones in the index.
+*/
+func (s *Stream) Check() []error {
+	c := &streamCheck{stream: s}
+	if err := s.loadHeader(); err != nil {
+		return []error{err}
+	}
+	nextKey := s.NextKey()
+	tailPageNum := s.StoreTailPage()
+	lastPageNum, lastPos := s.LastValueWrittenPage(), s.LastValueWrittenPos()
+
+	nextFree := c.checkNodes(s.StoreHeadPage(), tailPageNum)
+
+	entries := []data.TreeEntry{}
+	c.problems = append(c.problems, s.index.Check(func(key uint64, item data.IndexItem) {
+		entries = append(entries, data.TreeEntry{Key: key, Item: item})
+	})...)
+
+	locations := make(map[uint32]map[uint16]uint64, len(nextFree))
+	for _, e := range entries {
+		if e.Key >= nextKey {
+			c.report("index has key %d, the next key is %d", e.Key, nextKey)
+		}
+		if c.checkItem(e.Key, e.Item, nextFree) {
+			if locations[e.Item.PageNum] == nil {
+				locations[e.Item.PageNum] = make(map[uint16]uint64)
+			}
+			locations[e.Item.PageNum][e.Item.Offset] = e.Key
+		}
+	}
+
+	if len(entries) == 0 {
+		return c.problems
+	}
+
+	// Follow the items from the first to the last
+	pageNum, offset := entries[0].Item.PageNum, entries[0].Item.Offset
+	for i := 0; ; i++ {
+		key, found := locations[pageNum][offset]
+		if !found {
+			c.report("item at page %d offset %d is not in the index", pageNum, offset)
+			break
+		}
+		if i >= len(entries) || key != entries[i].Key {
+			c.report("item for key %d is out of order in the store", key)
+			break
+		}
+		page, err := s.pager.Page(pageNum)
+		if err != nil {
+			c.problems = append(c.problems, err)
+			break
+		}
+		header := ReadHeader(page, offset)
+		if isLastItem(header, pageNum, offset) {
+			if i != len(entries)-1 {
+				c.report("items end at key %d, the index goes on to key %d", key, entries[len(entries)-1].Key)
+			}
+			if pageNum != lastPageNum || offset != lastPos {
+				c.report("last item is at page %d offset %d, the stream says page %d offset %d", pageNum, offset, lastPageNum, lastPos)
+			}
+			break
+		}
+		pageNum, offset = header.NextItemPageNum, header.NextItemOffset
+	}
+
+	return c.problems
+}
+
+// checkNodes walks the store nodes from head to tail, returning the next free position of each
+func (c *streamCheck) checkNodes(headPageNum uint32, tailPageNum uint32) map[uint32]uint16 {
+	nextFree := make(map[uint32]uint16)
+	previous := uint32(0)
+	for pageNum := headPageNum; ; {
+		if _, found := nextFree[pageNum]; found {
+			c.report("store page %d is in the chain more than once", pageNum)
+			return nextFree
+		}
+		page, err := c.stream.pager.Page(pageNum)
+		if err != nil {
+			c.problems = append(c.problems, err)
+			return nextFree
+		}
+
+		node := NewNode(page)
+		if node.Previous() != previous {
+			c.report("store page %d has previous page %d, expected %d", pageNum, node.Previous(), previous)
+		}
+		position := node.NextFreePosition()
+		if position < HeaderSize || position > node.usableSize() {
+			c.report("store page %d has next free position %d outside of the page", pageNum, position)
+			position = node.usableSize()
+		}
+		nextFree[pageNum] = position
+
+		if pageNum == tailPageNum {
+			if node.Next() != 0 {
+				c.report("store tail page %d has next page %d", pageNum, node.Next())
+			}
+			return nextFree
+		}
+		if node.Next() == 0 {
+			c.report("store ends at page %d, before the tail page %d", pageNum, tailPageNum)
+			return nextFree
+		}
+		previous, pageNum = pageNum, node.Next()
+	}
+}
+
+// checkItem checks an index entry against the header of the item it points to, returning whether
+// it points at a valid item header
+func (c *streamCheck) checkItem(key uint64, item data.IndexItem, nextFree map[uint32]uint16) bool {
+	position, found := nextFree[item.PageNum]
+	if !found {
+		c.report("index entry for key %d points at page %d, which is not in the store", key, item.PageNum)
+		return false
+	}
+	if item.Offset < HeaderSize || uint32(item.Offset)+StoreItemSize > uint32(position) {
+		c.report("index entry for key %d points at offset %d of page %d, outside the items written to it", key, item.Offset, item.PageNum)
+		return false
+	}
+
+	page, err := c.stream.pager.Page(item.PageNum)
+	if err != nil {
+		c.problems = append(c.problems, err)
+		return false
+	}
+	header := ReadHeader(page, item.Offset)
+	if header.Key != key {
+		c.report("index entry for key %d points at the item for key %d", key, header.Key)
+		return false
+	}
+	if header.Length != item.Length {
+		c.report("index entry for key %d has length %d, the item has length %d", key, item.Length, header.Length)
+	}
+	return true
+}
+
+// isLastItem returns whether the item at pageNum and offset, with header, is the last in the stream.
+// The last item points nowhere, unless it is also the first, which points at itself.
+func isLastItem(header StoreItem, pageNum uint32, offset uint16) bool {
+	return (header.NextItemPageNum == 0 && header.NextItemOffset == 0) ||
+		(header.NextItemPageNum == pageNum && header.NextItemOffset == offset)
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+)
+
+func TestCheckSoundStream(t *testing.T) {
+	pager := data.NewMemoryPager(data.MinPageSize)
+	stream, _, _ := InitialiseStream(pager)
+	for i := 0; i < 2000; i++ {
+		// Some items span several pages, some leave too little room for the next header
+		if _, err := stream.Add(bytes.Repeat([]byte{byte(i)}, (i*37)%2500)); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+
+	if problems := stream.Check(); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+}
+
+func TestCheckEmptyStream(t *testing.T) {
+	stream, _, _ := InitialiseStream(&data.MemoryPager{})
+	if problems := stream.Check(); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+}
+
+func TestCheckReportsEveryProblem(t *testing.T) {
+	pager := &data.MemoryPager{}
+	stream, _, _ := InitialiseStream(pager)
+	for i := 0; i < 10; i++ {
+		stream.Add(make([]byte, 1000))
+	}
+
+	// A broken link back from the tail
+	tailPage, _ := pager.Page(stream.StoreTailPage())
+	NewNode(tailPage).SetPrevious(stream.IndexPage())
+	// The index has the wrong length for key 2
+	indexPage, _ := pager.Page(stream.IndexPage())
+	index := data.NewNode(indexPage)
+	wrongLength := index.GetNodeValue(2)
+	wrongLength.Length = 5
+	index.SetNodeValue(2, wrongLength)
+	// The item for key 5 claims to be key 6
+	item, _ := stream.index.Get(5)
+	itemPage, _ := pager.Page(item.PageNum)
+	header := ReadHeader(itemPage, item.Offset)
+	header.Key = 6
+	WriteHeader(itemPage, header, item.Offset)
+
+	problems := stream.Check()
+	// Key 5 is also reported as missing when following the items
+	if len(problems) != 4 {
+		t.Errorf("incorrect number of problems, expected %d, got %d: %+v", 4, len(problems), problems)
+	}
+	for _, problem := range problems {
+		if !errors.Is(problem, data.ErrCorrupt) {
+			t.Errorf("expected %v, got %+v", data.ErrCorrupt, problem)
+		}
+	}
+}
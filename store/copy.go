@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/gilmae/klite/data"
+)
+
+/*
+CopyTo adds every item in the index of s to dst, which must be empty, keeping their keys. The items
+are written one after the other, so dst's store has no gaps where items have been removed, and its
+index is built from them in one go with data.BulkLoad, leaving every node full. dst is left with the
+same next key as s, so keys are never reused.
+*/
+func (s *Stream) CopyTo(dst *Stream) error {
+	if err := dst.loadHeader(); err != nil {
+		return err
+	}
+	if dst.NextKey() != 0 {
+		return fmt.Errorf("stream being copied to is not empty")
+	}
+
+	entries := []data.TreeEntry{}
+	err := s.index.Walk(func(key uint64, item data.IndexItem) error {
+		payload, _, err := getItem(item.PageNum, item.Offset, s, key)
+		if err != nil {
+			return err
+		}
+		entry, err := dst.copyItem(key, payload)
+		entries = append(entries, entry)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.loadHeader(); err != nil {
+		return err
+	}
+	return dst.finishCopy(entries, s.NextKey())
+}
+
+// copyItem writes an item being copied into s to the end of its store, returning the index entry for
+// it. The index is built once every item has been copied, see finishCopy.
+func (s *Stream) copyItem(key uint64, payload []byte) (data.TreeEntry, error) {
+	if err := s.loadHeader(); err != nil {
+		return data.TreeEntry{}, err
+	}
+	if err := s.pager.MarkDirty(s.pageNum); err != nil {
+		return data.TreeEntry{}, err
+	}
+	written, err := s.write(key, payload)
+	if err != nil {
+		return data.TreeEntry{}, err
+	}
+	return data.TreeEntry{Key: key, Item: written}, nil
+}
+
+// finishCopy builds the index of s from the entries for the items copied into it, and gives it
+// nextKey
+func (s *Stream) finishCopy(entries []data.TreeEntry, nextKey uint64) error {
+	if err := s.loadHeader(); err != nil {
+		return err
+	}
+	index, err := data.BulkLoad(s.pager, s.IndexPage(), entries)
+	if err != nil {
+		return err
+	}
+	s.index = *index
+
+	if err := s.loadHeader(); err != nil {
+		return err
+	}
+	if err := s.pager.MarkDirty(s.pageNum); err != nil {
+		return err
+	}
+	s.setNextKey(nextKey)
+	return nil
+}
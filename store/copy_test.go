@@ -0,0 +1,51 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCopyToKeepsKeys(t *testing.T) {
+	pager, src := newFreeListStream(t, 0)
+
+	// Leave gaps in the keys, as if items had been removed
+	keys := []uint64{0, 1, 5, 6, 7, 20, 100}
+	for _, key := range keys {
+		src.loadHeader()
+		pager.MarkDirty(src.pageNum)
+		if err := src.add(key, testPayload(int(key))); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+
+	_, dst := newFreeListStream(t, 0)
+	if err := src.CopyTo(dst); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if problems := dst.Check(); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+	if dst.NextKey() != 101 {
+		t.Errorf("incorrect next key, expected %d, got %d", 101, dst.NextKey())
+	}
+	for _, key := range keys {
+		record, err := dst.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected error for key %d, got %+v", key, err)
+		}
+		if !bytes.Equal(record.Data, testPayload(int(key))) {
+			t.Errorf("incorrect data for key %d", key)
+		}
+	}
+	if _, err := dst.Get(2); err == nil {
+		t.Errorf("expected an error getting a key that was not copied")
+	}
+}
+
+func TestCopyToRequiresEmptyStream(t *testing.T) {
+	_, src := newFreeListStream(t, 3)
+	_, dst := newFreeListStream(t, 1)
+	if err := src.CopyTo(dst); err == nil {
+		t.Errorf("expected an error copying to a stream that is not empty")
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+)
+
+func TestAddUnderFaults(t *testing.T) {
+	faults := []data.Fault{
+		{Op: data.FaultRead, Kind: data.FaultError, Rate: 0.05},
+		{Op: data.FaultRead, Kind: data.FaultCrash, Rate: 0.02},
+	}
+	pager := data.NewFaultPager(data.NewMemoryPager(data.MinPageSize), 7)
+	stream, root, err := InitialiseStream(pager)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	pager.Flush()
+	pager.SetFaults(faults...)
+
+	numFaults := 0
+	flushed := 0
+	for i := 0; i < 300; i++ {
+		key, err := stream.Add(testPayload(i))
+		if err == nil && key != uint64(i) {
+			t.Fatalf("incorrect key, expected %d, got %d", i, key)
+		}
+		if err == nil && i%5 == 4 {
+			if err = pager.Flush(); err == nil {
+				flushed = i + 1
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		// Whatever failed, the stream goes back to how it was when last flushed
+		if !errors.Is(err, data.ErrInjected) && !errors.Is(err, data.ErrCrashed) {
+			t.Fatalf("unexpected error adding item %d, got %+v", i, err)
+		}
+		numFaults++
+		pager.Crash()
+		pager.Restart()
+		pager.SetFaults()
+		stream, err = NewStream(pager, root)
+		if err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+		checkItems(t, stream, flushed)
+		pager.SetFaults(faults...)
+		i = flushed - 1
+	}
+
+	if numFaults == 0 {
+		t.Errorf("expected some faults to be injected")
+	}
+	pager.SetFaults()
+	pager.Flush()
+	checkItems(t, stream, 300)
+	if _, err := stream.Get(300); err == nil {
+		t.Errorf("expected an error getting an item that was never added")
+	}
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"github.com/gilmae/klite/data"
+)
+
+/*
+Streams written by older versions cannot be read in place with the current layout, so
+CopyLegacyStream copies their items into a new stream instead.
+
+Before version 0.10.0 pages had no checksum, so store nodes used the whole page and items ran on into
+the bytes the checksum is now kept in. Before version 0.15.0 keys were 32 bits, so item headers and
+the next key in the stream header were 4 bytes shorter, and the index was made of narrow nodes (see
+data.NarrowTreePages). The stream header has always started with the index root, store head and
+store tail pages.
+*/
+const (
+	legacyStoreItemSize = 14
+	legacyNextKeySize   = uint16(unsafe.Sizeof(uint32(0)))
+)
+
+// CopyLegacyStream copies the stream at pageNum, written before keys were 64 bits, into a new stream
+// in the same pager, returning the header page of the new stream. checksummed is whether the stream
+// was written with room for page checksums, by version 0.10.0 or later. The items are read by
+// following them through the store, so an index damaged by an older version does not matter, and the
+// new index is built from them. The pages of the old stream are left as they are, unused.
+func CopyLegacyStream(p data.Pager, pageNum uint32, checksummed bool) (uint32, error) {
+	src := &Stream{pager: p, pageNum: pageNum}
+	if err := src.loadHeader(); err != nil {
+		return 0, err
+	}
+	dst, dstPageNum, err := InitialiseStream(p)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := []data.TreeEntry{}
+	err = src.eachLegacyItem(checksummed, func(key uint64, payload []byte) error {
+		entry, err := dst.copyItem(key, payload)
+		entries = append(entries, entry)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := src.loadHeader(); err != nil {
+		return 0, err
+	}
+	if err := dst.finishCopy(entries, src.legacyNextKey()); err != nil {
+		return 0, err
+	}
+	return dstPageNum, nil
+}
+
+// LegacyStreamPages returns the page numbers of every page the stream at pageNum, written before keys
+// were 64 bits, uses. See Stream.Pages.
+func LegacyStreamPages(p data.Pager, pageNum uint32) ([]uint32, error) {
+	s := &Stream{pager: p, pageNum: pageNum}
+	if err := s.loadHeader(); err != nil {
+		return nil, err
+	}
+	headPageNum, tailPageNum := s.StoreHeadPage(), s.StoreTailPage()
+	pages, _, err := data.NarrowTreePages(p, s.IndexPage())
+	if err != nil {
+		return nil, err
+	}
+	return s.appendStorePages(append(pages, pageNum), headPageNum, tailPageNum)
+}
+
+// legacyNextKey returns the next key from a stream header written before keys were 64 bits
+func (s *Stream) legacyNextKey() uint64 {
+	return uint64(binary.LittleEndian.Uint32((*s.page)[NextKeyOffset : NextKeyOffset+legacyNextKeySize]))
+}
+
+// eachLegacyItem calls visit with every item in a store written before keys were 64 bits, from the
+// first to the last
+func (s *Stream) eachLegacyItem(checksummed bool, visit func(key uint64, payload []byte) error) error {
+	pageNum, offset := s.StoreHeadPage(), uint16(HeaderSize)
+	page, err := s.pager.Page(pageNum)
+	if err != nil {
+		return err
+	}
+	if NewNode(page).NextFreePosition() == HeaderSize {
+		return nil
+	}
+
+	first := true
+	var previous uint64
+	for {
+		header, payload, err := readLegacyItem(s.pager, pageNum, offset, checksummed)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if err := checkKeyOrder(pageNum, offset, header.Key, previous); err != nil {
+				return err
+			}
+		}
+		first, previous = false, header.Key
+
+		if err := visit(header.Key, payload); err != nil {
+			return err
+		}
+		if isLastItem(header, pageNum, offset) {
+			return nil
+		}
+		pageNum, offset = header.NextItemPageNum, header.NextItemOffset
+	}
+}
+
+// readLegacyItem reads the header and payload of the item at offset in pageNum, from a store written
+// before keys were 64 bits. Unless checksummed its nodes use the whole page.
+func readLegacyItem(p data.Pager, pageNum uint32, offset uint16, checksummed bool) (StoreItem, []byte, error) {
+	page, err := p.Page(pageNum)
+	if err != nil {
+		return StoreItem{}, nil, err
+	}
+	usableSize := len(*page)
+	if checksummed {
+		usableSize = page.UsableSize()
+	}
+	if offset < HeaderSize || int(offset)+legacyStoreItemSize > usableSize {
+		return StoreItem{}, nil, fmt.Errorf("%w: item at page %d offset %d is outside of the page", data.ErrCorrupt, pageNum, offset)
+	}
+	header := deserialiseLegacy((*page)[offset : offset+legacyStoreItemSize])
+
+	payload := make([]byte, header.Length)
+	position := int(offset) + legacyStoreItemSize
+	read := 0
+	for {
+		read += copy(payload[read:], (*page)[position:usableSize])
+		if read == len(payload) {
+			return header, payload, nil
+		}
+		next := NewNode(page).Next()
+		if next == 0 {
+			return StoreItem{}, nil, fmt.Errorf("%w: item for key %d ends after %d of %d bytes", data.ErrCorrupt, header.Key, read, header.Length)
+		}
+		if page, err = p.Page(next); err != nil {
+			return StoreItem{}, nil, err
+		}
+		position = int(HeaderSize)
+	}
+}
+
+// deserialiseLegacy reads an item header written before keys were 64 bits
+func deserialiseLegacy(enc []byte) StoreItem {
+	r := StoreItem{}
+	r.Key = uint64(binary.LittleEndian.Uint32(enc[0:4]))
+	r.Length = binary.LittleEndian.Uint32(enc[4:8])
+	r.NextItemPageNum = binary.LittleEndian.Uint32(enc[8:12])
+	r.NextItemOffset = binary.LittleEndian.Uint16(enc[12:14])
+	return r
+}
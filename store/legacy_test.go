@@ -0,0 +1,121 @@
+package store
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+)
+
+// writeLegacyHeader writes an item header the way versions before 0.15.0 did, with a 32 bit key
+func writeLegacyHeader(p *data.Page, header StoreItem, offset uint16) {
+	enc := (*p)[offset : offset+legacyStoreItemSize]
+	binary.LittleEndian.PutUint32(enc[0:4], uint32(header.Key))
+	binary.LittleEndian.PutUint32(enc[4:8], header.Length)
+	binary.LittleEndian.PutUint32(enc[8:12], header.NextItemPageNum)
+	binary.LittleEndian.PutUint16(enc[12:14], header.NextItemOffset)
+}
+
+// writeLegacyStream writes numItems items to a new stream the way versions before 0.15.0 did, with 32
+// bit keys, and returns the stream's header page. Unless checksummed, as before 0.10.0, items run up
+// to the very end of each store page.
+func writeLegacyStream(t *testing.T, pager data.Pager, numItems int, checksummed bool) uint32 {
+	t.Helper()
+	stream, streamPageNum, err := InitialiseStream(pager)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	pageNum := stream.StoreHeadPage()
+	page, _ := pager.Page(pageNum)
+	position := int(HeaderSize)
+	usableSize := len(*page)
+	if checksummed {
+		usableSize = page.UsableSize()
+	}
+	nextNode := func() {
+		NewNode(page).SetNextFreePosition(uint16(position))
+		next, _ := pager.AllocatePage()
+		NewNode(page).SetNext(next)
+		page, _ = pager.Page(next)
+		InititaliseNode(page).SetPrevious(pageNum)
+		pageNum, position = next, int(HeaderSize)
+	}
+
+	lastPageNum, lastOffset := pageNum, uint16(HeaderSize)
+	for key := 0; key < numItems; key++ {
+		payload := testPayload(key)
+		if usableSize-position < legacyStoreItemSize {
+			position = usableSize
+			nextNode()
+		}
+		startPageNum, startOffset := pageNum, uint16(position)
+		writeLegacyHeader(page, NewStoreItem(uint64(key), uint32(len(payload)), 0, 0), startOffset)
+		position += legacyStoreItemSize
+		for written := 0; ; {
+			n := copy((*page)[position:usableSize], payload[written:])
+			written, position = written+n, position+n
+			if written == len(payload) {
+				break
+			}
+			nextNode()
+		}
+		NewNode(page).SetNextFreePosition(uint16(position))
+
+		// Point the last item at this one, so the first item points at itself
+		lastPage, _ := pager.Page(lastPageNum)
+		last := deserialiseLegacy((*lastPage)[lastOffset:])
+		last.NextItemPageNum, last.NextItemOffset = startPageNum, startOffset
+		writeLegacyHeader(lastPage, last, lastOffset)
+		lastPageNum, lastOffset = startPageNum, startOffset
+	}
+
+	// The fields after the store tail page were 4 bytes further on, after a 32 bit next key
+	stream.loadHeader()
+	stream.SetStoreTailPage(pageNum)
+	header := (*stream.page)[NextKeyOffset:]
+	binary.LittleEndian.PutUint32(header[0:4], uint32(numItems))
+	binary.LittleEndian.PutUint32(header[4:8], lastPageNum)
+	binary.LittleEndian.PutUint16(header[8:10], lastOffset)
+	return streamPageNum
+}
+
+func TestCopyLegacyStream(t *testing.T) {
+	for _, checksummed := range []bool{false, true} {
+		for _, numItems := range []int{0, 1, 2, 500} {
+			pager := data.NewMemoryPager(data.MinPageSize)
+			legacyPageNum := writeLegacyStream(t, pager, numItems, checksummed)
+
+			streamPageNum, err := CopyLegacyStream(pager, legacyPageNum, checksummed)
+			if err != nil {
+				t.Fatalf("%d, %t: unexpected error, got %+v", numItems, checksummed, err)
+			}
+			stream, err := NewStream(pager, streamPageNum)
+			if err != nil {
+				t.Fatalf("%d, %t: unexpected error, got %+v", numItems, checksummed, err)
+			}
+			checkItems(t, stream, numItems)
+		}
+	}
+}
+
+func TestCopyLegacyStreamWithLoop(t *testing.T) {
+	pager := data.NewMemoryPager(data.MinPageSize)
+	legacyPageNum := writeLegacyStream(t, pager, 10, true)
+
+	// Point the fifth item back at the first
+	stream, _ := NewStream(pager, legacyPageNum)
+	page, _ := pager.Page(stream.StoreHeadPage())
+	header, offset := deserialiseLegacy((*page)[HeaderSize:]), uint16(HeaderSize)
+	for i := 0; i < 4; i++ {
+		page, _ = pager.Page(header.NextItemPageNum)
+		offset = header.NextItemOffset
+		header = deserialiseLegacy((*page)[offset:])
+	}
+	header.NextItemPageNum, header.NextItemOffset = stream.StoreHeadPage(), HeaderSize
+	writeLegacyHeader(page, header, offset)
+
+	if _, err := CopyLegacyStream(pager, legacyPageNum, true); err == nil {
+		t.Errorf("expected an error copying items that loop")
+	}
+}
@@ -66,8 +66,13 @@ func (n *Node) SetNextFreePosition(nextFreePos uint16) {
 	binary.LittleEndian.PutUint16((*n.page)[NextFreePositionOffset:NextFreePositionOffset+NextFreePositionSize], nextFreePos)
 }
 
+// usableSize is the number of bytes of the page the node can use
+func (n *Node) usableSize() uint16 {
+	return uint16(n.page.UsableSize())
+}
+
 func (n *Node) CloseNode() {
-	n.SetNextFreePosition(data.PageSize)
+	n.SetNextFreePosition(n.usableSize())
 }
 
 // func (n *Node) LastValueWrittenPosition() uint16 {
@@ -79,7 +84,7 @@ func (n *Node) CloseNode() {
 // }
 
 func (n *Node) SpaceRemaining() uint16 {
-	return data.PageSize - n.NextFreePosition()
+	return n.usableSize() - n.NextFreePosition()
 }
 
 func (n *Node) Write(data []byte) (uint16, error) {
@@ -99,8 +104,8 @@ func (n *Node) Read(offset uint16, length uint32, buffer []byte) (uint32, error)
 
 	numBytesToRead := length
 
-	if uint32(offset)+length > data.PageSize {
-		numBytesToRead = uint32(data.PageSize - offset)
+	if uint32(offset)+length > uint32(n.usableSize()) {
+		numBytesToRead = uint32(n.usableSize() - offset)
 	}
 
 	copy(buffer, (*n.page)[uint32(offset):uint32(offset)+numBytesToRead])
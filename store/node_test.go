@@ -8,32 +8,32 @@ import (
 )
 
 func TestNewNode(t *testing.T) {
-	page := data.Page(make([]byte, data.PageSize))
+	page := data.Page(make([]byte, data.DefaultPageSize))
 	node := InititaliseNode(&page)
 
 	if node.NextFreePosition() != 12 {
 		t.Errorf("space remaining is incorrect ,expected %+v, got %+v", 12, node.NextFreePosition())
 	}
-	if node.SpaceRemaining() != 4084 {
-		t.Errorf("space remaining is incorrect ,expected %+v, got %+v", 4084, node.SpaceRemaining())
+	if node.SpaceRemaining() != 4080 {
+		t.Errorf("space remaining is incorrect ,expected %+v, got %+v", 4080, node.SpaceRemaining())
 	}
 }
 
 func TestWriteToNode(t *testing.T) {
-	page := data.Page(make([]byte, data.PageSize))
+	page := data.Page(make([]byte, data.DefaultPageSize))
 	node := InititaliseNode(&page)
 
-	payload := make([]byte, 4080)
+	payload := make([]byte, 4076)
 	bytesWritten, err := node.Write(payload)
 
 	if err != nil {
 		t.Errorf("unexpected error. Got %+v", err)
 	}
-	if bytesWritten != 4080 {
-		t.Errorf("bytesWritten is incorrect ,expected %d, got %d", 4080, bytesWritten)
+	if bytesWritten != 4076 {
+		t.Errorf("bytesWritten is incorrect ,expected %d, got %d", 4076, bytesWritten)
 	}
-	if node.NextFreePosition() != 4092 {
-		t.Errorf("nextFreePosition is incorrect ,expected %+v, got %+v", 4092, node.NextFreePosition())
+	if node.NextFreePosition() != 4088 {
+		t.Errorf("nextFreePosition is incorrect ,expected %+v, got %+v", 4088, node.NextFreePosition())
 	}
 	if node.SpaceRemaining() != 4 {
 		t.Errorf("space remaining is incorrect ,expected %+v, got %+v", 4, node.SpaceRemaining())
@@ -41,7 +41,7 @@ func TestWriteToNode(t *testing.T) {
 }
 
 func TestWriteToNodeWithInsufficientSpace(t *testing.T) {
-	page := data.Page(make([]byte, data.PageSize))
+	page := data.Page(make([]byte, data.DefaultPageSize))
 	node := InititaliseNode(&page)
 
 	payload := make([]byte, 4080)
@@ -69,13 +69,13 @@ func TestReadFromNode(t *testing.T) {
 		expectedError     error
 	}{
 		{0, 2, []byte{2, 3}, []byte{2, 3}, 2, nil},
-		{4094, 2, []byte{3, 1}, []byte{3, 1}, 2, nil},
-		{4095, 2, []byte{2}, []byte{2, 0}, 1, nil},
+		{4090, 2, []byte{3, 1}, []byte{3, 1}, 2, nil},
+		{4091, 2, []byte{2}, []byte{2, 0}, 1, nil},
 	}
 
 	for _, test := range tests {
 
-		page := data.Page(make([]byte, data.PageSize))
+		page := data.Page(make([]byte, data.DefaultPageSize))
 		if test.expectedError == nil {
 			copy(page[test.offset:uint32(test.offset)+test.expectedBytesRead], test.expectedBuffer)
 		}
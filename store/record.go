@@ -2,5 +2,5 @@ package store
 
 type Record struct {
 	Data []byte
-	Key  uint32
+	Key  uint64
 }
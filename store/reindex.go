@@ -0,0 +1,117 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/gilmae/klite/data"
+)
+
+/*
+Reindex throws the index away and builds it again by following the items in the store from the
+first, which is always at the start of the head page. The next key and the position of the last item
+are reset from the items found as well, so Reindex repairs a stream whose index is damaged or was
+written by an older version.
+
+The pages of the old index are handed back to the pager to be reused, unless the old index is
+damaged, in which case they are left alone as they may point anywhere.
+*/
+func (s *Stream) Reindex() error {
+	if err := s.loadHeader(); err != nil {
+		return err
+	}
+	if err := s.pager.MarkDirty(s.pageNum); err != nil {
+		return err
+	}
+
+	entries, err := s.scanItems()
+	if err != nil {
+		return err
+	}
+
+	var oldPages []uint32
+	if len(s.index.Check(nil)) == 0 {
+		if oldPages, err = s.index.Pages(); err != nil {
+			return err
+		}
+	}
+
+	rootPageNum, err := s.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	index, err := data.BulkLoad(s.pager, rootPageNum, entries)
+	if err != nil {
+		return err
+	}
+
+	for _, pageNum := range oldPages {
+		if err := s.pager.FreePage(pageNum); err != nil {
+			return err
+		}
+	}
+
+	if err := s.loadHeader(); err != nil {
+		return err
+	}
+	if err := s.pager.MarkDirty(s.pageNum); err != nil {
+		return err
+	}
+	s.SetIndexPage(rootPageNum)
+	s.index = *index
+	if len(entries) == 0 {
+		s.setNextKey(0)
+		s.setLastValueWrittenPage(s.StoreHeadPage())
+		s.setLastValueWrittenPos(HeaderSize)
+		return nil
+	}
+	last := entries[len(entries)-1]
+	s.setNextKey(last.Key + 1)
+	s.setLastValueWrittenPage(last.Item.PageNum)
+	s.setLastValueWrittenPos(last.Item.Offset)
+	return nil
+}
+
+// scanItems follows the items in the store from the first to the last, returning where each is
+func (s *Stream) scanItems() ([]data.TreeEntry, error) {
+	pageNum, offset := s.StoreHeadPage(), uint16(HeaderSize)
+	page, err := s.pager.Page(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if NewNode(page).NextFreePosition() == HeaderSize {
+		return nil, nil
+	}
+
+	entries := []data.TreeEntry{}
+	for {
+		page, err := s.pager.Page(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		if offset < HeaderSize || uint32(offset)+StoreItemSize > uint32(NewNode(page).usableSize()) {
+			return nil, fmt.Errorf("%w: item at page %d offset %d is outside of the page", data.ErrCorrupt, pageNum, offset)
+		}
+
+		header := ReadHeader(page, offset)
+		if len(entries) > 0 {
+			if err := checkKeyOrder(pageNum, offset, header.Key, entries[len(entries)-1].Key); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, data.TreeEntry{Key: header.Key, Item: data.NewIndexItem(pageNum, offset, header.Length)})
+
+		if isLastItem(header, pageNum, offset) {
+			return entries, nil
+		}
+		pageNum, offset = header.NextItemPageNum, header.NextItemOffset
+	}
+}
+
+// checkKeyOrder returns an error unless the key of the item at offset in pageNum comes after the key of
+// the item before it. Keys only go up, which also stops a loop in the items going round forever.
+func checkKeyOrder(pageNum uint32, offset uint16, key uint64, previous uint64) error {
+	if key <= previous {
+		return fmt.Errorf("%w: item at page %d offset %d has key %d, after key %d", data.ErrCorrupt, pageNum, offset, key, previous)
+	}
+	return nil
+}
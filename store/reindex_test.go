@@ -0,0 +1,103 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gilmae/klite/data"
+)
+
+// testPayload returns the payload of item i, some of which span several pages
+func testPayload(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, (i*37)%1500)
+}
+
+func newFreeListStream(t *testing.T, numItems int) (*data.FreeListPager, *Stream) {
+	t.Helper()
+	memoryPager := data.NewMemoryPager(data.MinPageSize)
+	// Page 0 holds the free-list header
+	memoryPager.Page(0)
+	pager := data.NewFreeListPager(memoryPager, 0, 0)
+	stream, _, err := InitialiseStream(pager)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	for i := 0; i < numItems; i++ {
+		if _, err := stream.Add(testPayload(i)); err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+	}
+	return pager, stream
+}
+
+func checkItems(t *testing.T, stream *Stream, numItems int) {
+	t.Helper()
+	if problems := stream.Check(); len(problems) != 0 {
+		t.Errorf("unexpected problems, got %+v", problems)
+	}
+	if stream.NextKey() != uint64(numItems) {
+		t.Errorf("incorrect next key, expected %d, got %d", numItems, stream.NextKey())
+	}
+	for i := 0; i < numItems; i++ {
+		record, err := stream.Get(uint64(i))
+		if err != nil {
+			t.Fatalf("unexpected error for key %d, got %+v", i, err)
+		}
+		if !bytes.Equal(record.Data, testPayload(i)) {
+			t.Errorf("incorrect data for key %d", i)
+		}
+	}
+}
+
+func TestReindexDamagedIndex(t *testing.T) {
+	pager, stream := newFreeListStream(t, 3000)
+
+	// Wreck the index and the details of the last item
+	indexPage, _ := pager.Page(stream.IndexPage())
+	copy(*indexPage, make([]byte, len(*indexPage)))
+	stream.setNextKey(7)
+	stream.setLastValueWrittenPos(0)
+	if problems := stream.Check(); len(problems) == 0 {
+		t.Fatalf("expected problems with the damaged stream")
+	}
+
+	if err := stream.Reindex(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	checkItems(t, stream, 3000)
+
+	// The old pages may point anywhere, so are not reused
+	if count, _ := pager.FreePageCount(); count != 0 {
+		t.Errorf("incorrect number of free pages, expected %d, got %d", 0, count)
+	}
+
+	// Items added afterwards carry on from the last one
+	if key, err := stream.Add(testPayload(3000)); err != nil || key != 3000 {
+		t.Errorf("unexpected result adding after reindex, got %d, %+v", key, err)
+	}
+	checkItems(t, stream, 3001)
+}
+
+func TestReindexFreesOldIndex(t *testing.T) {
+	pager, stream := newFreeListStream(t, 3000)
+	oldPages, _ := stream.index.Pages()
+
+	if err := stream.Reindex(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	checkItems(t, stream, 3000)
+	if count, _ := pager.FreePageCount(); count != uint32(len(oldPages)) {
+		t.Errorf("incorrect number of free pages, expected %d, got %d", len(oldPages), count)
+	}
+}
+
+func TestReindexEmptyStream(t *testing.T) {
+	_, stream := newFreeListStream(t, 0)
+	if err := stream.Reindex(); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	checkItems(t, stream, 0)
+	if key, err := stream.Add([]byte{0x1}); err != nil || key != 0 {
+		t.Errorf("unexpected result adding after reindex, got %d, %+v", key, err)
+	}
+}
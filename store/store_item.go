@@ -16,41 +16,44 @@ import (
 	"github.com/gilmae/klite/data"
 )
 
+// StoreItemSize is the size of a serialised StoreItem
+const StoreItemSize = 18
+
 type StoreItem struct {
-	Key             uint32
+	Key             uint64
 	Length          uint32
 	NextItemPageNum uint32
 	NextItemOffset  uint16
 }
 
-func NewStoreItem(key uint32, length uint32, nextItemPageNum uint32, nextItemOffset uint16) StoreItem {
+func NewStoreItem(key uint64, length uint32, nextItemPageNum uint32, nextItemOffset uint16) StoreItem {
 	return StoreItem{Key: key, NextItemPageNum: nextItemPageNum, NextItemOffset: nextItemOffset, Length: length}
 }
 
 func ReadHeader(p *data.Page, offset uint16) StoreItem {
-	return Deserialise((*p)[offset : offset+14])
+	return Deserialise((*p)[offset : offset+StoreItemSize])
 }
 
 func WriteHeader(p *data.Page, header StoreItem, offset uint16) {
-	copy((*p)[offset:offset+14], Serialise(header))
+	copy((*p)[offset:offset+StoreItemSize], Serialise(header))
 }
 
 func Deserialise(enc []byte) StoreItem {
 	r := StoreItem{}
-	r.Key = binary.LittleEndian.Uint32(enc[0:4])
-	r.Length = binary.LittleEndian.Uint32(enc[4:8])
-	r.NextItemPageNum = binary.LittleEndian.Uint32(enc[8:12])
-	r.NextItemOffset = binary.LittleEndian.Uint16(enc[12:14])
+	r.Key = binary.LittleEndian.Uint64(enc[0:8])
+	r.Length = binary.LittleEndian.Uint32(enc[8:12])
+	r.NextItemPageNum = binary.LittleEndian.Uint32(enc[12:16])
+	r.NextItemOffset = binary.LittleEndian.Uint16(enc[16:18])
 
 	return r
 }
 
 func Serialise(r StoreItem) []byte {
-	enc := make([]byte, 14)
-	binary.LittleEndian.PutUint32(enc[0:4], r.Key)
-	binary.LittleEndian.PutUint32(enc[4:8], r.Length)
-	binary.LittleEndian.PutUint32(enc[8:12], uint32(r.NextItemPageNum))
-	binary.LittleEndian.PutUint16(enc[12:14], uint16(r.NextItemOffset))
+	enc := make([]byte, StoreItemSize)
+	binary.LittleEndian.PutUint64(enc[0:8], r.Key)
+	binary.LittleEndian.PutUint32(enc[8:12], r.Length)
+	binary.LittleEndian.PutUint32(enc[12:16], uint32(r.NextItemPageNum))
+	binary.LittleEndian.PutUint16(enc[16:18], uint16(r.NextItemOffset))
 
 	return enc
 }
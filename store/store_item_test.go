@@ -7,19 +7,19 @@ import (
 )
 
 func TestSerialise(t *testing.T) {
-	r := StoreItem{Key: 1, NextItemPageNum: 3, NextItemOffset: 2, Length: 513}
+	r := StoreItem{Key: 1<<32 + 1, NextItemPageNum: 3, NextItemOffset: 2, Length: 513}
 	enc := Serialise(r)
-	expectedValue := []byte{1, 0, 0, 0, 1, 2, 0, 0, 3, 0, 0, 0, 2, 0}
+	expectedValue := []byte{1, 0, 0, 0, 1, 0, 0, 0, 1, 2, 0, 0, 3, 0, 0, 0, 2, 0}
 	if !cmp.Equal(enc, expectedValue) {
 		t.Errorf("incorrect serialised value, expected %+v, got %+v", expectedValue, enc)
 	}
 }
 
 func TestDeserialise(t *testing.T) {
-	bytes := []byte{7, 0, 0, 0, 3, 1, 0, 0, 1, 0, 0, 0, 4, 0}
+	bytes := []byte{7, 0, 0, 0, 0, 0, 0, 0, 3, 1, 0, 0, 1, 0, 0, 0, 4, 0}
 	r := Deserialise(bytes)
 
-	expectedKey := uint32(7)
+	expectedKey := uint64(7)
 	expectedLength := uint32(259)
 	expectedNextItemPageNum := uint32(1)
 	expectedNextItemOffset := uint16(4)
@@ -7,7 +7,6 @@ import (
 	"unsafe"
 
 	"github.com/gilmae/klite/data"
-	"github.com/google/go-cmp/cmp"
 )
 
 const (
@@ -18,7 +17,7 @@ const (
 	StoreTailPageOffset        = StoreHeadPageOffset + StoreHeadPageSize
 	StoreTailPageSize          = uint16(unsafe.Sizeof(uint32(0)))
 	NextKeyOffset              = StoreTailPageOffset + StoreTailPageSize
-	NextKeySize                = uint16(unsafe.Sizeof(uint32(0)))
+	NextKeySize                = uint16(unsafe.Sizeof(uint64(0)))
 	LastValueWrittenPageOffset = NextKeyOffset + NextKeySize
 	LastValueWrittenPageSize   = uint16(unsafe.Sizeof(uint32(0)))
 	LastValueWrittenPosOffset  = LastValueWrittenPageOffset + LastValueWrittenPageSize
@@ -27,32 +26,56 @@ const (
 )
 
 type Stream struct {
-	pager data.Pager
-	page  *data.Page
-	index data.Tree
+	pager   data.Pager
+	page    *data.Page
+	pageNum uint32
+	index   data.Tree
 }
 
-func NewStream(p data.Pager, rootPageNum uint32) *Stream {
-	stream := &Stream{pager: p}
-	stream.page, _ = stream.pager.Page(rootPageNum)
+func NewStream(p data.Pager, rootPageNum uint32) (*Stream, error) {
+	stream := &Stream{pager: p, pageNum: rootPageNum}
+	if err := stream.loadHeader(); err != nil {
+		return nil, err
+	}
 
 	stream.index = *data.NewTree(p, stream.IndexPage())
-	return stream
+	return stream, nil
 }
 
-func InitialiseStream(p data.Pager) (*Stream, uint32) {
+// allocatePage returns a new page, already marked dirty
+func (s *Stream) allocatePage() (uint32, *data.Page, error) {
+	pageNum, err := s.pager.AllocatePage()
+	if err != nil {
+		return 0, nil, err
+	}
+	page, err := s.pager.Page(pageNum)
+	if err != nil {
+		return 0, nil, err
+	}
+	return pageNum, page, nil
+}
+
+func InitialiseStream(p data.Pager) (*Stream, uint32, error) {
 	stream := &Stream{pager: p}
-	streamRootPage := stream.pager.GetNextUnusedPageNum()
-	stream.page, _ = stream.pager.Page(streamRootPage)
+	streamRootPage, streamPage, err := stream.allocatePage()
+	if err != nil {
+		return nil, 0, err
+	}
+	stream.pageNum = streamRootPage
+	stream.page = streamPage
 
-	indexRootPageNum := stream.pager.GetNextUnusedPageNum()
-	indexRootPage, _ := stream.pager.Page(indexRootPageNum)
-	data.NewLeaf(indexRootPage)
+	indexRootPageNum, indexRootPage, err := stream.allocatePage()
+	if err != nil {
+		return nil, 0, err
+	}
+	data.NewLeaf(indexRootPage).SetIsRoot(true)
 	stream.SetIndexPage(indexRootPageNum)
 	stream.index = *data.NewTree(p, indexRootPageNum)
 
-	storeHeadPageNum := stream.pager.GetNextUnusedPageNum()
-	storeHeadPage, _ := stream.pager.Page(storeHeadPageNum)
+	storeHeadPageNum, storeHeadPage, err := stream.allocatePage()
+	if err != nil {
+		return nil, 0, err
+	}
 
 	InititaliseNode(storeHeadPage)
 	stream.SetStoreHeadPage(storeHeadPageNum)
@@ -62,7 +85,7 @@ func InitialiseStream(p data.Pager) (*Stream, uint32) {
 
 	stream.setNextKey(0)
 
-	return stream, streamRootPage
+	return stream, streamRootPage, nil
 }
 
 func (s *Stream) IndexPage() uint32 {
@@ -89,12 +112,12 @@ func (s *Stream) SetStoreTailPage(pageNum uint32) {
 	binary.LittleEndian.PutUint32((*s.page)[StoreTailPageOffset:StoreTailPageOffset+StoreTailPageSize], pageNum)
 }
 
-func (s *Stream) NextKey() uint32 {
-	return binary.LittleEndian.Uint32((*s.page)[NextKeyOffset : NextKeyOffset+NextKeySize])
+func (s *Stream) NextKey() uint64 {
+	return binary.LittleEndian.Uint64((*s.page)[NextKeyOffset : NextKeyOffset+NextKeySize])
 }
 
-func (s *Stream) setNextKey(key uint32) {
-	binary.LittleEndian.PutUint32((*s.page)[NextKeyOffset:NextKeyOffset+NextKeySize], key)
+func (s *Stream) setNextKey(key uint64) {
+	binary.LittleEndian.PutUint64((*s.page)[NextKeyOffset:NextKeyOffset+NextKeySize], key)
 }
 
 func (s *Stream) LastValueWrittenPage() uint32 {
@@ -113,7 +136,7 @@ func (s *Stream) setLastValueWrittenPos(key uint16) {
 	binary.LittleEndian.PutUint16((*s.page)[LastValueWrittenPosOffset:LastValueWrittenPosOffset+LastValueWrittenPosSize], key)
 }
 
-func (s *Stream) Add(payload []byte) (uint32, error) {
+func (s *Stream) Add(payload []byte) (uint64, error) {
 	/*
 		1. Get next write position
 		2. If no room for header, close tail page and create new one
@@ -122,13 +145,59 @@ func (s *Stream) Add(payload []byte) (uint32, error) {
 		5. Update header of last item to point to new item
 	*/
 
+	if err := s.loadHeader(); err != nil {
+		return 0, err
+	}
+	if err := s.pager.MarkDirty(s.pageNum); err != nil {
+		return 0, err
+	}
+
 	key := s.NextKey()
+	if key == math.MaxUint64 {
+		// The next key would wrap around and overwrite the index entry for key 0
+		return 0, fmt.Errorf("stream is full, all %d keys have been used", key)
+	}
+	if err := s.add(key, payload); err != nil {
+		return 0, err
+	}
+	return key, nil
+}
+
+// add writes payload to the end of the store with the given key, which must be greater than any
+// key already in the stream, and makes key+1 the next key. The header page must be loaded.
+func (s *Stream) add(key uint64, payload []byte) error {
+	item, err := s.write(key, payload)
+	if err != nil {
+		return err
+	}
+
+	// Add to index
+	if err := s.index.Insert(key, item); err != nil {
+		return err
+	}
+	// Splitting the index may have touched enough pages to evict the header
+	if err := s.loadHeader(); err != nil {
+		return err
+	}
+	if err := s.pager.MarkDirty(s.pageNum); err != nil {
+		return err
+	}
+	s.setNextKey(key + 1)
+	return nil
+}
+
+// write appends payload to the end of the store with the given key, returning where it was written.
+// It is not added to the index. The header page must be loaded.
+func (s *Stream) write(key uint64, payload []byte) (data.IndexItem, error) {
 	dataWritten := 0
 
 	curPageNum := s.StoreTailPage()
 	curPage, err := s.pager.Page(curPageNum)
 	if err != nil {
-		return 0, err
+		return data.IndexItem{}, err
+	}
+	if err := s.pager.MarkDirty(curPageNum); err != nil {
+		return data.IndexItem{}, err
 	}
 
 	curNode := NewNode(curPage)
@@ -142,7 +211,7 @@ func (s *Stream) Add(payload []byte) (uint32, error) {
 		curNode.CloseNode()
 		curPageNum, curNode, err = s.makeNewTailNode(curPageNum, curNode)
 		if err != nil {
-			return 0, err
+			return data.IndexItem{}, err
 		}
 	}
 
@@ -157,7 +226,7 @@ func (s *Stream) Add(payload []byte) (uint32, error) {
 		if bytesAvailable <= 0 {
 			curPageNum, curNode, err = s.makeNewTailNode(curPageNum, curNode)
 			if err != nil {
-				return 0, err
+				return data.IndexItem{}, err
 			}
 
 		} else {
@@ -173,9 +242,24 @@ func (s *Stream) Add(payload []byte) (uint32, error) {
 		}
 	}
 
+	// The header page may have been evicted while the payload was being written
+	if err := s.loadHeader(); err != nil {
+		return data.IndexItem{}, err
+	}
+	if err := s.pager.MarkDirty(s.pageNum); err != nil {
+		return data.IndexItem{}, err
+	}
+	s.SetStoreTailPage(curPageNum)
+
 	// Update the Next Item details of the last Item
 	lastItemPageNum := s.LastValueWrittenPage()
-	lastItemPage, _ := s.pager.Page(lastItemPageNum)
+	lastItemPage, err := s.pager.Page(lastItemPageNum)
+	if err != nil {
+		return data.IndexItem{}, err
+	}
+	if err := s.pager.MarkDirty(lastItemPageNum); err != nil {
+		return data.IndexItem{}, err
+	}
 	lastItemPos := s.LastValueWrittenPos()
 	lastItemHeader := ReadHeader(lastItemPage, lastItemPos)
 
@@ -187,15 +271,15 @@ func (s *Stream) Add(payload []byte) (uint32, error) {
 	// Update the last item details of the stream with this item
 	s.setLastValueWrittenPage(startPageNum)
 	s.setLastValueWrittenPos(startingOffset)
-
-	// Add to index
-	s.index.Insert(key, data.NewIndexItem(startPageNum, startingOffset, uint32(len(payload))))
-	s.setNextKey(key + 1)
-	return key, nil
+	return data.NewIndexItem(startPageNum, startingOffset, uint32(len(payload))), nil
 }
 
-func (s *Stream) Get(key uint32) (Record, error) {
-	items, err := s.GetFrom(key, 1)
+func (s *Stream) Get(key uint64) (Record, error) {
+	indexItem, err := s.index.Get(key)
+	if err != nil {
+		return Record{}, fmt.Errorf("key %d: %w", key, err)
+	}
+	items, err := s.readFrom(key, indexItem, 1)
 	if err != nil {
 		return Record{}, err
 	}
@@ -204,26 +288,35 @@ func (s *Stream) Get(key uint32) (Record, error) {
 
 }
 
-func (s *Stream) GetFrom(key uint32, num uint16) ([]Record, error) {
-	items := make([]Record, num)
-	indexItem := s.index.Get(key)
-
-	if cmp.Equal(indexItem, data.IndexItem{}) {
-		return nil, fmt.Errorf("key not found")
+// GetFrom returns num records starting at key, or at the first key after it if key is not in the
+// stream. Fewer than num records are returned if the stream ends first.
+func (s *Stream) GetFrom(key uint64, num uint16) ([]Record, error) {
+	firstKey, indexItem, found, err := s.index.Ceiling(key)
+	if err != nil {
+		return nil, err
 	}
+	if !found {
+		return nil, fmt.Errorf("key %d: %w", key, data.ErrKeyNotFound)
+	}
+	return s.readFrom(firstKey, indexItem, num)
+}
 
+// readFrom reads up to num records, following the store chain from the item for key. Fewer records
+// are returned if the last item in the stream is reached first.
+func (s *Stream) readFrom(key uint64, indexItem data.IndexItem, num uint16) ([]Record, error) {
+	items := make([]Record, 0, num)
 	pageNum := indexItem.PageNum
 	offset := indexItem.Offset
 
-	for i, _ := range items {
+	for len(items) < int(num) {
 		item, header, err := getItem(pageNum, offset, s, key)
 		if err != nil {
 			return nil, err
 		}
 
-		items[i] = Record{Data: item, Key: header.Key}
-		if err != nil {
-			return nil, err
+		items = append(items, Record{Data: item, Key: header.Key})
+		if isLastItem(header, pageNum, offset) {
+			break
 		}
 		pageNum = uint32(header.NextItemPageNum)
 		offset = header.NextItemOffset
@@ -232,7 +325,7 @@ func (s *Stream) GetFrom(key uint32, num uint16) ([]Record, error) {
 	return items, nil
 }
 
-func getItem(page uint32, offset uint16, s *Stream, key uint32) ([]byte, StoreItem, error) {
+func getItem(page uint32, offset uint16, s *Stream, key uint64) ([]byte, StoreItem, error) {
 	curOffset := offset
 	curPageNum := page
 
@@ -241,22 +334,34 @@ func getItem(page uint32, offset uint16, s *Stream, key uint32) ([]byte, StoreIt
 		return nil, StoreItem{}, err
 	}
 
-	header := Deserialise((*curPage)[curOffset : curOffset+14])
+	curNode := NewNode(curPage)
+	if curOffset < HeaderSize || uint32(curOffset)+StoreItemSize > uint32(curNode.usableSize()) {
+		return nil, StoreItem{}, fmt.Errorf("%w: item for key %d has offset %d outside of page %d", data.ErrCorrupt, key, curOffset, curPageNum)
+	}
+	header := Deserialise((*curPage)[curOffset : curOffset+StoreItemSize])
 
-	curOffset += 14
+	curOffset += StoreItemSize
 
 	buffer := make([]byte, header.Length)
-	curNode := NewNode(curPage)
 
 	totalNumBytesRead := uint32(0)
 
 	for totalNumBytesRead < header.Length {
-		numBytesRead, _ := curNode.Read(curOffset, header.Length-totalNumBytesRead, buffer[totalNumBytesRead:])
+		numBytesRead, err := curNode.Read(curOffset, header.Length-totalNumBytesRead, buffer[totalNumBytesRead:])
+		if err != nil {
+			return nil, StoreItem{}, err
+		}
 		totalNumBytesRead += numBytesRead
 
 		if totalNumBytesRead < header.Length {
 			nextPageNum := curNode.Next()
-			nextPage, _ := s.pager.Page(nextPageNum)
+			if nextPageNum == 0 {
+				return nil, StoreItem{}, fmt.Errorf("%w: item for key %d ends after %d of %d bytes", data.ErrCorrupt, key, totalNumBytesRead, header.Length)
+			}
+			nextPage, err := s.pager.Page(nextPageNum)
+			if err != nil {
+				return nil, StoreItem{}, err
+			}
 			curNode = NewNode(nextPage)
 			curOffset = HeaderSize
 		}
@@ -264,8 +369,62 @@ func getItem(page uint32, offset uint16, s *Stream, key uint32) ([]byte, StoreIt
 	return buffer, header, nil
 }
 
+// Pages returns the page numbers of every page the stream uses: its header page, the nodes of its
+// index and the nodes of its store
+func (s *Stream) Pages() ([]uint32, error) {
+	if err := s.loadHeader(); err != nil {
+		return nil, err
+	}
+	headPageNum, tailPageNum := s.StoreHeadPage(), s.StoreTailPage()
+	pages, err := s.index.Pages()
+	if err != nil {
+		return nil, err
+	}
+	return s.appendStorePages(append(pages, s.pageNum), headPageNum, tailPageNum)
+}
+
+// appendStorePages appends the page numbers of the store nodes from headPageNum to tailPageNum to
+// pages
+func (s *Stream) appendStorePages(pages []uint32, headPageNum uint32, tailPageNum uint32) ([]uint32, error) {
+	seen := make(map[uint32]bool)
+	for pageNum := headPageNum; ; {
+		if seen[pageNum] {
+			return nil, fmt.Errorf("%w: store page %d is in the chain more than once", data.ErrCorrupt, pageNum)
+		}
+		seen[pageNum] = true
+		pages = append(pages, pageNum)
+		if pageNum == tailPageNum {
+			return pages, nil
+		}
+
+		page, err := s.pager.Page(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		next := NewNode(page).Next()
+		if next == 0 {
+			return nil, fmt.Errorf("%w: store ends at page %d, before the tail page %d", data.ErrCorrupt, pageNum, tailPageNum)
+		}
+		pageNum = next
+	}
+}
+
+// loadHeader fetches the stream's header page again, as it may have been evicted from the pager's
+// cache since it was last used
+func (s *Stream) loadHeader() error {
+	page, err := s.pager.Page(s.pageNum)
+	if err != nil {
+		return err
+	}
+	s.page = page
+	return nil
+}
+
 func (s *Stream) makeNewTailNode(curPageNum uint32, curNode *Node) (uint32, *Node, error) {
-	newPageNum := s.pager.GetNextUnusedPageNum()
+	newPageNum, err := s.pager.AllocatePage()
+	if err != nil {
+		return 0, nil, err
+	}
 	newPage, err := s.pager.Page(newPageNum)
 	if err != nil {
 		return 0, nil, err
@@ -274,7 +433,6 @@ func (s *Stream) makeNewTailNode(curPageNum uint32, curNode *Node) (uint32, *Nod
 
 	curNode.SetNext(newPageNum)
 	newNode.SetPrevious(curPageNum)
-	s.SetStoreTailPage(newPageNum)
 
 	return newPageNum, newNode, nil
 }
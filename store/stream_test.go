@@ -2,6 +2,9 @@ package store
 
 import (
 	"bytes"
+	"errors"
+	"math"
+	"path/filepath"
 	"testing"
 
 	"github.com/gilmae/klite/data"
@@ -10,7 +13,7 @@ import (
 func TestWriteToStream(t *testing.T) {
 	pager := &data.MemoryPager{}
 
-	stream, _ := InitialiseStream(pager)
+	stream, _, _ := InitialiseStream(pager)
 
 	headPage, _ := pager.Page(stream.StoreHeadPage())
 	head := InititaliseNode(headPage)
@@ -20,13 +23,13 @@ func TestWriteToStream(t *testing.T) {
 	if head.NextFreePosition() != 12 {
 		t.Errorf("nextFreePosition is incorrect ,expected %+v, got %+v", 4092, head.NextFreePosition())
 	}
-	if head.SpaceRemaining() != 4084 {
+	if head.SpaceRemaining() != 4080 {
 		t.Errorf("space remaining is incorrect ,expected %+v, got %+v", 4, head.SpaceRemaining())
 	}
 
-	key, _ := stream.Add(make([]byte, 4070))
+	key, _ := stream.Add(make([]byte, 4062))
 
-	if head.NextFreePosition() != 4096 {
+	if head.NextFreePosition() != 4092 {
 		t.Errorf("nextFreePosition is incorrect ,expected %+v, got %+v", 4092, head.NextFreePosition())
 	}
 	if head.SpaceRemaining() != 0 {
@@ -42,8 +45,8 @@ func TestWriteToStream(t *testing.T) {
 		t.Errorf("incorrect key returned, expected %d, got %d", 0, key)
 	}
 
-	expectedHeaderBytes := []byte{0x0, 0, 0, 0, 0xE6, 0xF, 0, 0, 0x2, 0, 0, 0, 0xC, 0}
-	actualHeaderBytes := (*headPage)[12:26]
+	expectedHeaderBytes := []byte{0x0, 0, 0, 0, 0, 0, 0, 0, 0xDE, 0xF, 0, 0, 0x2, 0, 0, 0, 0xC, 0}
+	actualHeaderBytes := (*headPage)[12:30]
 
 	if !bytes.Equal(expectedHeaderBytes, actualHeaderBytes) {
 		t.Errorf("data header incorrect, expected %+v, got %+v", expectedHeaderBytes, actualHeaderBytes)
@@ -61,14 +64,14 @@ func TestWriteToStream(t *testing.T) {
 func TestValueHeaderIsAssignedNextValueDetails(t *testing.T) {
 	pager := &data.MemoryPager{}
 
-	stream, _ := InitialiseStream(pager)
+	stream, _, _ := InitialiseStream(pager)
 
 	headPage, _ := pager.Page(stream.StoreHeadPage())
 	_ = InititaliseNode(headPage)
 	indexPage, _ := pager.Page(stream.IndexPage())
 	_ = data.NewNode(indexPage)
 
-	stream.Add(make([]byte, 4055))
+	stream.Add(make([]byte, 4043))
 	stream.Add([]byte{0x1, 0x2, 0x3})
 
 	valueHeader := ReadHeader(headPage, 12)
@@ -77,47 +80,47 @@ func TestValueHeaderIsAssignedNextValueDetails(t *testing.T) {
 		t.Errorf("newItemPageNum of first value header incorrect, expected %d, got %d", stream.StoreHeadPage(), valueHeader.NextItemPageNum)
 	}
 
-	if valueHeader.NextItemOffset != 4081 { // Start from 12 + 4055 bytes + 14 for the header
-		t.Errorf("newItemOffset of first value header incorrect, expected %d, got %d", 4081, valueHeader.NextItemOffset)
+	if valueHeader.NextItemOffset != 4073 { // Start from 12 + 4043 bytes + 18 for the header
+		t.Errorf("newItemOffset of first value header incorrect, expected %d, got %d", 4073, valueHeader.NextItemOffset)
 	}
 
 	stream.Add([]byte{0x4, 0x5, 0x6})
 
-	valueHeader = ReadHeader(headPage, 4081)
+	valueHeader = ReadHeader(headPage, 4073)
 
 	if valueHeader.NextItemPageNum != stream.StoreTailPage() {
 		t.Errorf("newItemPageNum of second value header incorrect, expected %d, got %d", stream.StoreTailPage(), valueHeader.NextItemPageNum)
 	}
 
-	if valueHeader.NextItemOffset != 14 { // Start from 12 + 4055 bytes + 14 for the header
+	if valueHeader.NextItemOffset != 14 { // The second item's last 2 bytes follow the tail page's 12 byte header
 		t.Errorf("newItemOffset of second value header incorrect, expected %d, got %d", 14, valueHeader.NextItemOffset)
 	}
 }
 
 func TestWriteToStreamWithInsufficientSpace(t *testing.T) {
 	pager := &data.MemoryPager{}
-	stream, _ := InitialiseStream(pager)
+	stream, _, _ := InitialiseStream(pager)
 
 	headPage, _ := pager.Page(stream.StoreHeadPage())
 	head := NewNode(headPage)
 	indexPage, _ := pager.Page(stream.IndexPage())
 	indexRootNode := data.NewNode(indexPage)
 
-	stream.Add(make([]byte, 4055))
+	stream.Add(make([]byte, 4043))
 	stream.Add([]byte{0x1, 0x2, 0x3})
 
 	if stream.StoreHeadPage() == stream.StoreTailPage() {
 		t.Errorf("headPageNum equals tailPageNum, expected new page.")
 	}
-	if head.NextFreePosition() != 4096 {
+	if head.NextFreePosition() != 4092 {
 		t.Errorf("nextFreePosition is incorrect ,expected %+v, got %+v", 4092, head.NextFreePosition())
 	}
 	if head.SpaceRemaining() != 0 {
 		t.Errorf("space remaining is incorrect ,expected %+v, got %+v", 4, head.SpaceRemaining())
 	}
 
-	if (*headPage)[4095] != 0x1 {
-		t.Errorf("incorrect byte at end of headPage, expected %+v, got %+v", 0x1, (*headPage)[4095])
+	if (*headPage)[4091] != 0x1 {
+		t.Errorf("incorrect byte at end of headPage, expected %+v, got %+v", 0x1, (*headPage)[4091])
 	}
 
 	if (*head).Next() != stream.StoreTailPage() {
@@ -146,17 +149,17 @@ func TestReadFromStream(t *testing.T) {
 	expectedBuffer := []byte{0x1, 0x2, 0x3, 0x4}
 
 	pager := &data.MemoryPager{}
-	stream, _ := InitialiseStream(pager)
+	stream, _, _ := InitialiseStream(pager)
 
 	headPage, _ := pager.Page(stream.StoreHeadPage())
 
 	indexPage, _ := pager.Page(stream.IndexPage())
 
 	// Add the actual value to the node
-	copy((*headPage)[34:38], expectedBuffer)
+	copy((*headPage)[38:42], expectedBuffer)
 
 	// Add the value header to the node
-	copy((*headPage)[20:28], []byte{0, 0, 0, 0, 4, 0, 0, 0})
+	copy((*headPage)[20:32], []byte{0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0})
 	indexRootNode := data.NewNode(indexPage)
 
 	indexRootNode.SetNodeKey(0, 0)
@@ -177,7 +180,7 @@ func TestReadFromStreamUsingInvalidKey(t *testing.T) {
 	expectedBuffer := []byte{0x1, 0x2, 0x3, 0x4}
 
 	pager := &data.MemoryPager{}
-	stream, _ := InitialiseStream(pager)
+	stream, _, _ := InitialiseStream(pager)
 
 	headPage, _ := pager.Page(stream.StoreHeadPage())
 
@@ -190,8 +193,8 @@ func TestReadFromStreamUsingInvalidKey(t *testing.T) {
 	indexRootNode.SetNumKeys(1)
 
 	actualBuffer, err := stream.Get(2)
-	if err == nil {
-		t.Errorf("expected an error for invalid key")
+	if !errors.Is(err, data.ErrKeyNotFound) {
+		t.Errorf("expected %v, got %+v", data.ErrKeyNotFound, err)
 	}
 
 	if actualBuffer.Data != nil {
@@ -201,7 +204,7 @@ func TestReadFromStreamUsingInvalidKey(t *testing.T) {
 
 func TestReadMultiple(t *testing.T) {
 	pager := &data.MemoryPager{}
-	stream, _ := InitialiseStream(pager)
+	stream, _, _ := InitialiseStream(pager)
 
 	expectedItem1 := []byte{0x1, 0x2, 0x3}
 	expectedItem2 := []byte{0x4, 0x5, 0x6}
@@ -226,3 +229,145 @@ func TestReadMultiple(t *testing.T) {
 		t.Errorf("incorrect value received, expected %+v, got %+v", expectedItem2, items[1].Data)
 	}
 }
+
+func TestReadMultiplePastLastItem(t *testing.T) {
+	for _, test := range []struct {
+		numItems int
+		key      uint64
+		num      uint16
+	}{
+		// The only item points at itself
+		{numItems: 1, key: 0, num: 3},
+		{numItems: 3, key: 1, num: 10},
+		{numItems: 3, key: 0, num: 3},
+	} {
+		pager := &data.MemoryPager{}
+		stream, _, _ := InitialiseStream(pager)
+		for i := 0; i < test.numItems; i++ {
+			stream.Add([]byte{byte(i)})
+		}
+
+		items, err := stream.GetFrom(test.key, test.num)
+		if err != nil {
+			t.Fatalf("unexpected error, got %+v", err)
+		}
+		if expected := test.numItems - int(test.key); len(items) != expected {
+			t.Errorf("incorrect number of items from key %d of %d, expected %d, got %d", test.key, test.numItems, expected, len(items))
+		}
+		for i, item := range items {
+			if expected := test.key + uint64(i); item.Key != expected || item.Data[0] != byte(expected) {
+				t.Errorf("incorrect item %d, expected key %d, got %+v", i, expected, item)
+			}
+		}
+	}
+}
+
+func TestReadMultipleFromMissingKey(t *testing.T) {
+	memoryPager := &data.MemoryPager{}
+	// Page 0 holds the free-list header, deleting from the index needs one
+	memoryPager.Page(0)
+	stream, _, _ := InitialiseStream(data.NewFreeListPager(memoryPager, 0, 0))
+	for i := byte(0); i < 5; i++ {
+		stream.Add([]byte{i})
+	}
+	// As if key 2 had been removed by retention
+	if err := stream.index.Delete(2); err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+
+	if _, err := stream.Get(2); !errors.Is(err, data.ErrKeyNotFound) {
+		t.Errorf("expected %v, got %+v", data.ErrKeyNotFound, err)
+	}
+	items, err := stream.GetFrom(2, 2)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	for i, item := range items {
+		if expected := uint64(3 + i); item.Key != expected || item.Data[0] != byte(expected) {
+			t.Errorf("incorrect item %d, expected key %d, got %+v", i, expected, item)
+		}
+	}
+
+	// Reading from the item after the missing key still stops at the last item
+	if items, err := stream.GetFrom(2, 10); err != nil || len(items) != 2 {
+		t.Errorf("expected 2 items, got %d, %+v", len(items), err)
+	}
+
+	if _, err := stream.GetFrom(5, 1); !errors.Is(err, data.ErrKeyNotFound) {
+		t.Errorf("expected %v, got %+v", data.ErrKeyNotFound, err)
+	}
+}
+
+func TestAddToReadOnlyStream(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	pager, _ := data.Open(filename, data.Options{})
+	_, streamPageNum, _ := InitialiseStream(pager)
+	pager.Close()
+
+	pager, err := data.Open(filename, data.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	defer pager.Close()
+
+	stream, err := NewStream(pager, streamPageNum)
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	if _, err := stream.Add([]byte{0x1}); err != data.ErrReadOnly {
+		t.Errorf("expected %v, got %+v", data.ErrReadOnly, err)
+	}
+}
+
+func TestAddWhenKeysExhausted(t *testing.T) {
+	pager := &data.MemoryPager{}
+	stream, _, _ := InitialiseStream(pager)
+	stream.setNextKey(math.MaxUint64 - 1)
+
+	if key, err := stream.Add([]byte{0x1}); err != nil || key != math.MaxUint64-1 {
+		t.Errorf("unexpected result adding last key, got %d, %+v", key, err)
+	}
+	if _, err := stream.Add([]byte{0x2}); err == nil {
+		t.Errorf("expected an error once every key has been used")
+	}
+}
+
+func TestReadItemOutsidePage(t *testing.T) {
+	pager := &data.MemoryPager{}
+	stream, _, _ := InitialiseStream(pager)
+	stream.Add([]byte{0x1, 0x2, 0x3})
+
+	// Point the index at an offset that cannot hold an item header
+	indexPage, _ := pager.Page(stream.IndexPage())
+	indexRootNode := data.NewNode(indexPage)
+	indexRootNode.SetNodeValue(0, data.IndexItem{PageNum: stream.StoreHeadPage(), Offset: 4090, Length: 3})
+
+	if _, err := stream.Get(0); !errors.Is(err, data.ErrCorrupt) {
+		t.Errorf("expected %v, got %+v", data.ErrCorrupt, err)
+	}
+}
+
+func TestStreamPages(t *testing.T) {
+	pager, stream := newFreeListStream(t, 500)
+
+	pages, err := stream.Pages()
+	if err != nil {
+		t.Fatalf("unexpected error, got %+v", err)
+	}
+	// Nothing has been freed, so every page but the free-list header's belongs to the stream
+	seen := map[uint32]bool{}
+	for _, pageNum := range pages {
+		if seen[pageNum] {
+			t.Errorf("page %d returned more than once", pageNum)
+		}
+		seen[pageNum] = true
+	}
+	for pageNum := uint32(1); pageNum < pager.GetNextUnusedPageNum(); pageNum++ {
+		if !seen[pageNum] {
+			t.Errorf("page %d is missing", pageNum)
+		}
+	}
+	if len(pages) != int(pager.GetNextUnusedPageNum())-1 {
+		t.Errorf("incorrect number of pages, expected %d, got %d", pager.GetNextUnusedPageNum()-1, len(pages))
+	}
+}
@@ -10,8 +10,10 @@ const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
 
-	SELECT = "SELECT"
-	INSERT = "INSERT"
+	SELECT  = "SELECT"
+	INSERT  = "INSERT"
+	REINDEX = "REINDEX"
+	VACUUM  = "VACUUM"
 
 	SEMICOLON = "SEMICOLON"
 	COMMA     = "COMMA"
@@ -27,9 +29,11 @@ const (
 )
 
 var keywords = map[string]TokenType{
-	"add":   INSERT,
-	"get":   SELECT,
-	"after": AFTER,
+	"add":     INSERT,
+	"get":     SELECT,
+	"after":   AFTER,
+	"reindex": REINDEX,
+	"vacuum":  VACUUM,
 }
 
 // LookupIdent checks if an identifier is a keyword or a user identifier